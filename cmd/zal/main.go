@@ -1,8 +1,10 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"os"
@@ -35,14 +37,108 @@ func main() {
 	hostsFile := send.Flag("hosts-path", "Path to resolver to host mapping file.").String()
 	keyPrefix := send.Flag("key-prefix", "Prefix to add to the trapper item key").Default("prometheus").String()
 	defaultHost := send.Flag("default-host", "default host to send alerts to").Default("prometheus").String()
+	sendConfig := send.Flag("config-path", "Path to provisioner hosts config file, used for the severity mapping and item keys.").String()
+	alertmanagerURL := send.Flag("alertmanager-url", "Alertmanager URL, its silences are turned into Zabbix maintenances for the silenced hosts.").Envar("ALERTMANAGER_URL").String()
+	silenceHostLabel := send.Flag("silence-host-label", "Alert label holding the Zabbix host name in the silence matchers.").Default("host").String()
+	silenceInterval := send.Flag("silence-sync-interval", "How often to synchronize the silences into maintenances.").Default("1m").Duration()
+	closeResolved := send.Flag("close-resolved", "Close the Zabbix problems of resolved alerts whose triggers allow manual close, adding a message with the resolution.").Bool()
+	sendAPIURL := send.Flag("zabbix-api-url", "Zabbix json rpc url, used with --alertmanager-url and --close-resolved.").Envar("ZABBIX_API_URL").Default("http://127.0.0.1/zabbix/api_jsonrpc.php").String()
+	sendUser := send.Flag("user", "Zabbix json rpc user, used with --alertmanager-url and --close-resolved without --token-file.").Envar("ZABBIX_USER").String()
+	sendPassword := send.Flag("password", "Zabbix json rpc password, used with --alertmanager-url and --close-resolved without --token-file.").Envar("ZABBIX_PASSWORD").String()
+	sendTokenFile := send.Flag("token-file", "File with a Zabbix API token used instead of the user and password, Zabbix 5.4 or newer.").Envar("ZABBIX_TOKEN_FILE").String()
 
 	prov := app.Command("prov", "Reads Prometheus Alerting rules and converts them into Zabbix Triggers.")
 	provConfig := prov.Flag("config-path", "Path to provisioner hosts config file.").Required().String()
-	provUser := prov.Flag("user", "Zabbix json rpc user.").Envar("ZABBIX_USER").Required().String()
-	provPassword := prov.Flag("password", "Zabbix json rpc password.").Envar("ZABBIX_PASSWORD").Required().String()
+	provUser := prov.Flag("user", "Zabbix json rpc user, required without --token-file.").Envar("ZABBIX_USER").String()
+	provPassword := prov.Flag("password", "Zabbix json rpc password, required without --token-file.").Envar("ZABBIX_PASSWORD").String()
+	provTokenFile := prov.Flag("token-file", "File with a Zabbix API token used instead of the user and password, Zabbix 5.4 or newer.").Envar("ZABBIX_TOKEN_FILE").String()
 	provURL := prov.Flag("url", "Zabbix json rpc url.").Envar("ZABBIX_URL").Default("http://127.0.0.1/zabbix/api_jsonrpc.php").String()
 	provKeyPrefix := prov.Flag("key-prefix", "Prefix to add to the trapper item key.").Default("prometheus").String()
 	prometheusURL := prov.Flag("prometheus-url", "Prometheus URL.").Default("").String()
+	provInterval := prov.Flag("interval", "Run the provisioning continuously with the given interval, 0 runs it once.").Default("0s").Duration()
+	provCheckInterval := prov.Flag("rules-check-interval", "How often to check the rules directories for changes in continuous mode, 0 disables it.").Default("30s").Duration()
+	provAddr := prov.Flag("addr", "Server address for metrics and health endpoints in continuous mode.").Default("0.0.0.0:9096").String()
+	orphanHosts := prov.Flag("orphan-hosts", "What to do with hosts which are not Prometheus targets anymore: keep, disable, delete or tag.").Default(string(provisioner.OrphanKeep)).Enum(provisioner.OrphanPolicies...)
+	orphanTemplates := prov.Flag("orphan-templates", "What to do with templates in the template host groups which are not configured anymore: keep or delete.").Default(string(provisioner.OrphanKeep)).Enum(provisioner.OrphanPolicies...)
+	orphanItems := prov.Flag("orphan-items", "What to do with template items which are not rules anymore: keep, disable or delete.").Default(string(provisioner.OrphanDelete)).Enum(provisioner.OrphanPolicies...)
+	orphanTriggers := prov.Flag("orphan-triggers", "What to do with template triggers which are not rules anymore: keep, disable or delete.").Default(string(provisioner.OrphanDelete)).Enum(provisioner.OrphanPolicies...)
+	orphanGracePeriod := prov.Flag("orphan-grace-period", "How long objects have to be orphaned before they are deleted, only hosts keep it across runs, the other objects require --interval.").Default("0s").Duration()
+	maxRemovalPercent := prov.Flag("max-removal-percent", "Abort when a run would delete or disable more than this percentage of the existing objects of a type, 0 disables the limit.").Default("50").Float64()
+	provAdopt := prov.Flag("adopt", "Take over existing hosts, items and triggers matching the provisioned ones which aren't marked as managed by zal.").Bool()
+	provConcurrency := prov.Flag("zabbix-concurrency", "How many Zabbix API reads may run concurrently.").Default("1").Int()
+	provTimeout := prov.Flag("zabbix-timeout", "Timeout of every Zabbix API request, 0 disables it.").Default("30s").Duration()
+	provRetries := prov.Flag("zabbix-retries", "How many times to retry Zabbix API reads failing with transport errors, 5xx or 429 responses, 0 disables the retries.").Default("3").Int()
+	provOnFailure := prov.Flag("on-failure", "What to do when the changes of a template or host fail and are rolled back: stop or continue with the next one.").Default(string(provisioner.FailureStop)).Enum(string(provisioner.FailureStop), string(provisioner.FailureContinue))
+
+	snapshot := app.Command("snapshot", "Exports the Zabbix objects managed by the provisioner to a YAML or JSON file.")
+	snapshotConfig := snapshot.Flag("config-path", "Path to provisioner hosts config file.").Required().String()
+	snapshotUser := snapshot.Flag("user", "Zabbix json rpc user, required without --token-file.").Envar("ZABBIX_USER").String()
+	snapshotPassword := snapshot.Flag("password", "Zabbix json rpc password, required without --token-file.").Envar("ZABBIX_PASSWORD").String()
+	snapshotTokenFile := snapshot.Flag("token-file", "File with a Zabbix API token used instead of the user and password, Zabbix 5.4 or newer.").Envar("ZABBIX_TOKEN_FILE").String()
+	snapshotURL := snapshot.Flag("url", "Zabbix json rpc url.").Envar("ZABBIX_URL").Default("http://127.0.0.1/zabbix/api_jsonrpc.php").String()
+	snapshotOutput := snapshot.Flag("output", "Path to the snapshot file, JSON when it ends with .json, YAML otherwise.").Short('o').Required().String()
+
+	restore := app.Command("restore", "Applies a snapshot to Zabbix, the managed objects are changed to match it.")
+	restoreConfig := restore.Flag("config-path", "Path to provisioner hosts config file.").Required().String()
+	restoreUser := restore.Flag("user", "Zabbix json rpc user, required without --token-file.").Envar("ZABBIX_USER").String()
+	restorePassword := restore.Flag("password", "Zabbix json rpc password, required without --token-file.").Envar("ZABBIX_PASSWORD").String()
+	restoreTokenFile := restore.Flag("token-file", "File with a Zabbix API token used instead of the user and password, Zabbix 5.4 or newer.").Envar("ZABBIX_TOKEN_FILE").String()
+	restoreURL := restore.Flag("url", "Zabbix json rpc url.").Envar("ZABBIX_URL").Default("http://127.0.0.1/zabbix/api_jsonrpc.php").String()
+	restoreOrphanHosts := restore.Flag("orphan-hosts", "What to do with managed hosts missing in the snapshot: keep, disable, delete or tag.").Default(string(provisioner.OrphanKeep)).Enum(provisioner.OrphanPolicies...)
+	restoreOrphanTemplates := restore.Flag("orphan-templates", "What to do with templates in the template host groups missing in the snapshot: keep or delete.").Default(string(provisioner.OrphanKeep)).Enum(provisioner.OrphanPolicies...)
+	restoreOrphanItems := restore.Flag("orphan-items", "What to do with managed template items missing in the snapshot: keep, disable or delete.").Default(string(provisioner.OrphanDelete)).Enum(provisioner.OrphanPolicies...)
+	restoreOrphanTriggers := restore.Flag("orphan-triggers", "What to do with managed template triggers missing in the snapshot: keep, disable or delete.").Default(string(provisioner.OrphanDelete)).Enum(provisioner.OrphanPolicies...)
+	restoreMaxRemovalPercent := restore.Flag("max-removal-percent", "Abort when the restore would delete or disable more than this percentage of the existing objects of a type, 0 disables the limit.").Default("50").Float64()
+	restoreInput := restore.Arg("snapshot", "Path to the snapshot file.").Required().ExistingFile()
+
+	validate := app.Command("validate", "Checks the provisioner config and its rule files without calling Zabbix, exits with 1 on problems.")
+	validateConfig := validate.Flag("config-path", "Path to provisioner hosts config file.").Required().String()
+	validateKeyPrefix := validate.Flag("key-prefix", "Prefix to add to the trapper item key.").Default("prometheus").String()
+	validateOffline := validate.Flag("offline", "Don't fetch the rules of rulesUrls.").Bool()
+
+	ackSync := app.Command("ack-sync", "Silences in Alertmanager the alerts of the acknowledged Zabbix problems of the triggers managed by zal.")
+	ackAlertmanagerURL := ackSync.Flag("alertmanager-url", "Alertmanager URL.").Envar("ALERTMANAGER_URL").Required().String()
+	ackUser := ackSync.Flag("user", "Zabbix json rpc user, required without --token-file.").Envar("ZABBIX_USER").String()
+	ackPassword := ackSync.Flag("password", "Zabbix json rpc password, required without --token-file.").Envar("ZABBIX_PASSWORD").String()
+	ackTokenFile := ackSync.Flag("token-file", "File with a Zabbix API token used instead of the user and password, Zabbix 5.4 or newer.").Envar("ZABBIX_TOKEN_FILE").String()
+	ackURL := ackSync.Flag("url", "Zabbix json rpc url.").Envar("ZABBIX_URL").Default("http://127.0.0.1/zabbix/api_jsonrpc.php").String()
+	ackInterval := ackSync.Flag("interval", "Run the synchronization continuously with the given interval, 0 runs it once.").Default("1m").Duration()
+	ackDuration := ackSync.Flag("silence-duration", "Duration of the silences, they are extended while the problems stay open.").Default("1h").Duration()
+	ackHostLabel := ackSync.Flag("host-label", "Alert label matched with the Zabbix host of the problem, none when empty.").String()
+	ackTagLabels := ackSync.Flag("tag-label", "Problem tag matched with an alert label, as tag or tag=label, repeatable.").Strings()
+	ackAddr := ackSync.Flag("addr", "Server address for metrics in continuous mode.").Default("0.0.0.0:9097").String()
+
+	forward := app.Command("forward", "Forwards the open Zabbix problems to Alertmanager as alerts and resolves them when the problems recover.")
+	fwdAlertmanagerURL := forward.Flag("alertmanager-url", "Alertmanager URL.").Envar("ALERTMANAGER_URL").Required().String()
+	fwdUser := forward.Flag("user", "Zabbix json rpc user, required without --token-file.").Envar("ZABBIX_USER").String()
+	fwdPassword := forward.Flag("password", "Zabbix json rpc password, required without --token-file.").Envar("ZABBIX_PASSWORD").String()
+	fwdTokenFile := forward.Flag("token-file", "File with a Zabbix API token used instead of the user and password, Zabbix 5.4 or newer.").Envar("ZABBIX_TOKEN_FILE").String()
+	fwdURL := forward.Flag("url", "Zabbix json rpc url.").Envar("ZABBIX_URL").Default("http://127.0.0.1/zabbix/api_jsonrpc.php").String()
+	fwdFrontendURL := forward.Flag("frontend-url", "Zabbix frontend URL used for the links of the alerts, derived from --url by default.").String()
+	fwdInterval := forward.Flag("interval", "Forward the problems continuously with the given interval, 0 runs it once.").Default("30s").Duration()
+	fwdResolveTimeout := forward.Flag("resolve-timeout", "How long the alerts fire without being pushed again, has to be longer than the interval.").Default("5m").Duration()
+	fwdMinSeverity := forward.Flag("min-severity", "Lowest severity of the forwarded problems.").Default("not_classified").Enum("not_classified", "information", "warning", "average", "high", "disaster")
+	fwdIncludeManaged := forward.Flag("include-managed", "Forward the problems of the triggers managed by zal too, which already come from Alertmanager.").Bool()
+	fwdWebhook := forward.Flag("webhook", "Receive the events of the Zabbix webhook media type on /webhook, --interval 0 disables the polling then.").Bool()
+	fwdWebhookTokenFile := forward.Flag("webhook-token-file", "File with the bearer token required from the webhook media type, required with --webhook.").Envar("ZAL_WEBHOOK_TOKEN_FILE").String()
+	fwdAddr := forward.Flag("addr", "Server address for metrics and the webhook in continuous mode.").Default("0.0.0.0:9098").String()
+
+	mediatype := app.Command("mediatype", "Zabbix media types of zal.")
+	mediatypeExport := mediatype.Command("export", "Writes the webhook media type posting the trigger events to zal forward --webhook, importable by Zabbix 5.4 or newer.")
+	mediatypeURL := mediatypeExport.Flag("url", "URL of the webhook of zal forward.").Default("http://127.0.0.1:9098/webhook").String()
+	mediatypeTokenFile := mediatypeExport.Flag("webhook-token-file", "File with the bearer token of the webhook of zal forward.").Envar("ZAL_WEBHOOK_TOKEN_FILE").String()
+	mediatypeName := mediatypeExport.Flag("name", "Name of the media type.").Default(zabbixsvc.DefaultMediaTypeName).String()
+	mediatypeFormat := mediatypeExport.Flag("format", "Format of the export.").Default("yaml").Enum("yaml", "json")
+	mediatypeOutput := mediatypeExport.Flag("output", "Path to the export file, the standard output by default.").Short('o').String()
+
+	exporter := app.Command("exporter", "Exposes the open Zabbix problems, the trigger values and the host availability as Prometheus metrics.")
+	expUser := exporter.Flag("user", "Zabbix json rpc user, required without --token-file.").Envar("ZABBIX_USER").String()
+	expPassword := exporter.Flag("password", "Zabbix json rpc password, required without --token-file.").Envar("ZABBIX_PASSWORD").String()
+	expTokenFile := exporter.Flag("token-file", "File with a Zabbix API token used instead of the user and password, Zabbix 5.4 or newer.").Envar("ZABBIX_TOKEN_FILE").String()
+	expURL := exporter.Flag("url", "Zabbix json rpc url.").Envar("ZABBIX_URL").Default("http://127.0.0.1/zabbix/api_jsonrpc.php").String()
+	expInterval := exporter.Flag("interval", "Interval of the reads of the problems, triggers and hosts.").Default("1m").Duration()
+	expTagLabels := exporter.Flag("tag-label", "Problem tag added as a label to zabbix_problem, repeatable.").Strings()
+	expAddr := exporter.Flag("addr", "Server address for metrics.").Default("0.0.0.0:9099").String()
 
 	test := app.Command("test", "Test different things")
 
@@ -90,11 +186,57 @@ func main() {
 			}
 		}
 
+		hostConfigs := make(map[string]provisioner.HostConfig)
+
+		if sendConfig != nil && *sendConfig != "" {
+			cfg, err := provisioner.LoadHostConfigFromFile(*sendConfig)
+			if err != nil {
+				log.Fatal(err)
+			}
+			hostConfigs, err = zabbixsvc.HostConfigsByHost(cfg)
+			if err != nil {
+				log.Fatal(err)
+			}
+			sendHosts := []string{*defaultHost}
+			for _, host := range hosts {
+				sendHosts = append(sendHosts, host)
+			}
+			for _, host := range sendHosts {
+				if _, ok := hostConfigs[host]; !ok {
+					log.Warnf("host %s isn't listed in the hosts of any config in %s, its alerts use the default item keys", host, *sendConfig)
+				}
+			}
+		}
+
 		h := &zabbixsvc.JSONHandler{
 			Sender:      s,
 			KeyPrefix:   *keyPrefix,
 			DefaultHost: *defaultHost,
 			Hosts:       hosts,
+			HostConfigs: hostConfigs,
+		}
+
+		if *alertmanagerURL != "" || *closeResolved {
+			if *sendTokenFile == "" && (*sendUser == "" || *sendPassword == "") {
+				log.Fatal("error --user and --password or --token-file are required with --alertmanager-url and --close-resolved")
+			}
+			api, err := provisioner.NewZabbixAPI(*sendAPIURL, *sendUser, *sendPassword, provisioner.Options{TokenFile: *sendTokenFile})
+			if err != nil {
+				log.Fatalf("error failed to create zabbix api client: %s", err)
+			}
+
+			if *closeResolved {
+				h.Closer = &zabbixsvc.ProblemCloser{API: api}
+			}
+			if *alertmanagerURL != "" {
+				silences := &zabbixsvc.SilenceSync{
+					Alertmanager: zabbixsvc.NewAlertmanagerClient(*alertmanagerURL),
+					API:          api,
+					HostLabel:    *silenceHostLabel,
+				}
+				log.Infof("synchronizing the silences of %s into maintenances every %s", *alertmanagerURL, *silenceInterval)
+				go silences.Run(*silenceInterval, make(chan struct{}))
+			}
 		}
 
 		http.Handle("/metrics", promhttp.Handler())
@@ -106,20 +248,327 @@ func main() {
 		}
 
 	case prov.FullCommand():
+		if *provTokenFile == "" && (*provUser == "" || *provPassword == "") {
+			log.Fatal("error --user and --password or --token-file are required")
+		}
+
 		cfg, err := provisioner.LoadHostConfigFromFile(*provConfig)
 		if err != nil {
 			log.Fatal(err)
 		}
 		log.Infof("loaded hosts configuration from '%s'", *provConfig)
 
-		prov, err := provisioner.New(*prometheusURL, *provKeyPrefix, *provURL, *provUser, *provPassword, cfg)
+		retries := *provRetries
+		if retries == 0 {
+			// the provisioner keeps the default of the client for 0
+			retries = -1
+		}
+		options := provisioner.Options{
+			Cleanup: provisioner.CleanupConfig{
+				Hosts:             provisioner.OrphanPolicy(*orphanHosts),
+				Templates:         provisioner.OrphanPolicy(*orphanTemplates),
+				Items:             provisioner.OrphanPolicy(*orphanItems),
+				Triggers:          provisioner.OrphanPolicy(*orphanTriggers),
+				GracePeriod:       *orphanGracePeriod,
+				MaxRemovalPercent: *maxRemovalPercent,
+			},
+			Adopt:       *provAdopt,
+			Concurrency: *provConcurrency,
+			Timeout:     *provTimeout,
+			Retries:     retries,
+			OnFailure:   provisioner.FailurePolicy(*provOnFailure),
+			TokenFile:   *provTokenFile,
+		}
+
+		if *provInterval == 0 {
+			if err := options.Cleanup.WithDefaults().ValidateOnce(); err != nil {
+				log.Fatalf("error %s, set --interval", err)
+			}
+		}
+
+		prov, err := provisioner.New(*prometheusURL, *provKeyPrefix, *provURL, *provUser, *provPassword, cfg, options)
+		if err != nil {
+			log.Fatalf("error failed to create provisioner: %s", err)
+		}
+
+		defer func() {
+			if err := prov.Logout(); err != nil {
+				log.Warn(err)
+			}
+		}()
+
+		if *provInterval == 0 {
+			if err := prov.Run(); err != nil {
+				log.Errorf("error provisioning zabbix items: %s", err)
+				prov.Logout()
+				os.Exit(1)
+			}
+			return
+		}
+
+		http.Handle("/metrics", promhttp.Handler())
+		http.HandleFunc("/-/healthy", prov.HandleHealthy)
+		http.HandleFunc("/-/ready", prov.HandleReady)
+		go func() {
+			log.Info("Zabbix provisioner started, listening on ", *provAddr)
+			if err := http.ListenAndServe(*provAddr, nil); err != nil {
+				log.Fatal(err)
+			}
+		}()
+
+		stop := make(chan struct{})
+		go func() {
+			if err := interrupt(log.StandardLogger(), nil); err == nil {
+				close(stop)
+			}
+		}()
+
+		prov.RunEvery(*provInterval, *provCheckInterval, stop)
+	case snapshot.FullCommand():
+		if *snapshotTokenFile == "" && (*snapshotUser == "" || *snapshotPassword == "") {
+			log.Fatal("error --user and --password or --token-file are required")
+		}
+		cfg, err := provisioner.LoadHostConfigFromFile(*snapshotConfig)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		prov, err := provisioner.New("", "", *snapshotURL, *snapshotUser, *snapshotPassword, cfg, provisioner.Options{TokenFile: *snapshotTokenFile})
+		if err != nil {
+			log.Fatalf("error failed to create provisioner: %s", err)
+		}
+
+		s, err := prov.Snapshot()
+		if err != nil {
+			log.Fatalf("error taking snapshot: %s", err)
+		}
+		if err := provisioner.WriteSnapshotToFile(*snapshotOutput, s); err != nil {
+			log.Fatal(err)
+		}
+		log.Infof("snapshot written to '%s'", *snapshotOutput)
+
+	case restore.FullCommand():
+		if *restoreTokenFile == "" && (*restoreUser == "" || *restorePassword == "") {
+			log.Fatal("error --user and --password or --token-file are required")
+		}
+		cfg, err := provisioner.LoadHostConfigFromFile(*restoreConfig)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		s, err := provisioner.LoadSnapshotFromFile(*restoreInput)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		options := provisioner.Options{
+			Cleanup: provisioner.CleanupConfig{
+				Hosts:             provisioner.OrphanPolicy(*restoreOrphanHosts),
+				Templates:         provisioner.OrphanPolicy(*restoreOrphanTemplates),
+				Items:             provisioner.OrphanPolicy(*restoreOrphanItems),
+				Triggers:          provisioner.OrphanPolicy(*restoreOrphanTriggers),
+				MaxRemovalPercent: *restoreMaxRemovalPercent,
+			},
+			TokenFile: *restoreTokenFile,
+		}
+		prov, err := provisioner.New("", "", *restoreURL, *restoreUser, *restorePassword, cfg, options)
 		if err != nil {
 			log.Fatalf("error failed to create provisioner: %s", err)
 		}
 
-		if err := prov.Run(); err != nil {
-			log.Fatalf("error provisioning zabbix items: %s", err)
+		if err := prov.Restore(s); err != nil {
+			log.Fatalf("error restoring snapshot: %s", err)
 		}
+		log.Infof("snapshot '%s' taken at %s restored", *restoreInput, s.CreatedAt)
+
+	case validate.FullCommand():
+		problems := provisioner.ValidateConfigFile(*validateConfig, *validateKeyPrefix, *validateOffline)
+		for _, problem := range problems {
+			fmt.Println(problem)
+		}
+		if len(problems) != 0 {
+			os.Exit(1)
+		}
+		log.Infof("config '%s' is valid", *validateConfig)
+
+	case ackSync.FullCommand():
+		if *ackTokenFile == "" && (*ackUser == "" || *ackPassword == "") {
+			log.Fatal("error --user and --password or --token-file are required")
+		}
+		tagLabels := map[string]string{}
+		for _, tagLabel := range *ackTagLabels {
+			parts := strings.SplitN(tagLabel, "=", 2)
+			tagLabels[parts[0]] = parts[len(parts)-1]
+		}
+
+		api, err := provisioner.NewZabbixAPI(*ackURL, *ackUser, *ackPassword, provisioner.Options{TokenFile: *ackTokenFile})
+		if err != nil {
+			log.Fatalf("error failed to create zabbix api client: %s", err)
+		}
+		s := &zabbixsvc.AckSync{
+			API:          api,
+			Alertmanager: zabbixsvc.NewAlertmanagerClient(*ackAlertmanagerURL),
+			HostLabel:    *ackHostLabel,
+			TagLabels:    tagLabels,
+			Duration:     *ackDuration,
+		}
+
+		if *ackInterval == 0 {
+			if err := s.Sync(context.Background()); err != nil {
+				log.Fatalf("error synchronizing the acknowledged problems: %s", err)
+			}
+			return
+		}
+
+		http.Handle("/metrics", promhttp.Handler())
+		go func() {
+			log.Info("Zabbix acknowledgement sync started, listening on ", *ackAddr)
+			if err := http.ListenAndServe(*ackAddr, nil); err != nil {
+				log.Fatal(err)
+			}
+		}()
+
+		stop := make(chan struct{})
+		go func() {
+			if err := interrupt(log.StandardLogger(), nil); err == nil {
+				close(stop)
+			}
+		}()
+
+		s.Run(*ackInterval, stop)
+
+	case forward.FullCommand():
+		poll := !*fwdWebhook || *fwdInterval != 0
+		if poll && *fwdTokenFile == "" && (*fwdUser == "" || *fwdPassword == "") {
+			log.Fatal("error --user and --password or --token-file are required")
+		}
+		if *fwdWebhook && *fwdWebhookTokenFile == "" {
+			log.Fatal("error --webhook-token-file is required with --webhook")
+		}
+		if *fwdInterval != 0 && *fwdResolveTimeout <= *fwdInterval {
+			log.Fatal("error --resolve-timeout has to be longer than --interval")
+		}
+		frontendURL := *fwdFrontendURL
+		if frontendURL == "" {
+			frontendURL = strings.TrimSuffix(*fwdURL, "/api_jsonrpc.php")
+		}
+		minSeverity, _ := provisioner.ParseZabbixPriority(*fwdMinSeverity)
+
+		f := &zabbixsvc.Forwarder{
+			Alertmanager:   zabbixsvc.NewAlertmanagerClient(*fwdAlertmanagerURL),
+			FrontendURL:    frontendURL,
+			MinSeverity:    minSeverity,
+			IncludeManaged: *fwdIncludeManaged,
+			ResolveTimeout: *fwdResolveTimeout,
+			NoPoll:         !poll,
+		}
+		if *fwdWebhook {
+			token, err := readWebhookToken(*fwdWebhookTokenFile)
+			if err != nil {
+				log.Fatalf("error %s", err)
+			}
+			f.WebhookToken = token
+		}
+		if poll {
+			api, err := provisioner.NewZabbixAPI(*fwdURL, *fwdUser, *fwdPassword, provisioner.Options{TokenFile: *fwdTokenFile})
+			if err != nil {
+				log.Fatalf("error failed to create zabbix api client: %s", err)
+			}
+			f.API = api
+		}
+
+		if *fwdInterval == 0 && !*fwdWebhook {
+			if err := f.Forward(context.Background()); err != nil {
+				log.Fatalf("error forwarding the problems: %s", err)
+			}
+			return
+		}
+
+		http.Handle("/metrics", promhttp.Handler())
+		if *fwdWebhook {
+			http.HandleFunc("/webhook", f.HandleWebhook)
+		}
+		go func() {
+			log.Info("Zabbix problem forwarder started, listening on ", *fwdAddr)
+			if err := http.ListenAndServe(*fwdAddr, nil); err != nil {
+				log.Fatal(err)
+			}
+		}()
+
+		stop := make(chan struct{})
+		go func() {
+			if err := interrupt(log.StandardLogger(), nil); err == nil {
+				close(stop)
+			}
+		}()
+
+		interval := *fwdInterval
+		if !poll {
+			// the alerts of the webhook are pushed again well before they resolve
+			interval = *fwdResolveTimeout / 3
+		}
+		f.Run(interval, stop)
+
+	case mediatypeExport.FullCommand():
+		var token string
+		if *mediatypeTokenFile != "" {
+			var err error
+			if token, err = readWebhookToken(*mediatypeTokenFile); err != nil {
+				log.Fatalf("error %s", err)
+			}
+		}
+		export := zabbixsvc.WebhookMediaType(*mediatypeName, *mediatypeURL, token)
+		var out []byte
+		var err error
+		if *mediatypeFormat == "json" {
+			out, err = export.JSON()
+		} else {
+			out, err = export.YAML()
+		}
+		if err != nil {
+			log.Fatalf("error encoding the media type: %s", err)
+		}
+		if *mediatypeOutput == "" {
+			os.Stdout.Write(out)
+			return
+		}
+		if err := ioutil.WriteFile(*mediatypeOutput, out, 0644); err != nil {
+			log.Fatalf("error writing the media type: %s", err)
+		}
+		log.Infof("media type written to '%s'", *mediatypeOutput)
+
+	case exporter.FullCommand():
+		if *expTokenFile == "" && (*expUser == "" || *expPassword == "") {
+			log.Fatal("error --user and --password or --token-file are required")
+		}
+		if *expInterval <= 0 {
+			log.Fatal("error --interval has to be positive")
+		}
+
+		api, err := provisioner.NewZabbixAPI(*expURL, *expUser, *expPassword, provisioner.Options{TokenFile: *expTokenFile})
+		if err != nil {
+			log.Fatalf("error failed to create zabbix api client: %s", err)
+		}
+		e := zabbixsvc.NewExporter(api, *expTagLabels)
+		prometheus.MustRegister(e)
+
+		http.Handle("/metrics", promhttp.Handler())
+		go func() {
+			log.Info("Zabbix exporter started, listening on ", *expAddr)
+			if err := http.ListenAndServe(*expAddr, nil); err != nil {
+				log.Fatal(err)
+			}
+		}()
+
+		stop := make(chan struct{})
+		go func() {
+			if err := interrupt(log.StandardLogger(), nil); err == nil {
+				close(stop)
+			}
+		}()
+
+		e.Run(*expInterval, stop)
+
 	case test.FullCommand():
 		//get targets from prom
 		log.Infof("in testing")
@@ -205,7 +654,7 @@ func main() {
 			targTags[targname] = []string{"Prometheus", v.Labels.Job, v.Labels.Group}
 			// log.Infof("%v\n", v.Labels.Instance[:strings.LastIndex(v.Labels.Instance, ":")])
 		}
-		log.Infof("targets list: %v", targets, targTags)
+		log.Infof("targets list: %v, tags: %v", targets, targTags)
 		//Create hosts in zabbix
 		// vars
 		url := "http://51.15.213.9:8144/api_jsonrpc.php"
@@ -285,12 +734,25 @@ func main() {
 	}
 }
 
-func interrupt(logger log.Logger, cancel <-chan struct{}) error {
+//readWebhookToken reads the bearer token of the webhook, it can't be empty
+func readWebhookToken(filename string) (string, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return "", fmt.Errorf("can't read the webhook token file %s: %v", filename, err)
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("the webhook token file %s is empty", filename)
+	}
+	return token, nil
+}
+
+func interrupt(logger *log.Logger, cancel <-chan struct{}) error {
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
 	select {
 	case s := <-c:
-		log.Info("caught signal. Exiting.", "signal", s)
+		logger.Infof("caught signal %s, exiting", s)
 		return nil
 	case <-cancel:
 		return errors.New("canceled")
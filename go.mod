@@ -5,7 +5,6 @@ go 1.13
 require (
 	github.com/Bowery/prompt v0.0.0-20190916142128-fa8279994f75 // indirect
 	github.com/dchest/safefile v0.0.0-20151022103144-855e8d98f185 // indirect
-	github.com/devopyio/zabbix-alertmanager v1.2.2
 	github.com/google/shlex v0.0.0-20191202100458-e7afc7fbc510 // indirect
 	github.com/kardianos/govendor v1.0.9 // indirect
 	github.com/pkg/errors v0.9.1
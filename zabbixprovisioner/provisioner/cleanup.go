@@ -0,0 +1,446 @@
+package provisioner
+
+import (
+	"time"
+
+	zabbix "github.com/neogan74/zabbix-alertmanager/zabbixprovisioner/zabbixclient"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+//OrphanPolicy what to do with Zabbix objects which are not provisioned anymore
+type OrphanPolicy string
+
+//Orphan policies
+const (
+	// OrphanKeep leaves the object untouched
+	OrphanKeep OrphanPolicy = "keep"
+	// OrphanDisable disables the object (hosts, items and triggers)
+	OrphanDisable OrphanPolicy = "disable"
+	// OrphanDelete deletes the object once it has been orphaned for the grace period
+	OrphanDelete OrphanPolicy = "delete"
+	// OrphanTag adds the StaleTag tag to the object (hosts)
+	OrphanTag OrphanPolicy = "tag"
+)
+
+//StaleTag tag marking orphaned hosts, the value is the time since when the host is orphaned
+const StaleTag = "zal_stale_since"
+
+//OrphanPolicies lists the policies for the command line flags
+var OrphanPolicies = []string{string(OrphanKeep), string(OrphanDisable), string(OrphanDelete), string(OrphanTag)}
+
+//CleanupConfig configures how orphaned Zabbix objects are handled.
+// Orphans are hosts which are not targets anymore, templates in the template host groups which are not configured,
+// and items and triggers of the provisioned templates which are not rules anymore.
+type CleanupConfig struct {
+	Hosts     OrphanPolicy
+	Templates OrphanPolicy
+	Items     OrphanPolicy
+	Triggers  OrphanPolicy
+	// GracePeriod for OrphanDelete, counted from the first run which found the object orphaned.
+	// Hosts keep it in the StaleTag tag, the other objects only in memory, see ValidateOnce.
+	GracePeriod time.Duration
+	// MaxRemovalPercent aborts the run when more objects of a type would be deleted or disabled, 0 disables the limit
+	MaxRemovalPercent float64
+}
+
+//DefaultCleanupConfig keeps hosts and templates and deletes items and triggers
+var DefaultCleanupConfig = CleanupConfig{
+	Hosts:     OrphanKeep,
+	Templates: OrphanKeep,
+	Items:     OrphanDelete,
+	Triggers:  OrphanDelete,
+}
+
+//WithDefaults fills the empty policies from DefaultCleanupConfig
+func (c CleanupConfig) WithDefaults() CleanupConfig {
+	if c.Hosts == "" {
+		c.Hosts = DefaultCleanupConfig.Hosts
+	}
+	if c.Templates == "" {
+		c.Templates = DefaultCleanupConfig.Templates
+	}
+	if c.Items == "" {
+		c.Items = DefaultCleanupConfig.Items
+	}
+	if c.Triggers == "" {
+		c.Triggers = DefaultCleanupConfig.Triggers
+	}
+	return c
+}
+
+//Validate checks that every object type supports its policy
+func (c CleanupConfig) Validate() error {
+	policies := []struct {
+		objectType string
+		policy     OrphanPolicy
+		supported  []OrphanPolicy
+	}{
+		{"host", c.Hosts, []OrphanPolicy{OrphanKeep, OrphanDisable, OrphanDelete, OrphanTag}},
+		{"template", c.Templates, []OrphanPolicy{OrphanKeep, OrphanDelete}},
+		{"item", c.Items, []OrphanPolicy{OrphanKeep, OrphanDisable, OrphanDelete}},
+		{"trigger", c.Triggers, []OrphanPolicy{OrphanKeep, OrphanDisable, OrphanDelete}},
+	}
+
+	for _, p := range policies {
+		if !containsPolicy(p.supported, p.policy) {
+			return errors.Errorf("orphan policy %q is not supported for %ss, supported: %v", p.policy, p.objectType, p.supported)
+		}
+	}
+
+	if c.GracePeriod < 0 {
+		return errors.Errorf("negative orphan grace period: %s", c.GracePeriod)
+	}
+	if c.MaxRemovalPercent < 0 || c.MaxRemovalPercent > 100 {
+		return errors.Errorf("max removal percent must be between 0 and 100, got: %v", c.MaxRemovalPercent)
+	}
+	return nil
+}
+
+//ValidateOnce checks that the config can be applied by single runs. The grace period of templates, items and
+// triggers is only kept in memory between the runs of a continuous provisioning, their orphans would never be deleted.
+func (c CleanupConfig) ValidateOnce() error {
+	if c.GracePeriod == 0 {
+		return nil
+	}
+	policies := []struct {
+		objectType string
+		policy     OrphanPolicy
+	}{{"template", c.Templates}, {"item", c.Items}, {"trigger", c.Triggers}}
+	for _, p := range policies {
+		if p.policy == OrphanDelete {
+			return errors.Errorf("the orphan grace period of %ss requires a continuous provisioning, only hosts keep it across runs", p.objectType)
+		}
+	}
+	return nil
+}
+
+func containsPolicy(policies []OrphanPolicy, policy OrphanPolicy) bool {
+	for _, p := range policies {
+		if p == policy {
+			return true
+		}
+	}
+	return false
+}
+
+//loadOrphanedTemplates loads the templates of the template host groups which are not configured anymore
+func (p *Provisioner) loadOrphanedTemplates() error {
+	var groupIDs []string
+	for _, host := range p.hosts {
+		for _, name := range host.TemplateHostGroups {
+			if hostGroup, ok := p.HostGroups[name]; ok && hostGroup.GroupID != "" {
+				groupIDs = append(groupIDs, hostGroup.GroupID)
+			}
+		}
+	}
+	if len(groupIDs) == 0 {
+		return nil
+	}
+
+	zabbixTemplates, err := p.api.TemplateGet(zabbix.Params{
+		"output":   "extend",
+		"groupids": groupIDs,
+	})
+	if err != nil {
+		return errors.Wrapf(err, "error getting templates of the hostgroups: %v", groupIDs)
+	}
+
+	for _, zabbixTemplate := range zabbixTemplates {
+		if _, ok := p.Templates[zabbixTemplate.Name]; ok || !HasManagedMarker(zabbixTemplate.Description) {
+			continue
+		}
+		p.AddTemplate(&CustomTemplate{
+			State:        StateOld,
+			Template:     zabbixTemplate,
+			HostGroups:   map[string]struct{}{},
+			Items:        map[string]*CustomItem{},
+			Applications: map[string]*CustomApplication{},
+			Triggers:     map[string]*CustomTrigger{},
+		})
+		log.Debugf("Load orphaned template from Zabbix: %s", zabbixTemplate.Name)
+	}
+	return nil
+}
+
+//removals counts the existing and removed objects of a type for the safety limit
+type removals struct {
+	existing int
+	removed  int
+}
+
+//orphanCleanup applies the policies to the objects of one run
+type orphanCleanup struct {
+	config CleanupConfig
+	now    time.Time
+	// since when the objects are orphaned, carried between runs
+	previous map[string]time.Time
+	current  map[string]time.Time
+	counts   map[string]*removals
+}
+
+//ApplyOrphanPolicies turns the orphaned objects still in StateOld into the changes of their policy.
+// Afterwards only objects which have to be deleted are left in StateOld.
+// It returns an error without changing anything when more objects would be removed than allowed.
+func (p *Provisioner) ApplyOrphanPolicies(now time.Time) error {
+	c := &orphanCleanup{
+		config:   p.cleanup,
+		now:      now,
+		previous: p.orphans,
+		current:  map[string]time.Time{},
+		counts: map[string]*removals{
+			"host":     {},
+			"template": {},
+			"item":     {},
+			"trigger":  {},
+		},
+	}
+
+	var changes []func()
+
+	for _, host := range p.Hosts {
+		changes = append(changes, c.host(host)...)
+	}
+
+	configured := make(map[string]struct{}, len(p.hosts))
+	for _, host := range p.hosts {
+		configured[host.Name] = struct{}{}
+	}
+
+	for _, template := range p.Templates {
+		if _, ok := configured[template.Name]; ok && template.State == StateOld {
+			// The rules of the template weren't loaded, its content isn't orphaned
+			changes = append(changes, keepTemplate(template))
+			continue
+		}
+		changes = append(changes, c.template(template)...)
+
+		for key, item := range template.Items {
+			changes = append(changes, c.item(template.Name+"/"+key, item)...)
+		}
+
+		for key, trigger := range template.Triggers {
+			changes = append(changes, c.trigger(template.Name+"/"+key, trigger)...)
+		}
+
+		// Applications of the items which are kept or disabled must stay
+		if c.config.Items != OrphanDelete {
+			for _, application := range template.Applications {
+				application := application
+				changes = append(changes, func() {
+					if application.State == StateOld {
+						application.State = StateEqual
+					}
+				})
+			}
+		}
+	}
+
+	if err := c.checkLimit(); err != nil {
+		return err
+	}
+
+	for _, change := range changes {
+		change()
+	}
+	p.orphans = c.current
+	return nil
+}
+
+//keepTemplate leaves the template and its content untouched
+func keepTemplate(template *CustomTemplate) func() {
+	return func() {
+		template.State = StateEqual
+		for _, application := range template.Applications {
+			application.State = StateEqual
+		}
+		for _, item := range template.Items {
+			item.State = StateEqual
+		}
+		for _, trigger := range template.Triggers {
+			trigger.State = StateEqual
+		}
+	}
+}
+
+//checkLimit fails when a type would lose more than MaxRemovalPercent of its objects
+func (c *orphanCleanup) checkLimit() error {
+	if c.config.MaxRemovalPercent == 0 {
+		return nil
+	}
+
+	for objectType, count := range c.counts {
+		if count.existing == 0 || count.removed == 0 {
+			continue
+		}
+		percent := float64(count.removed) * 100 / float64(count.existing)
+		if percent > c.config.MaxRemovalPercent {
+			return errors.Errorf("refusing to remove %d of %d %ss (%.0f%%), the limit is %.0f%%",
+				count.removed, count.existing, objectType, percent, c.config.MaxRemovalPercent)
+		}
+	}
+	return nil
+}
+
+//orphanedFor records the object as orphaned and returns for how long it is orphaned
+func (c *orphanCleanup) orphanedFor(key string, since time.Time) time.Duration {
+	if since.IsZero() {
+		since = c.now
+		if previous, ok := c.previous[key]; ok {
+			since = previous
+		}
+	}
+	c.current[key] = since
+	return c.now.Sub(since)
+}
+
+//expired reports whether an orphan deleted after the grace period can be deleted now
+func (c *orphanCleanup) expired(key string, since time.Time) bool {
+	orphaned := c.orphanedFor(key, since)
+	if orphaned < c.config.GracePeriod {
+		log.Infof("orphaned %s will be deleted in %s", key, c.config.GracePeriod-orphaned)
+		return false
+	}
+	return true
+}
+
+//count counts the objects which exist in Zabbix, new objects don't have an id yet
+func (c *orphanCleanup) count(objectType string, existing bool) {
+	if existing {
+		c.counts[objectType].existing++
+	}
+}
+
+func (c *orphanCleanup) removed(objectType string) {
+	c.counts[objectType].removed++
+}
+
+func (c *orphanCleanup) host(host *CustomHost) []func() {
+	c.count("host", host.HostID != "")
+	if host.State != StateOld {
+		return nil
+	}
+
+	key := "host/" + host.Name
+	since := staleSince(host.Tags)
+
+	switch c.config.Hosts {
+	case OrphanDisable:
+		if host.Status == zabbix.Unmonitored {
+			return []func(){func() { host.State = StateEqual }}
+		}
+		c.removed("host")
+		return []func(){func() {
+			log.Infof("disabling orphaned host %s", host.Name)
+			host.Status = zabbix.Unmonitored
+			host.State = StateUpdated
+		}}
+	case OrphanTag:
+		c.orphanedFor(key, since)
+		return []func(){c.tagHost(host, since)}
+	case OrphanDelete:
+		if !c.expired(key, since) {
+			// The tag keeps the grace period across restarts
+			return []func(){c.tagHost(host, since)}
+		}
+		c.removed("host")
+		return []func(){func() { log.Infof("deleting orphaned host %s", host.Name) }}
+	default:
+		return []func(){func() { host.State = StateEqual }}
+	}
+}
+
+//tagHost adds the stale tag unless the host already has it
+func (c *orphanCleanup) tagHost(host *CustomHost, since time.Time) func() {
+	return func() {
+		if !since.IsZero() {
+			host.State = StateEqual
+			return
+		}
+		log.Infof("tagging orphaned host %s as stale", host.Name)
+		host.Tags = append(host.Tags, zabbix.Tag{Tag: StaleTag, Value: c.now.UTC().Format(time.RFC3339)})
+		host.State = StateUpdated
+	}
+}
+
+//staleSince returns the time of the stale tag or zero time when the host isn't tagged
+func staleSince(tags []zabbix.Tag) time.Time {
+	for _, tag := range tags {
+		if tag.Tag != StaleTag {
+			continue
+		}
+		since, err := time.Parse(time.RFC3339, tag.Value)
+		if err != nil {
+			log.Warnf("invalid %s tag value: %s", StaleTag, tag.Value)
+			return time.Time{}
+		}
+		return since
+	}
+	return time.Time{}
+}
+
+func (c *orphanCleanup) template(template *CustomTemplate) []func() {
+	c.count("template", template.TemplateID != "")
+	if template.State != StateOld {
+		return nil
+	}
+
+	key := "template/" + template.Name
+	if c.config.Templates == OrphanDelete && c.expired(key, time.Time{}) {
+		c.removed("template")
+		return []func(){func() { log.Infof("deleting orphaned template %s", template.Name) }}
+	}
+	return []func(){func() { template.State = StateEqual }}
+}
+
+func (c *orphanCleanup) item(key string, item *CustomItem) []func() {
+	c.count("item", item.ItemID != "")
+	if item.State != StateOld {
+		return nil
+	}
+
+	key = "item/" + key
+	switch c.config.Items {
+	case OrphanDisable:
+		if item.Status == zabbix.Disabled {
+			return []func(){func() { item.State = StateEqual }}
+		}
+		c.removed("item")
+		return []func(){func() {
+			item.Status = zabbix.Disabled
+			item.State = StateUpdated
+		}}
+	case OrphanDelete:
+		if c.expired(key, time.Time{}) {
+			c.removed("item")
+			return nil
+		}
+	}
+	return []func(){func() { item.State = StateEqual }}
+}
+
+func (c *orphanCleanup) trigger(key string, trigger *CustomTrigger) []func() {
+	c.count("trigger", trigger.TriggerID != "")
+	if trigger.State != StateOld {
+		return nil
+	}
+
+	key = "trigger/" + key
+	switch c.config.Triggers {
+	case OrphanDisable:
+		if trigger.Status == zabbix.Disabled {
+			return []func(){func() { trigger.State = StateEqual }}
+		}
+		c.removed("trigger")
+		return []func(){func() {
+			trigger.Status = zabbix.Disabled
+			trigger.State = StateUpdated
+		}}
+	case OrphanDelete:
+		if c.expired(key, time.Time{}) {
+			c.removed("trigger")
+			return nil
+		}
+	}
+	return []func(){func() { trigger.State = StateEqual }}
+}
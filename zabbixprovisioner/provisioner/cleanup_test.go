@@ -0,0 +1,189 @@
+package provisioner_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/neogan74/zabbix-alertmanager/zabbixprovisioner/provisioner"
+	zabbix "github.com/neogan74/zabbix-alertmanager/zabbixprovisioner/zabbixclient"
+)
+
+//newTestProvisioner creates a provisioner logged in to a fake Zabbix API, the server has to be closed
+func newTestProvisioner(t *testing.T, options provisioner.Options) (*provisioner.Provisioner, *httptest.Server) {
+	t.Helper()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "result": "token", "id": 1})
+	}))
+
+	hosts := []provisioner.HostConfig{{Name: "prom2zbx"}}
+	p, err := provisioner.New("", "prometheus", ts.URL, "user", "password", hosts, options)
+	if err != nil {
+		ts.Close()
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return p, ts
+}
+
+//orphanedZabbix has a provisioned template with one current and one orphaned item and trigger, and a current and an orphaned host
+func orphanedZabbix() *provisioner.CustomZabbix {
+	return &provisioner.CustomZabbix{
+		Hosts: map[string]*provisioner.CustomHost{
+			"current":  {State: provisioner.StateEqual, Host: zabbix.Host{HostID: "1", Name: "current"}},
+			"orphaned": {State: provisioner.StateOld, Host: zabbix.Host{HostID: "2", Name: "orphaned"}},
+		},
+		Templates: map[string]*provisioner.CustomTemplate{
+			"prom2zbx": {
+				State:    provisioner.StateEqual,
+				Template: zabbix.Template{TemplateID: "3", Name: "prom2zbx"},
+				Items: map[string]*provisioner.CustomItem{
+					"current":  {State: provisioner.StateEqual, Item: zabbix.Item{ItemID: "4"}},
+					"orphaned": {State: provisioner.StateOld, Item: zabbix.Item{ItemID: "5"}},
+				},
+				Triggers: map[string]*provisioner.CustomTrigger{
+					"current":  {State: provisioner.StateEqual, Trigger: zabbix.Trigger{TriggerID: "6"}},
+					"orphaned": {State: provisioner.StateOld, Trigger: zabbix.Trigger{TriggerID: "7"}},
+				},
+				Applications: map[string]*provisioner.CustomApplication{},
+			},
+			"removed": {
+				State:        provisioner.StateOld,
+				Template:     zabbix.Template{TemplateID: "8", Name: "removed"},
+				Items:        map[string]*provisioner.CustomItem{},
+				Triggers:     map[string]*provisioner.CustomTrigger{},
+				Applications: map[string]*provisioner.CustomApplication{},
+			},
+		},
+		HostGroups: map[string]*provisioner.CustomHostGroup{},
+	}
+}
+
+func TestApplyOrphanPoliciesDefault(t *testing.T) {
+	p, ts := newTestProvisioner(t, provisioner.Options{})
+	defer ts.Close()
+	p.CustomZabbix = orphanedZabbix()
+
+	if err := p.ApplyOrphanPolicies(time.Now()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if p.Hosts["orphaned"].State != provisioner.StateEqual {
+		t.Errorf("Expected orphaned host to be kept, got state %s", provisioner.StateName[p.Hosts["orphaned"].State])
+	}
+	if p.Templates["removed"].State != provisioner.StateEqual {
+		t.Errorf("Expected orphaned template to be kept, got state %s", provisioner.StateName[p.Templates["removed"].State])
+	}
+	template := p.Templates["prom2zbx"]
+	if template.Items["orphaned"].State != provisioner.StateOld || template.Triggers["orphaned"].State != provisioner.StateOld {
+		t.Error("Expected orphaned item and trigger to be deleted")
+	}
+}
+
+func TestApplyOrphanPoliciesDisableAndTag(t *testing.T) {
+	p, ts := newTestProvisioner(t, provisioner.Options{Cleanup: provisioner.CleanupConfig{
+		Hosts:    provisioner.OrphanTag,
+		Items:    provisioner.OrphanDisable,
+		Triggers: provisioner.OrphanDisable,
+	}})
+	defer ts.Close()
+	p.CustomZabbix = orphanedZabbix()
+
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := p.ApplyOrphanPolicies(now); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	host := p.Hosts["orphaned"]
+	if host.State != provisioner.StateUpdated || len(host.Tags) != 1 || host.Tags[0].Value != "2020-01-02T03:04:05Z" {
+		t.Errorf("Expected orphaned host to be tagged, got %+v", host)
+	}
+
+	template := p.Templates["prom2zbx"]
+	if item := template.Items["orphaned"]; item.State != provisioner.StateUpdated || item.Status != zabbix.Disabled {
+		t.Errorf("Expected orphaned item to be disabled, got %+v", item)
+	}
+	if trigger := template.Triggers["orphaned"]; trigger.State != provisioner.StateUpdated || trigger.Status != zabbix.Disabled {
+		t.Errorf("Expected orphaned trigger to be disabled, got %+v", trigger)
+	}
+}
+
+func TestApplyOrphanPoliciesGracePeriod(t *testing.T) {
+	p, ts := newTestProvisioner(t, provisioner.Options{Cleanup: provisioner.CleanupConfig{
+		Hosts:       provisioner.OrphanDelete,
+		Templates:   provisioner.OrphanDelete,
+		GracePeriod: time.Hour,
+	}})
+	defer ts.Close()
+
+	start := time.Now()
+	p.CustomZabbix = orphanedZabbix()
+	if err := p.ApplyOrphanPolicies(start); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if p.Hosts["orphaned"].State == provisioner.StateOld || p.Templates["removed"].State == provisioner.StateOld {
+		t.Error("Expected orphans not to be deleted during the grace period")
+	}
+	if p.Hosts["orphaned"].State != provisioner.StateUpdated {
+		t.Error("Expected orphaned host to be tagged during the grace period")
+	}
+
+	p.CustomZabbix = orphanedZabbix()
+	if err := p.ApplyOrphanPolicies(start.Add(2 * time.Hour)); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if p.Hosts["orphaned"].State != provisioner.StateOld || p.Templates["removed"].State != provisioner.StateOld {
+		t.Error("Expected orphans to be deleted after the grace period")
+	}
+}
+
+func TestApplyOrphanPoliciesMaxRemovalPercent(t *testing.T) {
+	p, ts := newTestProvisioner(t, provisioner.Options{Cleanup: provisioner.CleanupConfig{
+		Hosts:             provisioner.OrphanDisable,
+		MaxRemovalPercent: 40,
+	}})
+	defer ts.Close()
+	p.CustomZabbix = orphanedZabbix()
+
+	if err := p.ApplyOrphanPolicies(time.Now()); err == nil {
+		t.Fatal("Expected error when removing half of the objects")
+	}
+	if p.Hosts["orphaned"].State != provisioner.StateOld || p.Hosts["orphaned"].Status != zabbix.Monitored {
+		t.Error("Expected no changes when the limit is exceeded")
+	}
+}
+
+func TestCleanupConfigValidate(t *testing.T) {
+	invalid := map[string]provisioner.CleanupConfig{
+		"template disable": {Templates: provisioner.OrphanDisable},
+		"item tag":         {Items: provisioner.OrphanTag},
+		"unknown":          {Hosts: "archive"},
+		"percent":          {MaxRemovalPercent: 150},
+		"grace period":     {GracePeriod: -time.Minute},
+	}
+	for name, config := range invalid {
+		if err := config.WithDefaults().Validate(); err == nil {
+			t.Errorf("Expected %s error", name)
+		}
+	}
+
+	if err := (provisioner.CleanupConfig{}).WithDefaults().Validate(); err != nil {
+		t.Errorf("Unexpected error for the default config: %v", err)
+	}
+}
+
+func TestCleanupConfigValidateOnce(t *testing.T) {
+	hosts := provisioner.CleanupConfig{Hosts: provisioner.OrphanDelete, Items: provisioner.OrphanDisable,
+		Triggers: provisioner.OrphanKeep, GracePeriod: time.Hour}
+	if err := hosts.WithDefaults().ValidateOnce(); err != nil {
+		t.Errorf("Unexpected error for the grace period of hosts: %v", err)
+	}
+	if err := (provisioner.CleanupConfig{GracePeriod: time.Hour}).WithDefaults().ValidateOnce(); err == nil {
+		t.Error("Expected error for the grace period of items deleted by single runs")
+	}
+	if err := (provisioner.CleanupConfig{}).WithDefaults().ValidateOnce(); err != nil {
+		t.Errorf("Unexpected error without grace period: %v", err)
+	}
+}
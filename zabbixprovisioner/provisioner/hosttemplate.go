@@ -0,0 +1,194 @@
+package provisioner
+
+import (
+	"bytes"
+	"net"
+	"sort"
+	"strings"
+	"text/template"
+
+	zabbix "github.com/neogan74/zabbix-alertmanager/zabbixprovisioner/zabbixclient"
+	"github.com/pkg/errors"
+)
+
+//Default host template values, the target host is used for the names and the interface address
+const (
+	DefaultHostTemplateHost = "{{ .Host }}"
+	DefaultInterfaceType    = "agent"
+)
+
+//defaultInterfacePorts default ports by interface type
+var defaultInterfacePorts = map[string]string{
+	"agent": "10050",
+	"snmp":  "161",
+	"ipmi":  "623",
+	"jmx":   "12345",
+}
+
+//interfaceTypes Zabbix interface types by name
+var interfaceTypes = map[string]zabbix.InterfaceType{
+	"agent": zabbix.Agent,
+	"snmp":  zabbix.SNMP,
+	"ipmi":  zabbix.IPMI,
+	"jmx":   zabbix.JMX,
+}
+
+//HostTemplate describes how a Prometheus target is turned into a Zabbix host.
+// All values are Go templates executed with the PrometheusTarget, e.g. "{{ .Host }}" or "{{ .Labels.job }}".
+type HostTemplate struct {
+	Host      string            `yaml:"host"`
+	Name      string            `yaml:"name"`
+	Interface InterfaceTemplate `yaml:"interface"`
+	Inventory map[string]string `yaml:"inventory"`
+	Tags      map[string]string `yaml:"tags"`
+}
+
+//InterfaceTemplate describes the main interface of the host.
+// The address is used as IP when it is an IP address, otherwise as DNS name.
+type InterfaceTemplate struct {
+	Type          string `yaml:"type"`
+	Address       string `yaml:"address"`
+	Port          string `yaml:"port"`
+	SNMPVersion   string `yaml:"snmpVersion"`
+	SNMPCommunity string `yaml:"snmpCommunity"`
+}
+
+//TargetHost renders the host template of the config for the target.
+// Tag and DeploymentStatus of the config fill the inventory fields of the same name unless the template sets them.
+func (c HostConfig) TargetHost(target PrometheusTarget) (zabbix.Host, error) {
+	host, err := c.HostTemplate.Render(target)
+	if err != nil {
+		return zabbix.Host{}, err
+	}
+
+	defaults := map[string]string{
+		"tag":               c.Tag,
+		"deployment_status": c.DeploymentStatus,
+	}
+	for field, value := range defaults {
+		if value == "" {
+			continue
+		}
+		if host.Inventory == nil {
+			host.InventoryMode = zabbix.InventoryManual
+			host.Inventory = map[string]string{}
+		}
+		if _, ok := host.Inventory[field]; !ok {
+			host.Inventory[field] = value
+		}
+	}
+	return host, nil
+}
+
+//Render creates the Zabbix host for the target
+func (t HostTemplate) Render(target PrometheusTarget) (zabbix.Host, error) {
+	r := &hostRenderer{target: target}
+
+	host := zabbix.Host{
+		Host:      r.render("host", withDefault(t.Host, DefaultHostTemplateHost)),
+		Available: 1,
+		Status:    0,
+	}
+	host.Name = r.render("name", withDefault(t.Name, withDefault(t.Host, DefaultHostTemplateHost)))
+
+	iface, err := t.Interface.render(r)
+	if err != nil {
+		return zabbix.Host{}, err
+	}
+	host.Interfaces = zabbix.HostInterfaces{iface}
+
+	if len(t.Inventory) != 0 {
+		host.InventoryMode = zabbix.InventoryManual
+		host.Inventory = make(map[string]string, len(t.Inventory))
+		for field, value := range t.Inventory {
+			if v := r.render("inventory "+field, value); v != "" {
+				host.Inventory[field] = v
+			}
+		}
+	}
+
+	for _, tag := range sortedKeys(t.Tags) {
+		host.Tags = append(host.Tags, zabbix.Tag{Tag: tag, Value: r.render("tag "+tag, t.Tags[tag])})
+	}
+
+	if r.err != nil {
+		return zabbix.Host{}, r.err
+	}
+	if host.Host == "" {
+		return zabbix.Host{}, errors.Errorf("empty host name for target: %v", target.Labels)
+	}
+	return host, nil
+}
+
+func (t InterfaceTemplate) render(r *hostRenderer) (zabbix.HostInterface, error) {
+	typeName := strings.ToLower(withDefault(t.Type, DefaultInterfaceType))
+	interfaceType, ok := interfaceTypes[typeName]
+	if !ok {
+		return zabbix.HostInterface{}, errors.Errorf("unknown interface type: %s", t.Type)
+	}
+
+	iface := zabbix.HostInterface{
+		Main: 1,
+		Type: interfaceType,
+		Port: r.render("interface port", withDefault(t.Port, defaultInterfacePorts[typeName])),
+	}
+
+	address := r.render("interface address", withDefault(t.Address, DefaultHostTemplateHost))
+	if net.ParseIP(address) != nil {
+		iface.IP = address
+		iface.UseIP = 1
+	} else {
+		iface.DNS = address
+	}
+
+	if interfaceType == zabbix.SNMP {
+		iface.Details = &zabbix.HostInterfaceDetails{
+			Version:   withDefault(t.SNMPVersion, "2"),
+			Bulk:      "1",
+			Community: withDefault(t.SNMPCommunity, "{$SNMP_COMMUNITY}"),
+		}
+	}
+
+	return iface, nil
+}
+
+//hostRenderer renders templates of one target and keeps the first error
+type hostRenderer struct {
+	target PrometheusTarget
+	err    error
+}
+
+func (r *hostRenderer) render(name, text string) string {
+	if r.err != nil {
+		return ""
+	}
+
+	tmpl, err := template.New(name).Option("missingkey=zero").Parse(text)
+	if err != nil {
+		r.err = errors.Wrapf(err, "invalid %s template", name)
+		return ""
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, r.target); err != nil {
+		r.err = errors.Wrapf(err, "error executing %s template", name)
+		return ""
+	}
+	return strings.TrimSpace(buf.String())
+}
+
+func withDefault(value, defaultValue string) string {
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
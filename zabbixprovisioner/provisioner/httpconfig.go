@@ -0,0 +1,162 @@
+package provisioner
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+//DefaultHTTPTimeout timeout for requests to Prometheus when the config doesn't set one
+const DefaultHTTPTimeout = 30 * time.Second
+
+//HTTPClientConfig configures how the provisioner talks to Prometheus compatible APIs
+type HTTPClientConfig struct {
+	BearerToken     string            `yaml:"bearerToken"`
+	BearerTokenFile string            `yaml:"bearerTokenFile"`
+	BasicAuth       *BasicAuth        `yaml:"basicAuth"`
+	Headers         map[string]string `yaml:"headers"`
+	TLSConfig       TLSConfig         `yaml:"tlsConfig"`
+	Timeout         string            `yaml:"timeout"`
+}
+
+//BasicAuth credentials for HTTP basic authentication
+type BasicAuth struct {
+	Username     string `yaml:"username"`
+	Password     string `yaml:"password"`
+	PasswordFile string `yaml:"passwordFile"`
+}
+
+//TLSConfig TLS settings of the HTTP client
+type TLSConfig struct {
+	CAFile             string `yaml:"caFile"`
+	CertFile           string `yaml:"certFile"`
+	KeyFile            string `yaml:"keyFile"`
+	ServerName         string `yaml:"serverName"`
+	InsecureSkipVerify bool   `yaml:"insecureSkipVerify"`
+}
+
+//NewClient creates http.Client which adds the configured authentication and headers to every request
+func (c HTTPClientConfig) NewClient() (*http.Client, error) {
+	tlsConfig, err := c.TLSConfig.newTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := DefaultHTTPTimeout
+	if c.Timeout != "" {
+		timeout, err = time.ParseDuration(c.Timeout)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid timeout: %s", c.Timeout)
+		}
+	}
+
+	headers := make(http.Header, len(c.Headers)+1)
+	for k, v := range c.Headers {
+		headers.Set(k, v)
+	}
+
+	token := c.BearerToken
+	if c.BearerTokenFile != "" {
+		token, err = readSecretFile(c.BearerTokenFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if token != "" {
+		headers.Set("Authorization", "Bearer "+token)
+	}
+
+	var basicAuth *BasicAuth
+	if c.BasicAuth != nil {
+		basicAuth = &BasicAuth{Username: c.BasicAuth.Username, Password: c.BasicAuth.Password}
+		if c.BasicAuth.PasswordFile != "" {
+			basicAuth.Password, err = readSecretFile(c.BasicAuth.PasswordFile)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &authRoundTripper{
+			headers:   headers,
+			basicAuth: basicAuth,
+			next:      transport,
+		},
+	}, nil
+}
+
+func (c TLSConfig) newTLSConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+
+	if c.CAFile != "" {
+		ca, err := ioutil.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "can't read the CA file: %s", c.CAFile)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, errors.Errorf("can't parse the CA file: %s", c.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if c.CertFile != "" || c.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "can't load the client certificate: %s", c.CertFile)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+type authRoundTripper struct {
+	headers   http.Header
+	basicAuth *BasicAuth
+	next      http.RoundTripper
+}
+
+func (rt *authRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	for k, v := range rt.headers {
+		req.Header[k] = v
+	}
+	if rt.basicAuth != nil {
+		req.SetBasicAuth(rt.basicAuth.Username, rt.basicAuth.Password)
+	}
+	return rt.next.RoundTrip(req)
+}
+
+func readSecretFile(filename string) (string, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return "", errors.Wrapf(err, "can't read the secret file: %s", filename)
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+//prometheusAPIURL joins the Prometheus address and the API path, addresses without a scheme use http
+func prometheusAPIURL(address, path string) string {
+	address = strings.TrimSuffix(address, "/")
+	if !strings.Contains(address, "://") {
+		address = "http://" + address
+	}
+	if strings.HasSuffix(address, path) {
+		return address
+	}
+	return address + path
+}
@@ -0,0 +1,131 @@
+package provisioner
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+//Item key strategies
+const (
+	// KeyStrategyAlertname <prefix>.<alertname>
+	KeyStrategyAlertname = "alertname"
+	// KeyStrategyLabels <prefix>.<alertname>.<label value>...
+	KeyStrategyLabels = "labels"
+	// KeyStrategyHash <prefix>.<alertname>.<hash of the labels>
+	KeyStrategyHash = "hash"
+)
+
+//DefaultGroupLabel label holding the rule group name in alerts received by zal send
+const DefaultGroupLabel = "rulegroup"
+
+//ItemKeyConfig configures how trapper item keys are computed.
+// zal prov computes the key from the rule and zal send from the alert labels, so only static rule labels can be used.
+// With IncludeGroup the rules and the alerts must carry the group name in GroupLabel, e.g. by using group labels.
+type ItemKeyConfig struct {
+	Strategy     string   `yaml:"strategy"`
+	Labels       []string `yaml:"labels"`
+	IncludeGroup bool     `yaml:"includeGroup"`
+	GroupLabel   string   `yaml:"groupLabel"`
+}
+
+//Validate checks the strategy and its labels
+func (c ItemKeyConfig) Validate() error {
+	switch c.Strategy {
+	case "", KeyStrategyAlertname:
+		return nil
+	case KeyStrategyLabels, KeyStrategyHash:
+		if len(c.Labels) == 0 {
+			return errors.Errorf("item key strategy %s requires labels", c.Strategy)
+		}
+		return nil
+	default:
+		return errors.Errorf("unknown item key strategy: %s", c.Strategy)
+	}
+}
+
+//RuleKey returns the item key of a Prometheus rule.
+// The labels of the key, and the group label with IncludeGroup, have to be static labels of the rule,
+// otherwise zal send could compute another key from the labels of the alert.
+func (c ItemKeyConfig) RuleKey(keyPrefix string, rule PrometheusRule) (string, error) {
+	labels := rule.AllLabels()
+	var names []string
+	if c.Strategy == KeyStrategyLabels || c.Strategy == KeyStrategyHash {
+		names = append(names, c.Labels...)
+	}
+	if c.IncludeGroup {
+		names = append(names, c.groupLabel())
+	}
+	for _, name := range names {
+		value, ok := labels[name]
+		if !ok {
+			return "", errors.Errorf("label %s of the item key is missing from the rule %s", name, rule.Name)
+		}
+		if strings.Contains(value, "{{") {
+			return "", errors.Errorf("label %s of the rule %s is templated, it can't be used in the item key", name, rule.Name)
+		}
+	}
+	return c.key(keyPrefix, rule.Name, labels[c.groupLabel()], labels), nil
+}
+
+//AlertKey returns the item key of an alert received from Alertmanager
+func (c ItemKeyConfig) AlertKey(keyPrefix string, labels map[string]string) string {
+	return c.key(keyPrefix, labels["alertname"], labels[c.groupLabel()], labels)
+}
+
+//key joins the parts of the item key. The alertname strategy keeps the lowercased alertname of the keys
+// created before the other strategies, they sanitise every part.
+func (c ItemKeyConfig) key(keyPrefix, alertname, group string, labels map[string]string) string {
+	parts := []string{strings.ToLower(keyPrefix)}
+	if c.IncludeGroup && group != "" {
+		parts = append(parts, itemKeyPart(group))
+	}
+
+	switch c.Strategy {
+	case KeyStrategyLabels:
+		parts = append(parts, itemKeyPart(alertname))
+		for _, name := range c.Labels {
+			parts = append(parts, itemKeyPart(labels[name]))
+		}
+	case KeyStrategyHash:
+		parts = append(parts, itemKeyPart(alertname), labelsHash(c.Labels, labels))
+	default:
+		parts = append(parts, strings.ToLower(alertname))
+	}
+
+	return strings.Join(parts, ".")
+}
+
+func (c ItemKeyConfig) groupLabel() string {
+	if c.GroupLabel == "" {
+		return DefaultGroupLabel
+	}
+	return c.GroupLabel
+}
+
+//labelsHash hashes the selected labels, the order of the names doesn't matter
+func labelsHash(names []string, labels map[string]string) string {
+	sorted := append([]string(nil), names...)
+	sort.Strings(sorted)
+
+	h := fnv.New32a()
+	for _, name := range sorted {
+		fmt.Fprintf(h, "%s=%q;", name, labels[name])
+	}
+	return fmt.Sprintf("%08x", h.Sum32())
+}
+
+//itemKeyPart lowercases s and replaces the characters which are not allowed in Zabbix item keys
+func itemKeyPart(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '_', r == '-', r == '.':
+			return r
+		default:
+			return '_'
+		}
+	}, strings.ToLower(s))
+}
@@ -0,0 +1,195 @@
+package provisioner
+
+import (
+	"fmt"
+	"strings"
+
+	zabbix "github.com/neogan74/zabbix-alertmanager/zabbixprovisioner/zabbixclient"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+//FailurePolicy decides what ApplyChanges does after a template or host failed and its changes were rolled back
+type FailurePolicy string
+
+//Failure policies
+const (
+	FailureStop     FailurePolicy = "stop"
+	FailureContinue FailurePolicy = "continue"
+)
+
+//Validate checks the failure policy is known
+func (f FailurePolicy) Validate() error {
+	switch f {
+	case FailureStop, FailureContinue:
+		return nil
+	}
+	return errors.Errorf("unknown failure policy: %s", f)
+}
+
+//journal records the mutations applied to Zabbix in one scope, a template, a host or the shared objects,
+// together with the changes undoing them
+type journal struct {
+	scope   string
+	entries []journalEntry
+}
+
+type journalEntry struct {
+	objectType string
+	action     string
+	count      int
+	// undo is nil for changes which can't be reverted
+	undo func() error
+}
+
+func (e journalEntry) String() string {
+	return fmt.Sprintf("%d %ss %s", e.count, e.objectType, e.action)
+}
+
+func newJournal(scope string) *journal {
+	return &journal{scope: scope}
+}
+
+//apply runs the change and records it when it succeeds, n is the number of changed objects
+func (j *journal) apply(objectType, action string, n int, change func() error, undo func() error) error {
+	if n == 0 {
+		return nil
+	}
+	if err := change(); err != nil {
+		return errors.Wrapf(err, "%s: failed in %s %d %ss", j.scope, strings.TrimSuffix(action, "ed")+"ing", n, objectType)
+	}
+	recordChanges(objectType, action, n)
+	j.entries = append(j.entries, journalEntry{objectType: objectType, action: action, count: n, undo: undo})
+	return nil
+}
+
+//rollback undoes the recorded changes in reverse order and returns the ones left applied
+func (j *journal) rollback() (inconsistent []string) {
+	for i := len(j.entries) - 1; i >= 0; i-- {
+		entry := j.entries[i]
+		if entry.undo == nil {
+			log.Warnf("rollback of %s: %s can't be reverted", j.scope, entry)
+			inconsistent = append(inconsistent, fmt.Sprintf("%s: %s, not revertible", j.scope, entry))
+			continue
+		}
+		if err := entry.undo(); err != nil {
+			log.Errorf("rollback of %s: error reverting %s: %v", j.scope, entry, err)
+			inconsistent = append(inconsistent, fmt.Sprintf("%s: %s, revert failed: %v", j.scope, entry, err))
+			continue
+		}
+		recordChanges(entry.objectType, "reverted", entry.count)
+		log.Infof("rollback of %s: reverted %s", j.scope, entry)
+	}
+	j.entries = nil
+	return inconsistent
+}
+
+//ApplyError is returned by ApplyChanges when some changes failed.
+// The changes of a failed template or host are rolled back, Inconsistent lists what the rollback left applied.
+type ApplyError struct {
+	Failures     []error
+	Inconsistent []string
+}
+
+func (e *ApplyError) Error() string {
+	failures := make([]string, len(e.Failures))
+	for i, err := range e.Failures {
+		failures[i] = err.Error()
+	}
+	msg := strings.Join(failures, "; ")
+	if len(e.Inconsistent) != 0 {
+		msg += fmt.Sprintf("; rollback left inconsistent: %s", strings.Join(e.Inconsistent, ", "))
+	}
+	return msg
+}
+
+//fail rolls the journal back and adds the failure to the report
+func (e *ApplyError) fail(j *journal, err error) {
+	log.Errorf("%v, rolling back %s", err, j.scope)
+	e.Failures = append(e.Failures, err)
+	e.Inconsistent = append(e.Inconsistent, j.rollback()...)
+}
+
+func (e *ApplyError) errorOrNil() error {
+	if len(e.Failures) == 0 {
+		return nil
+	}
+	return e
+}
+
+//The recreate functions copy the objects right away, the deletion clears their ids.
+// The copies are created again without ids, the created ones get new ids.
+
+//deletedApplications applications deleted from a template or host. They are recreated once, by the rollback
+// of their deletion or before the items linked to them, whose undo runs first.
+type deletedApplications struct {
+	api          *zabbix.API
+	applications zabbix.Applications
+	recreated    bool
+}
+
+func (p *Provisioner) recreateApplications(applications zabbix.Applications) *deletedApplications {
+	return &deletedApplications{api: p.api, applications: append(zabbix.Applications(nil), applications...)}
+}
+
+func (d *deletedApplications) recreate() error {
+	if d.recreated || len(d.applications) == 0 {
+		return nil
+	}
+	for i := range d.applications {
+		d.applications[i].ApplicationID = ""
+	}
+	if err := d.api.ApplicationsCreate(d.applications); err != nil {
+		return err
+	}
+	d.recreated = true
+	return nil
+}
+
+//id returns the id of the application, recreating the deleted applications when it is one of them
+func (d *deletedApplications) id(application zabbix.Application) (string, error) {
+	for _, deleted := range d.applications {
+		if deleted.Name != application.Name {
+			continue
+		}
+		if err := d.recreate(); err != nil {
+			return "", err
+		}
+		for _, recreated := range d.applications {
+			if recreated.Name == application.Name {
+				return recreated.ApplicationID, nil
+			}
+		}
+	}
+	return application.ApplicationID, nil
+}
+
+//recreateItems links the items to their applications loaded from Zabbix, the deleted ones are recreated first
+func (p *Provisioner) recreateItems(items zabbix.Items, applications *deletedApplications) func() error {
+	deleted := append(zabbix.Items(nil), items...)
+	return func() error {
+		for i := range deleted {
+			deleted[i].ItemID = ""
+			deleted[i].Error = ""
+			deleted[i].ApplicationIds = []string{}
+			for _, application := range deleted[i].ItemApplications {
+				id, err := applications.id(application)
+				if err != nil {
+					return errors.Wrapf(err, "error recreating the applications of item %s", deleted[i].Key)
+				}
+				deleted[i].ApplicationIds = append(deleted[i].ApplicationIds, id)
+			}
+		}
+		return p.api.ItemsCreate(deleted)
+	}
+}
+
+func (p *Provisioner) recreateTriggers(triggers zabbix.Triggers) func() error {
+	deleted := append(zabbix.Triggers(nil), triggers...)
+	return func() error {
+		for i := range deleted {
+			deleted[i].TriggerID = ""
+		}
+		return p.api.TriggersCreate(deleted)
+	}
+}
@@ -0,0 +1,88 @@
+package provisioner
+
+import (
+	"sort"
+	"strings"
+
+	zabbix "github.com/neogan74/zabbix-alertmanager/zabbixprovisioner/zabbixclient"
+	log "github.com/sirupsen/logrus"
+)
+
+//Ownership markers of the objects created by the provisioner.
+// Hosts and triggers get the ManagedTag tag, items and templates which have no tags get ManagedMarker in the description.
+const (
+	ManagedTagName  = "managed_by"
+	ManagedTagValue = "zal"
+	ManagedMarker   = "managed_by: zal"
+)
+
+//ManagedTag tag of the hosts and triggers managed by the provisioner
+var ManagedTag = zabbix.Tag{Tag: ManagedTagName, Value: ManagedTagValue}
+
+//IsManaged reports whether the tags contain ManagedTag
+func IsManaged(tags []zabbix.Tag) bool {
+	for _, tag := range tags {
+		if tag == ManagedTag {
+			return true
+		}
+	}
+	return false
+}
+
+//HasManagedMarker reports whether the description contains ManagedMarker
+func HasManagedMarker(description string) bool {
+	for _, line := range strings.Split(description, "\n") {
+		if strings.TrimSpace(line) == ManagedMarker {
+			return true
+		}
+	}
+	return false
+}
+
+//withManagedMarker appends ManagedMarker to the description
+func withManagedMarker(description string) string {
+	if HasManagedMarker(description) {
+		return description
+	}
+	return strings.TrimSpace(description + "\n\n" + ManagedMarker)
+}
+
+//withManagedTag appends ManagedTag to the tags
+func withManagedTag(tags []zabbix.Tag) []zabbix.Tag {
+	if IsManaged(tags) {
+		return tags
+	}
+	return append(tags, ManagedTag)
+}
+
+//triggerTags returns the configured trigger tags sorted by name, followed by ManagedTag
+func triggerTags(tags map[string]string) []zabbix.Tag {
+	names := make([]string, 0, len(tags))
+	for name := range tags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	res := make([]zabbix.Tag, 0, len(tags)+1)
+	for _, name := range names {
+		res = append(res, zabbix.Tag{Tag: name, Value: tags[name]})
+	}
+	return withManagedTag(res)
+}
+
+//adoptable decides what to do with an object found in Zabbix without the ownership marker.
+// It returns true when the object has to be loaded into the diff, which happens only in the adopt mode
+// for objects matching a provisioned one. Otherwise the object is left untouched and the matching
+// provisioned object, if any, has to be dropped so it doesn't collide with it.
+func (p *Provisioner) adoptable(objectType, name string, provisioned bool) bool {
+	if !provisioned {
+		log.Debugf("skipping %s %s not managed by zal", objectType, name)
+		return false
+	}
+	if p.adopt {
+		log.Infof("adopting %s %s", objectType, name)
+		return true
+	}
+	log.Warnf("%s %s already exists and isn't managed by zal, leaving it untouched, use --adopt to take it over", objectType, name)
+	return false
+}
@@ -0,0 +1,156 @@
+package provisioner_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/neogan74/zabbix-alertmanager/zabbixprovisioner/provisioner"
+	zabbix "github.com/neogan74/zabbix-alertmanager/zabbixprovisioner/zabbixclient"
+)
+
+func TestOwnershipMarkers(t *testing.T) {
+	if !provisioner.IsManaged([]zabbix.Tag{{Tag: "team", Value: "ops"}, provisioner.ManagedTag}) {
+		t.Error("Expected tags with the managed tag to be managed")
+	}
+	if provisioner.IsManaged([]zabbix.Tag{{Tag: provisioner.ManagedTagName, Value: "someone"}}) {
+		t.Error("Expected tags managed by someone else not to be managed")
+	}
+
+	if !provisioner.HasManagedMarker("Added for the on-call team\n\nmanaged_by: zal") {
+		t.Error("Expected description with the marker to be managed")
+	}
+	if provisioner.HasManagedMarker("not managed_by: zal yet") {
+		t.Error("Expected the marker to be matched only on its own line")
+	}
+}
+
+func TestLoadRulesFromPrometheusManaged(t *testing.T) {
+	p, ts := newTestProvisioner(t, provisioner.Options{})
+	defer ts.Close()
+	p.CustomZabbix = &provisioner.CustomZabbix{
+		Hosts:      map[string]*provisioner.CustomHost{},
+		Templates:  map[string]*provisioner.CustomTemplate{},
+		HostGroups: map[string]*provisioner.CustomHostGroup{},
+	}
+
+	hostConfig := provisioner.HostConfig{
+		Name:          "prom2zbx",
+		HostAlertsDir: rulesOKpath,
+		TriggerTags:   map[string]string{"team": "ops", "env": "prod"},
+	}
+	if err := p.LoadRulesFromPrometheus(hostConfig); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	template := p.Templates["prom2zbx"]
+	if !provisioner.HasManagedMarker(template.Description) {
+		t.Errorf("Expected template to be marked, got description %q", template.Description)
+	}
+
+	for key, item := range template.Items {
+		if !provisioner.HasManagedMarker(item.Description) {
+			t.Errorf("Expected item %s to be marked, got description %q", key, item.Description)
+		}
+	}
+
+	expected := []zabbix.Tag{{Tag: "env", Value: "prod"}, {Tag: "team", Value: "ops"}, provisioner.ManagedTag}
+	for _, trigger := range template.Triggers {
+		if !reflect.DeepEqual(trigger.Tags, expected) {
+			t.Errorf("Expected trigger %s tags %v, got %v", trigger.Description, expected, trigger.Tags)
+		}
+	}
+}
+
+//runAdopt provisions template0 with the rule Alert0 and the target host0 into a Zabbix where they exist unmanaged
+func runAdopt(t *testing.T, adopt bool) *fakeZabbix {
+	z := newFakeZabbix(1, 1, 1)
+	z.unmanaged = true
+	ts := httptest.NewServer(z)
+	defer ts.Close()
+
+	prom := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"status": "success", "data": {"activeTargets": [{"labels": {"instance": "host0:9100", "job": "node"}, "health": "up"}]}}`)
+	}))
+	defer prom.Close()
+
+	hosts := []provisioner.HostConfig{{
+		Name:                   templateName(0),
+		HostGroups:             []string{"Prometheus"},
+		TemplateHostGroups:     []string{"Templates"},
+		ItemDefaultApplication: "prometheus",
+		HostAlertsDir:          "./testdata/testsAdopt/",
+		PrometheusUrl:          prom.URL,
+	}}
+	p, err := provisioner.New("", "prometheus", ts.URL, "user", "password", hosts, provisioner.Options{Adopt: adopt})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := p.Run(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return z
+}
+
+func TestLoadDataFromZabbixSkipsUnmanaged(t *testing.T) {
+	z := runAdopt(t, false)
+
+	_, byMethod := z.callCount()
+	for method, n := range byMethod {
+		if !strings.HasSuffix(method, ".get") && method != "user.login" && method != "APIInfo.version" && n != 0 {
+			t.Errorf("Expected the unmanaged objects to be left untouched, got %d %s calls", n, method)
+		}
+	}
+}
+
+func TestLoadDataFromZabbixAdopt(t *testing.T) {
+	z := runAdopt(t, true)
+
+	var templates []struct {
+		Description string `json:"description"`
+	}
+	var items []struct {
+		Key         string `json:"key_"`
+		Description string `json:"description"`
+	}
+	var triggers, hosts []struct {
+		Tags []zabbix.Tag `json:"tags"`
+	}
+	for method, v := range map[string]interface{}{
+		"template.update": &templates,
+		"item.update":     &items,
+		"trigger.update":  &triggers,
+		"host.update":     &hosts,
+	} {
+		changed := z.changed(method)
+		if len(changed) != 1 {
+			t.Fatalf("Expected one %s call, got %d", method, len(changed))
+		}
+		if err := json.Unmarshal(changed[0], v); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	if len(templates) != 1 || !provisioner.HasManagedMarker(templates[0].Description) {
+		t.Errorf("Expected the template to be marked, got %+v", templates)
+	}
+	if len(items) != 1 || items[0].Key != "prometheus.alert0" || !provisioner.HasManagedMarker(items[0].Description) {
+		t.Errorf("Expected the item to be marked, got %+v", items)
+	}
+	if len(triggers) != 1 || !provisioner.IsManaged(triggers[0].Tags) {
+		t.Errorf("Expected the trigger to be tagged, got %+v", triggers)
+	}
+	if len(hosts) != 1 || !provisioner.IsManaged(hosts[0].Tags) {
+		t.Errorf("Expected the host to be tagged, got %+v", hosts)
+	}
+	_, byMethod := z.callCount()
+	for _, method := range []string{"item.create", "trigger.create", "host.create", "template.create"} {
+		if byMethod[method] != 0 {
+			t.Errorf("Expected the adopted objects not to be created again, got %d %s calls", byMethod[method], method)
+		}
+	}
+}
@@ -1,69 +1,404 @@
 package provisioner
 
 import (
+	"encoding/json"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
 	yaml "gopkg.in/yaml.v2"
 )
 
 //PrometheusAlertRules struct for grouping rules
 type PrometheusAlertRules struct {
-	Groups []struct {
-		Rules []PrometheusRule `yaml:"rules"`
-	} `yaml:"groups"`
+	Groups []PrometheusRuleGroup `yaml:"groups"`
+}
+
+//PrometheusRuleGroup group of rules
+type PrometheusRuleGroup struct {
+	Name     string            `yaml:"name"`
+	Interval string            `yaml:"interval"`
+	Labels   map[string]string `yaml:"labels"`
+	Rules    []PrometheusRule  `yaml:"rules"`
 }
 
 //PrometheusRule struct representing
 type PrometheusRule struct {
-	Name        string            `yaml:"alert"`
-	Annotations map[string]string `yaml:"annotations"`
-	Expression  string            `yaml:"expr"`
-	Labels      map[string]string `yaml:"labels"`
+	Name          string            `yaml:"alert"`
+	Annotations   map[string]string `yaml:"annotations"`
+	Expression    string            `yaml:"expr"`
+	For           string            `yaml:"for"`
+	KeepFiringFor string            `yaml:"keep_firing_for"`
+	Labels        map[string]string `yaml:"labels"`
+
+	// Group fields are copied from the group the rule belongs to
+	Group         string            `yaml:"-"`
+	GroupInterval string            `yaml:"-"`
+	GroupLabels   map[string]string `yaml:"-"`
+
+	// File and Document tell where the rule was loaded from, Document is 1 based
+	File     string `yaml:"-"`
+	Document int    `yaml:"-"`
+}
+
+//AllLabels returns the group labels overridden by the rule labels, like Prometheus attaches them to alerts
+func (r PrometheusRule) AllLabels() map[string]string {
+	if len(r.GroupLabels) == 0 {
+		return r.Labels
+	}
+
+	labels := make(map[string]string, len(r.GroupLabels)+len(r.Labels))
+	for k, v := range r.GroupLabels {
+		labels[k] = v
+	}
+	for k, v := range r.Labels {
+		labels[k] = v
+	}
+	return labels
 }
 
-//LoadPrometheusRulesFromDir function for loading prometheus rule from given directory
+//setGroup copies the group fields to the rule
+func (r *PrometheusRule) setGroup(group PrometheusRuleGroup) {
+	r.Group = group.Name
+	r.GroupInterval = group.Interval
+	r.GroupLabels = group.Labels
+}
+
+//Source returns the location of the rule for error messages
+func (r PrometheusRule) Source() string {
+	if r.Document == 0 {
+		return r.File
+	}
+	return fmt.Sprintf("%s (document %d)", r.File, r.Document)
+}
+
+//ruleDocument YAML document holding rules: a plain rule file, a PrometheusRule custom resource or a List of them
+type ruleDocument struct {
+	Kind   string                `yaml:"kind"`
+	Groups []PrometheusRuleGroup `yaml:"groups"`
+	Spec   PrometheusAlertRules  `yaml:"spec"`
+	Items  []ruleDocument        `yaml:"items"`
+}
+
+//ruleGroups returns the groups of the document, documents of other kinds (ConfigMap, Deployment...) have none
+func (d ruleDocument) ruleGroups() []PrometheusRuleGroup {
+	switch d.Kind {
+	case "":
+		return d.Groups
+	case "PrometheusRule":
+		return d.Spec.Groups
+	case "List":
+		var groups []PrometheusRuleGroup
+		for _, item := range d.Items {
+			groups = append(groups, item.ruleGroups()...)
+		}
+		return groups
+	default:
+		return nil
+	}
+}
+
+//LoadPrometheusRulesFromDir function for loading prometheus rule from given directory and its subdirectories
 func LoadPrometheusRulesFromDir(dir string) ([]PrometheusRule, error) {
-	filesInDir, err := ioutil.ReadDir(dir)
+	var rules []PrometheusRule
+
+	err := walkRuleFiles(dir, func(path string) error {
+		fileRules, err := LoadPrometheusRulesFromFile(path)
+		if err != nil {
+			return err
+		}
+		rules = append(rules, fileRules...)
+		return nil
+	})
 	if err != nil {
-		return nil, errors.Wrapf(err, "can't open the alerts files directory")
+		return nil, errors.Wrapf(err, "can't load the alerts files directory: %s", dir)
 	}
 
-	var rules []PrometheusRule
+	return rules, nil
+}
 
-	for _, file := range filesInDir {
-		if strings.HasSuffix(file.Name(), ".yml") || strings.HasSuffix(file.Name(), ".yaml") {
-			alertsFile, err := ioutil.ReadFile(filepath.Join(dir, file.Name()))
-			if err != nil {
-				return nil, errors.Wrapf(err, "can't open the alerts file: %s", file.Name())
+//walkRuleFiles calls fn for the YAML files in the directory and its subdirectories
+func walkRuleFiles(dir string, fn func(path string) error) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		// Skip hidden directories like the ..data of Kubernetes ConfigMap volumes, files are linked from there
+		if info.IsDir() {
+			if path != dir && strings.HasPrefix(info.Name(), ".") {
+				return filepath.SkipDir
 			}
+			return nil
+		}
 
-			ruleConfig := PrometheusAlertRules{}
+		if strings.HasSuffix(info.Name(), ".yml") || strings.HasSuffix(info.Name(), ".yaml") {
+			return fn(path)
+		}
+		return nil
+	})
+}
 
-			err = yaml.Unmarshal(alertsFile, &ruleConfig)
-			if err != nil {
-				return nil, errors.Wrapf(err, "can't read the alerts file: %s", file.Name())
-			}
-			for _, rule := range ruleConfig.Groups {
-				for _, alert := range rule.Rules {
-					if alert.Name != "" {
-						rules = append(rules, alert)
-					}
+//LoadPrometheusRulesFromFile function for loading prometheus rules from a file.
+// The file can hold several YAML documents, each of them either a rule file or a PrometheusRule manifest.
+func LoadPrometheusRulesFromFile(filename string) ([]PrometheusRule, error) {
+	alertsFile, err := os.Open(filename)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't open the alerts file: %s", filename)
+	}
+	defer alertsFile.Close()
+
+	var rules []PrometheusRule
+
+	dec := yaml.NewDecoder(alertsFile)
+	for document := 1; ; document++ {
+		var doc ruleDocument
+		err := dec.Decode(&doc)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "can't read the alerts file: %s, document: %d", filename, document)
+		}
+
+		for _, group := range doc.ruleGroups() {
+			for _, alert := range group.Rules {
+				if alert.Name != "" {
+					alert.setGroup(group)
+					alert.File = filename
+					alert.Document = document
+					rules = append(rules, alert)
 				}
 			}
-
 		}
 	}
 
-	for i := 0; i < len(rules); i++ {
-		for j := i + 1; j < len(rules); j++ {
-			if rules[j].Name == rules[i].Name {
-				return nil, errors.Errorf("can't load rules with the same alertname: %v, index: %v, %v", rules[j].Name, i+1, j+1)
+	return rules, nil
+}
+
+//rulesAPIPath path of the Prometheus rules API
+const rulesAPIPath = "/api/v1/rules"
+
+//PrometheusRulesResponse response of the Prometheus /api/v1/rules endpoint
+type PrometheusRulesResponse struct {
+	Status string `json:"status"`
+	Error  string `json:"error"`
+	Data   struct {
+		Groups []struct {
+			Name     string  `json:"name"`
+			File     string  `json:"file"`
+			Interval float64 `json:"interval"`
+			Rules    []struct {
+				Type          string            `json:"type"`
+				Name          string            `json:"name"`
+				Query         string            `json:"query"`
+				Duration      float64           `json:"duration"`
+				KeepFiringFor float64           `json:"keepFiringFor"`
+				Labels        map[string]string `json:"labels"`
+				Annotations   map[string]string `json:"annotations"`
+			} `json:"rules"`
+		} `json:"groups"`
+	} `json:"data"`
+}
+
+//LoadPrometheusRulesFromAPI function for loading alerting rules from Prometheus compatible /api/v1/rules endpoints.
+// Rules are deduplicated, so the same rule served by several replicas is loaded once.
+func LoadPrometheusRulesFromAPI(client *http.Client, urls []string) ([]PrometheusRule, error) {
+	var rules []PrometheusRule
+	seen := map[string]struct{}{}
+
+	for _, u := range urls {
+		apiURL := prometheusAPIURL(u, rulesAPIPath)
+		resp, err := client.Get(apiURL + "?type=alert")
+		if err != nil {
+			return nil, errors.Wrapf(err, "can't get the rules: %s", apiURL)
+		}
+
+		data, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, errors.Wrapf(err, "can't read the rules response: %s", apiURL)
+		}
+
+		var rulesResp PrometheusRulesResponse
+		if err := json.Unmarshal(data, &rulesResp); err != nil {
+			return nil, errors.Wrapf(err, "can't decode the rules response: %s, status code: %d", apiURL, resp.StatusCode)
+		}
+		if resp.StatusCode != http.StatusOK || rulesResp.Status != "success" {
+			return nil, errors.Errorf("error getting the rules: %s, status code: %d, error: %s", apiURL, resp.StatusCode, rulesResp.Error)
+		}
+
+		for _, group := range rulesResp.Data.Groups {
+			for _, rule := range group.Rules {
+				if rule.Type != "alerting" || rule.Name == "" {
+					continue
+				}
+
+				alert := PrometheusRule{
+					Name:          rule.Name,
+					Expression:    rule.Query,
+					For:           secondsToDuration(rule.Duration),
+					KeepFiringFor: secondsToDuration(rule.KeepFiringFor),
+					Labels:        rule.Labels,
+					Annotations:   rule.Annotations,
+					Group:         group.Name,
+					GroupInterval: secondsToDuration(group.Interval),
+					File:          fmt.Sprintf("%s %s", apiURL, group.File),
+				}
+
+				id := ruleID(group.File, group.Name, alert)
+				if _, ok := seen[id]; ok {
+					continue
+				}
+				seen[id] = struct{}{}
+				rules = append(rules, alert)
 			}
 		}
 	}
 
 	return rules, nil
 }
+
+//ruleID identifies a rule across Prometheus replicas
+func ruleID(file, group string, rule PrometheusRule) string {
+	labels := make([]string, 0, len(rule.Labels))
+	for k, v := range rule.Labels {
+		labels = append(labels, fmt.Sprintf("%s=%q", k, v))
+	}
+	sort.Strings(labels)
+	return fmt.Sprintf("%s/%s/%s/%s/{%s}", file, group, rule.Name, rule.Expression, strings.Join(labels, ","))
+}
+
+//secondsToDuration formats durations reported by the Prometheus API in seconds, zero is empty like in rule files
+func secondsToDuration(seconds float64) string {
+	if seconds == 0 {
+		return ""
+	}
+	return model.Duration(time.Duration(seconds * float64(time.Second))).String()
+}
+
+//targetsAPIPath path of the Prometheus targets API
+const targetsAPIPath = "/api/v1/targets"
+
+//Targets structure for Prometheus api/v1/targets resposce
+type Targets struct {
+	Status string `json:"status"`
+	Error  string `json:"error"`
+	Data   struct {
+		ActiveTargets []struct {
+			DiscoveredLabels map[string]string `json:"discoveredLabels"`
+			Labels           map[string]string `json:"labels"`
+			ScrapePool       string            `json:"scrapePool"`
+			ScrapeURL        string            `json:"scrapeUrl"`
+			LastError        string            `json:"lastError"`
+			LastScrape       time.Time         `json:"lastScrape"`
+			Health           string            `json:"health"`
+		} `json:"activeTargets"`
+		DroppedTargets []interface{} `json:"droppedTargets"`
+	} `json:"data"`
+}
+
+//PrometheusTarget active Prometheus target, Host is the instance without the port
+type PrometheusTarget struct {
+	Host   string
+	Labels map[string]string
+}
+
+//TargetSelector selects targets by their labels, values are anchored regular expressions
+type TargetSelector map[string]*regexp.Regexp
+
+//NewTargetSelector compiles the label selector
+func NewTargetSelector(selector map[string]string) (TargetSelector, error) {
+	ts := make(TargetSelector, len(selector))
+	for label, expr := range selector {
+		re, err := regexp.Compile("^(?:" + expr + ")$")
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid selector for label %s", label)
+		}
+		ts[label] = re
+	}
+	return ts, nil
+}
+
+//Matches reports whether all the selector expressions match the labels, missing labels match as empty
+func (ts TargetSelector) Matches(labels map[string]string) bool {
+	for label, re := range ts {
+		if !re.MatchString(labels[label]) {
+			return false
+		}
+	}
+	return true
+}
+
+//LoadPrometheusTargets function for loading the active targets matching the selector from Prometheus.
+// Targets with the same host are loaded once.
+func LoadPrometheusTargets(client *http.Client, address string, selector TargetSelector) ([]PrometheusTarget, error) {
+	apiURL := prometheusAPIURL(address, targetsAPIPath)
+	resp, err := client.Get(apiURL + "?state=active")
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't get the targets: %s", apiURL)
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't read the targets response: %s", apiURL)
+	}
+
+	var targetsResp Targets
+	if err := json.Unmarshal(data, &targetsResp); err != nil {
+		return nil, errors.Wrapf(err, "can't decode the targets response: %s, status code: %d", apiURL, resp.StatusCode)
+	}
+	if resp.StatusCode != http.StatusOK || targetsResp.Status != "success" {
+		return nil, errors.Errorf("error getting the targets: %s, status code: %d, error: %s", apiURL, resp.StatusCode, targetsResp.Error)
+	}
+
+	var targets []PrometheusTarget
+	seen := map[string]struct{}{}
+	for _, active := range targetsResp.Data.ActiveTargets {
+		if !selector.Matches(active.Labels) {
+			continue
+		}
+
+		host := TargetHost(active.Labels["instance"])
+		if host == "" {
+			continue
+		}
+		if _, ok := seen[host]; ok {
+			continue
+		}
+		seen[host] = struct{}{}
+
+		targets = append(targets, PrometheusTarget{Host: host, Labels: active.Labels})
+	}
+
+	return targets, nil
+}
+
+//TargetHost returns the host of the instance label.
+// Handles host:port, [ipv6]:port, bare IPv6 addresses, hosts without port and URLs of blackbox style targets.
+func TargetHost(instance string) string {
+	instance = strings.TrimSpace(instance)
+	if strings.Contains(instance, "://") {
+		if u, err := url.Parse(instance); err == nil {
+			return u.Hostname()
+		}
+	}
+
+	if host, _, err := net.SplitHostPort(instance); err == nil {
+		return host
+	}
+
+	return strings.TrimSuffix(strings.TrimPrefix(instance, "["), "]")
+}
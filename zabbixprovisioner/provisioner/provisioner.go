@@ -1,13 +1,12 @@
 package provisioner
 
 import (
-	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/url"
-	"os"
 	"strings"
+	"sync"
 	"time"
 
 	zabbix "github.com/neogan74/zabbix-alertmanager/zabbixprovisioner/zabbixclient"
@@ -30,32 +29,15 @@ type HostConfig struct {
 	HostAlertsDir           string            `yaml:"alertsDir"`
 	TriggerTags             map[string]string `yaml:"triggerTags"`
 	PrometheusUrl           string            `yaml:"prometheusUrl"`
-}
-
-//Targets structure for Prometheus api/v1/targets resposce
-type Targets struct {
-	Status string `json:"status"`
-	Data   struct {
-		ActiveTargets []struct {
-			DiscoveredLabels struct {
-				Address     string `json:"__address__"`
-				MetricsPath string `json:"__metrics_path__"`
-				Scheme      string `json:"__scheme__"`
-				Group       string `json:"group"`
-				Job         string `json:"job"`
-			} `json:"discoveredLabels"`
-			Labels struct {
-				Group    string `json:"group"`
-				Instance string `json:"instance"`
-				Job      string `json:"job"`
-			} `json:"labels"`
-			ScrapeURL  string    `json:"scrapeUrl"`
-			LastError  string    `json:"lastError"`
-			LastScrape time.Time `json:"lastScrape"`
-			Health     string    `json:"health"`
-		} `json:"activeTargets"`
-		DroppedTargets []interface{} `json:"droppedTargets"`
-	} `json:"data"`
+	SeverityMapping         SeverityMapping   `yaml:"severityMapping"`
+	RulesURLs               []string          `yaml:"rulesUrls"`
+	PrometheusHTTPConfig    HTTPClientConfig  `yaml:"prometheusHttpConfig"`
+	ItemKey                 ItemKeyConfig     `yaml:"itemKey"`
+	GroupApplications       bool              `yaml:"groupApplications"`
+	TargetSelector          map[string]string `yaml:"targetSelector"`
+	HostTemplate            HostTemplate      `yaml:"hostTemplate"`
+	// Hosts Zabbix hosts zal send pushes the alerts of this config to, zal prov links them to the template
+	Hosts []string `yaml:"hosts"`
 }
 
 //Provisioner structure for syncronization objects between zabbix and prometheus alerts rules.
@@ -64,6 +46,14 @@ type Provisioner struct {
 	keyPrefix     string
 	hosts         []HostConfig
 	prometheusURL string
+	cleanup       CleanupConfig
+	adopt         bool
+	concurrency   int
+	onFailure     FailurePolicy
+	// since when the objects are orphaned, used for the cleanup grace period
+	orphans map[string]time.Time
+	// ready is set to 1 when the last run succeeded, accessed atomically
+	ready int32
 	*CustomZabbix
 }
 
@@ -74,27 +64,93 @@ gets parameters:
   ur; - Zabbix API URL
   user,password -  Zabbix API credentails
   hosts - list of hosts which will be created, updated in zabbix
+  options - cleanup of orphaned objects and adoption of existing objects
 */
-func New(prometheusURL, keyPrefix, url, user, password string, hosts []HostConfig) (*Provisioner, error) {
-	transport := http.DefaultTransport
-	//Zabbix API init
-	api := zabbix.NewAPI(url)
-	api.SetClient(&http.Client{
-		Transport: transport,
-	})
-	// try to login
-	_, err := api.Login(user, password)
+func New(prometheusURL, keyPrefix, url, user, password string, hosts []HostConfig, options Options) (*Provisioner, error) {
+	cleanup := options.Cleanup.WithDefaults()
+	if err := cleanup.Validate(); err != nil {
+		return nil, errors.Wrap(err, "invalid cleanup config")
+	}
+	onFailure := options.OnFailure
+	if onFailure == "" {
+		onFailure = FailureStop
+	}
+	if err := onFailure.Validate(); err != nil {
+		return nil, err
+	}
+
+	api, err := NewZabbixAPI(url, user, password, options)
 	if err != nil {
-		return nil, errors.Wrap(err, "error while login to zabbix api")
+		return nil, err
 	}
 	return &Provisioner{
 		api:           api,
 		keyPrefix:     keyPrefix,
 		hosts:         hosts,
 		prometheusURL: prometheusURL,
+		cleanup:       cleanup,
+		adopt:         options.Adopt,
+		concurrency:   options.Concurrency,
+		onFailure:     onFailure,
+		orphans:       map[string]time.Time{},
 	}, nil
 }
 
+//NewZabbixAPI creates the Zabbix API client configured by the options and logs in,
+// with the API token of the options when there is one
+func NewZabbixAPI(url, user, password string, options Options) (*zabbix.API, error) {
+	transport := http.DefaultTransport
+	//Zabbix API init
+	api := zabbix.NewAPI(url)
+	api.SetClient(&http.Client{
+		Transport: transport,
+	})
+	retry := zabbix.DefaultRetryConfig
+	if options.Retries < 0 {
+		retry.Retries = 0
+	} else if options.Retries > 0 {
+		retry.Retries = options.Retries
+	}
+	api.SetRetry(retry)
+	api.SetTimeout(options.Timeout)
+	// try to login, with the API token when there is one
+	if options.TokenFile != "" {
+		token, err := readSecretFile(options.TokenFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "error reading zabbix api token")
+		}
+		if err := api.LoginWithToken(token); err != nil {
+			return nil, errors.Wrap(err, "error while login to zabbix api with token")
+		}
+	} else if _, err := api.Login(user, password); err != nil {
+		return nil, errors.Wrap(err, "error while login to zabbix api")
+	}
+	return api, nil
+}
+
+//Options optional settings of the provisioner
+type Options struct {
+	Cleanup CleanupConfig
+	// Adopt takes over existing objects matching the provisioned ones which aren't marked as managed by zal
+	Adopt bool
+	// Concurrency limits the concurrent Zabbix API reads, values below 2 read sequentially
+	Concurrency int
+	// OnFailure decides whether to stop or to continue with the next template or host when one fails, stop by default
+	OnFailure FailurePolicy
+	// TokenFile holds a Zabbix API token used instead of the user and password, requires Zabbix 5.4 or newer
+	TokenFile string
+	// Timeout of every Zabbix API request, 0 disables it
+	Timeout time.Duration
+	// Retries of the Zabbix API requests failing with transport errors, 5xx or 429 responses,
+	// 0 keeps the default of the client and a negative value disables them
+	Retries int
+}
+
+//Logout ends the Zabbix API session
+func (p *Provisioner) Logout() error {
+	return errors.Wrap(p.api.Logout(), "error while logout from zabbix api")
+}
+
 //LoadHostConfigFromFile function
 func LoadHostConfigFromFile(filename string) ([]HostConfig, error) {
 	configFile, err := ioutil.ReadFile(filename)
@@ -120,24 +176,108 @@ func (p *Provisioner) Run() error {
 		HostGroups: map[string]*CustomHostGroup{},
 	}
 
-	//
-
-	//All hosts will have the rules which were only written for them
+	// The desired state of all the configs is loaded first, so one diff covers all the templates and hosts
 	for _, host := range p.hosts {
 		if err := p.LoadRulesFromPrometheus(host); err != nil {
-			return errors.Wrapf(err, "error loading prometheus rules, file: %s", host.HostAlertsDir)
+			return errors.Wrapf(err, "error loading prometheus rules, file: %s, urls: %v", host.HostAlertsDir, host.RulesURLs)
 		}
 		if err := p.LoadTargetsFromPrometheus(host); err != nil {
-			return errors.Wrapf(err, "error loading prometheus targets from given URL: %s", p.prometheusURL)
+			return errors.Wrapf(err, "error loading prometheus targets from given URL: %s", host.PrometheusUrl)
 		}
+	}
+
+	if err := p.LoadDataFromZabbix(); err != nil {
+		return errors.Wrap(err, "error loading zabbix rules")
+	}
+	p.linkProvisionedHosts()
 
-		if err := p.LoadDataFromZabbix(); err != nil {
-			return errors.Wrap(err, "error loading zabbix rules")
+	if err := p.ApplyChanges(); err != nil {
+		return errors.Wrap(err, "error applying changes")
+	}
+	if err := p.linkListedHosts(); err != nil {
+		return errors.Wrap(err, "error linking the hosts of the configs")
+	}
+	return nil
+}
+
+//linkProvisionedHosts adds the templates to the provisioned hosts listed in the hosts field of their configs,
+// so the host updates keep them linked
+func (p *Provisioner) linkProvisionedHosts() {
+	for _, hostConfig := range p.hosts {
+		for _, name := range hostConfig.Hosts {
+			for _, host := range p.Hosts {
+				if host.Host.Host == name && host.State != StateOld {
+					host.Templates[hostConfig.Name] = struct{}{}
+				}
+			}
 		}
+	}
+}
 
-		if err := p.ApplyChanges(); err != nil {
-			return errors.Wrap(err, "error applying changes")
+//linkListedHosts links the templates to the other hosts listed in the hosts field of their configs,
+// the templates already linked to the hosts stay linked. Hosts missing in Zabbix are skipped with a warning.
+func (p *Provisioner) linkListedHosts() error {
+	provisioned := map[string]bool{}
+	for _, host := range p.Hosts {
+		if host.State != StateOld {
+			provisioned[host.Host.Host] = true
+		}
+	}
+	var names []string
+	for _, hostConfig := range p.hosts {
+		for _, name := range hostConfig.Hosts {
+			if !provisioned[name] {
+				names = append(names, name)
+			}
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+
+	zabbixHosts, err := p.api.HostsGet(zabbix.Params{
+		"output":                []string{"hostid", "host"},
+		"filter":                map[string]interface{}{"host": names},
+		"selectParentTemplates": []string{"templateid", "host"},
+	})
+	if err != nil {
+		return errors.Wrap(err, "can't get the hosts")
+	}
+	hostsByName := make(map[string]zabbix.Host, len(zabbixHosts))
+	for _, host := range zabbixHosts {
+		hostsByName[host.Host] = host
+	}
+
+	for _, hostConfig := range p.hosts {
+		template, ok := p.Templates[hostConfig.Name]
+		if !ok || template.TemplateID == "" {
+			continue
+		}
+		var hostIDs []string
+		for _, name := range hostConfig.Hosts {
+			if provisioned[name] {
+				continue
+			}
+			host, ok := hostsByName[name]
+			if !ok {
+				log.Warnf("host %s of %s doesn't exist in Zabbix, it isn't linked to the template", name, hostConfig.Name)
+				continue
+			}
+			linked := false
+			for _, parent := range host.ParentTemplates {
+				linked = linked || parent.TemplateID == template.TemplateID
+			}
+			if !linked {
+				hostIDs = append(hostIDs, host.HostID)
+			}
+		}
+		if len(hostIDs) == 0 {
+			continue
 		}
+		if err := p.api.TemplatesLinkHosts(zabbix.TemplateIDs{{TemplateID: template.TemplateID}}, hostIDs); err != nil {
+			return errors.Wrapf(err, "can't link template %s to its hosts", hostConfig.Name)
+		}
+		log.Infof("linked template %s to %d hosts", hostConfig.Name, len(hostIDs))
 	}
 	return nil
 }
@@ -147,60 +287,65 @@ func (p *Provisioner) LoadTargetsFromPrometheus(hostConfig HostConfig) error {
 	log.Debugln("===================================================================")
 	log.Debugln("=======================LoadTargetsFromPrometheus=====================")
 	log.Debugln("===================================================================")
-	targetsPath := "/api/v1/targets"
-	promURL := fmt.Sprintf("%s%s%s", "http://", hostConfig.PrometheusUrl, targetsPath)
-	resp, err := http.Get(promURL)
-	if err != nil {
-		log.Infof("Error while get targets: %v\n", err)
-		os.Exit(1)
+	if hostConfig.PrometheusUrl == "" {
+		log.Debugf("no prometheusUrl defined for %s, skipping targets", hostConfig.Name)
+		return nil
 	}
-	defer resp.Body.Close()
 
-	data, err := ioutil.ReadAll(resp.Body)
+	client, err := hostConfig.PrometheusHTTPConfig.NewClient()
 	if err != nil {
-		log.Fatalf("Error with body: %v\n", err)
+		return errors.Wrap(err, "error creating prometheus client")
 	}
-	var targets []string
-	var targetsjs Targets
-	err = json.Unmarshal(data, &targetsjs)
+
+	selector, err := NewTargetSelector(hostConfig.TargetSelector)
 	if err != nil {
-		log.Fatalf("Error while JSON unmarshal %s", err)
+		return errors.Wrapf(err, "error in target selector of: %s", hostConfig.Name)
 	}
-	for _, v := range targetsjs.Data.ActiveTargets {
-		targets = append(targets, v.Labels.Instance[:strings.LastIndex(v.Labels.Instance, ":")])
+
+	promTargets, err := LoadPrometheusTargets(client, hostConfig.PrometheusUrl, selector)
+	if err != nil {
+		return errors.Wrap(err, "error loading targets")
 	}
-	log.Infof("targets list: %v", targets)
-	for _, trg := range targets {
-		newHost := &CustomHost{
-			State: StateNew,
-			Host: zabbix.Host{
-				Host:      string(trg),
-				Available: 1,
-				Name:      trg,
-				Status:    0,
-				Interfaces: zabbix.HostInterfaces{
-					zabbix.HostInterface{
-						DNS:   "",
-						IP:    "127.0.0.1",
-						Main:  1,
-						Port:  "10050",
-						Type:  1,
-						UseIP: 1,
-					},
-				},
-			},
-			HostGroups: make(map[string]struct{}, 1),
-		}
 
+	log.Infof("targets list: %d targets for %s", len(promTargets), hostConfig.Name)
+	for _, target := range promTargets {
+		host, err := hostConfig.TargetHost(target)
+		if err != nil {
+			return errors.Wrapf(err, "error in host template of: %s", hostConfig.Name)
+		}
 		for _, hostGroupName := range hostConfig.HostGroups {
 			p.AddHostGroup(&CustomHostGroup{
 				State: StateNew,
 				HostGroup: zabbix.HostGroup{
 					Name: hostGroupName,
 				}})
+		}
+
+		if existing, ok := p.Hosts[host.Name]; ok {
+			if _, ok := existing.Templates[hostConfig.Name]; ok {
+				log.Warnf("host %s is rendered from more targets, skipping target: %s", host.Name, target.Host)
+				continue
+			}
+			// The target of more configs is one host linked to all their templates
+			existing.Templates[hostConfig.Name] = struct{}{}
+			for _, hostGroupName := range hostConfig.HostGroups {
+				existing.HostGroups[hostGroupName] = struct{}{}
+			}
+			continue
+		}
+
+		host.Tags = withManagedTag(host.Tags)
+
+		newHost := &CustomHost{
+			State:      StateNew,
+			Host:       host,
+			HostGroups: make(map[string]struct{}, len(hostConfig.HostGroups)),
+			Templates:  map[string]struct{}{hostConfig.Name: {}},
+		}
+		for _, hostGroupName := range hostConfig.HostGroups {
 			newHost.HostGroups[hostGroupName] = struct{}{}
-			log.Debugf("Host from Prometheus: %+v", newHost)
 		}
+		log.Debugf("Host from Prometheus: %+v", newHost)
 		p.AddHost(newHost)
 	}
 	return nil
@@ -211,18 +356,43 @@ func (p *Provisioner) LoadRulesFromPrometheus(hostConfig HostConfig) error {
 	log.Debugln("===================================================================")
 	log.Debugln("=======================LoadRulesFromPrometheus=====================")
 	log.Debugln("===================================================================")
-	rules, err := LoadPrometheusRulesFromDir(hostConfig.HostAlertsDir)
-	if err != nil {
-		return errors.Wrap(err, "error loading rules")
+	if hostConfig.HostAlertsDir == "" && len(hostConfig.RulesURLs) == 0 {
+		return errors.Errorf("error no alertsDir or rulesUrls are defined for: %s", hostConfig.Name)
+	}
+
+	var rules []PrometheusRule
+	if hostConfig.HostAlertsDir != "" {
+		dirRules, err := LoadPrometheusRulesFromDir(hostConfig.HostAlertsDir)
+		if err != nil {
+			return errors.Wrap(err, "error loading rules")
+		}
+		rules = append(rules, dirRules...)
+	}
+
+	if len(hostConfig.RulesURLs) != 0 {
+		client, err := hostConfig.PrometheusHTTPConfig.NewClient()
+		if err != nil {
+			return errors.Wrap(err, "error creating prometheus client")
+		}
+		apiRules, err := LoadPrometheusRulesFromAPI(client, hostConfig.RulesURLs)
+		if err != nil {
+			return errors.Wrap(err, "error loading rules from api")
+		}
+		rules = append(rules, apiRules...)
 	}
 
 	log.Infof("Prometheus Rules for template - %v loaded: %v", hostConfig.Name, len(rules))
 
+	if err := hostConfig.ItemKey.Validate(); err != nil {
+		return errors.Wrapf(err, "error in item key config of: %s", hostConfig.Name)
+	}
+
 	newTemplate := &CustomTemplate{
 		State: StateNew,
 		Template: zabbix.Template{
 			Name:        hostConfig.Name,
 			DisplayName: hostConfig.Name,
+			Description: ManagedMarker,
 		},
 		HostGroups:   make(map[string]struct{}, 1),
 		Items:        map[string]*CustomItem{},
@@ -243,86 +413,132 @@ func (p *Provisioner) LoadRulesFromPrometheus(hostConfig HostConfig) error {
 	// Parse Prometheus rules and create corresponding items/triggers and applications for this host
 	for _, rule := range rules {
 		log.Debugf("Prom rule: %+v", rule)
-		key := fmt.Sprintf("%s.%s", strings.ToLower(p.keyPrefix), strings.ToLower(rule.Name))
-
-		var triggerTags []zabbix.Tag
-		for k, v := range hostConfig.TriggerTags {
-			triggerTags = append(triggerTags, zabbix.Tag{Tag: k, Value: v})
+		if err := p.addRule(newTemplate, hostConfig, rule); err != nil {
+			return err
 		}
+	}
+	log.Debugf("Template for Prometheus: %+v", newTemplate)
+	p.AddTemplate(newTemplate)
+	log.Debugf("------------Templates: %+v", p.Templates["prom2zbx"])
 
-		newItem := &CustomItem{
-			State: StateNew,
-			Item: zabbix.Item{
-				Name:         rule.Name,
-				Key:          key,
-				HostID:       "", //To be filled when the host will be created
-				Type:         2,  //Trapper
-				ValueType:    3,
-				History:      hostConfig.ItemDefaultHistory,
-				Trends:       hostConfig.ItemDefaultTrends,
-				TrapperHosts: hostConfig.ItemDefaultTrapperHosts,
-			},
-			Applications: map[string]struct{}{},
-		}
+	return nil
+}
 
-		newTrigger := &CustomTrigger{
-			State: StateNew,
-			Trigger: zabbix.Trigger{
-				Description: rule.Name,
-				Expression:  fmt.Sprintf("{%s:%s.last()}>0", newTemplate.Name, key),
-				ManualClose: 1,
-			},
-		}
+//addRule computes the item key of the rule and adds its items and triggers to the template
+func (p *Provisioner) addRule(newTemplate *CustomTemplate, hostConfig HostConfig, rule PrometheusRule) error {
+	key, err := hostConfig.ItemKey.RuleKey(p.keyPrefix, rule)
+	if err != nil {
+		return errors.Wrapf(err, "error computing item key, rule source: %s", rule.Source())
+	}
+	labels := rule.AllLabels()
 
-		if p.prometheusURL != "" {
-			newTrigger.URL = p.prometheusURL + "/alerts"
+	if !hostConfig.SeverityMapping.Dynamic {
+		return p.addRuleToTemplate(newTemplate, hostConfig, rule, key, hostConfig.SeverityMapping.Priority(labels))
+	}
 
-			url := p.prometheusURL + "/graph?g0.expr=" + url.QueryEscape(rule.Expression)
-			if len(url) < 255 {
-				newTrigger.URL = url
-			}
+	// With dynamic severity every priority gets its own item, zal send picks one by the alert labels
+	priorities := []zabbix.PriorityType{hostConfig.SeverityMapping.Priority(labels)}
+	if hostConfig.SeverityMapping.IsDynamic(labels) {
+		priorities = hostConfig.SeverityMapping.Priorities()
+	}
+	for _, priority := range priorities {
+		if err := p.addRuleToTemplate(newTemplate, hostConfig, rule, key+"."+PriorityName(priority), priority); err != nil {
+			return err
 		}
+	}
+	return nil
+}
 
-		if v, ok := rule.Annotations["summary"]; ok {
-			newTrigger.Comments = v
-		} else if v, ok := rule.Annotations["message"]; ok {
-			newTrigger.Comments = v
-		} else if v, ok := rule.Annotations["description"]; ok {
-			newTrigger.Comments = v
-		}
+//addRuleToTemplate creates the item and the trigger for the rule
+func (p *Provisioner) addRuleToTemplate(newTemplate *CustomTemplate, hostConfig HostConfig, rule PrometheusRule, key string, priority zabbix.PriorityType) error {
+	if existing, ok := newTemplate.Items[key]; ok {
+		return errors.Errorf("can't load rules with the same item key: %s, alertname: %s, sources: %s, %s", key, rule.Name, existing.Source, rule.Source())
+	}
 
-		if v, ok := rule.Labels["severity"]; ok {
-			newTrigger.Priority = GetZabbixPriority(v)
-		}
+	newItem := &CustomItem{
+		State: StateNew,
+		Item: zabbix.Item{
+			Name:         rule.Name,
+			Key:          key,
+			HostID:       "", //To be filled when the host will be created
+			Type:         2,  //Trapper
+			ValueType:    3,
+			History:      hostConfig.ItemDefaultHistory,
+			Trends:       hostConfig.ItemDefaultTrends,
+			TrapperHosts: hostConfig.ItemDefaultTrapperHosts,
+			Description:  ManagedMarker,
+		},
+		Applications: map[string]struct{}{},
+		Source:       rule.Source(),
+	}
 
-		// Add the special "No Data" trigger if requested
-		if delay, ok := rule.Annotations["zabbix_trigger_nodata"]; ok {
-			newTrigger.Trigger.Description = fmt.Sprintf("%s - no data for the last %s seconds", newTrigger.Trigger.Description, delay)
-			newTrigger.Trigger.Expression = fmt.Sprintf("{%s:%s.nodata(%s)}", newTemplate.Name, key, delay)
-		}
+	newTrigger := &CustomTrigger{
+		State: StateNew,
+		Trigger: zabbix.Trigger{
+			Description: rule.Name,
+			Expression:  fmt.Sprintf("{%s:%s.last()}>0", newTemplate.Name, key),
+			ManualClose: 1,
+			Priority:    priority,
+			Tags:        triggerTags(hostConfig.TriggerTags),
+		},
+	}
 
-		// If no applications are found in the rule, add the default application declared in the configuration
-		if len(newItem.Applications) == 0 {
-			newTemplate.AddApplication(&CustomApplication{
-				State: StateNew,
-				Application: zabbix.Application{
-					Name: hostConfig.ItemDefaultApplication,
-				},
-			})
-			newItem.Applications[hostConfig.ItemDefaultApplication] = struct{}{}
+	if p.prometheusURL != "" {
+		newTrigger.URL = p.prometheusURL + "/alerts"
+
+		url := p.prometheusURL + "/graph?g0.expr=" + url.QueryEscape(rule.Expression)
+		if len(url) < 255 {
+			newTrigger.URL = url
 		}
+	}
+
+	if v, ok := rule.Annotations["summary"]; ok {
+		newTrigger.Comments = v
+	} else if v, ok := rule.Annotations["message"]; ok {
+		newTrigger.Comments = v
+	} else if v, ok := rule.Annotations["description"]; ok {
+		newTrigger.Comments = v
+	}
 
-		log.Debugf("Loading item from Prometheus: %+v", newItem)
-		newTemplate.AddItem(newItem)
+	if rule.For != "" {
+		newTrigger.Comments = strings.TrimSpace(fmt.Sprintf("%s\n\nFires after the condition is true for: %s", newTrigger.Comments, rule.For))
+	}
+	if rule.KeepFiringFor != "" {
+		newTrigger.Comments = strings.TrimSpace(fmt.Sprintf("%s\nKeeps firing for: %s", newTrigger.Comments, rule.KeepFiringFor))
+	}
 
-		log.Debugf("Loading trigger from Prometheus: %+v", newTrigger)
-		newTemplate.AddTrigger(newTrigger)
+	// Add the special "No Data" trigger if requested
+	if delay, ok := rule.Annotations["zabbix_trigger_nodata"]; ok {
+		newTrigger.Trigger.Description = fmt.Sprintf("%s - no data for the last %s seconds", newTrigger.Trigger.Description, delay)
+		newTrigger.Trigger.Expression = fmt.Sprintf("{%s:%s.nodata(%s)}", newTemplate.Name, key, delay)
+	}
 
+	if hostConfig.GroupApplications && rule.Group != "" {
+		newTemplate.AddApplication(&CustomApplication{
+			State: StateNew,
+			Application: zabbix.Application{
+				Name: rule.Group,
+			},
+		})
+		newItem.Applications[rule.Group] = struct{}{}
 	}
-	log.Debugf("Template for Prometheus: %+v", newTemplate)
-	p.AddTemplate(newTemplate)
-	log.Debugf("------------Templates: %+v", p.Templates["prom2zbx"])
 
+	// If no applications are found in the rule, add the default application declared in the configuration
+	if len(newItem.Applications) == 0 {
+		newTemplate.AddApplication(&CustomApplication{
+			State: StateNew,
+			Application: zabbix.Application{
+				Name: hostConfig.ItemDefaultApplication,
+			},
+		})
+		newItem.Applications[hostConfig.ItemDefaultApplication] = struct{}{}
+	}
+
+	log.Debugf("Loading item from Prometheus: %+v", newItem)
+	newTemplate.AddItem(newItem)
+
+	log.Debugf("Loading trigger from Prometheus: %+v", newTrigger)
+	newTemplate.AddTrigger(newTrigger)
 	return nil
 }
 
@@ -334,11 +550,15 @@ func (p *Provisioner) LoadDataFromZabbix() error {
 	hostNames := make([]string, len(p.hosts))
 	templateNames := []string{}
 	hostGroupNames := []string{}
+	targetHostGroupNames := map[string]struct{}{}
 	for i := range p.hosts {
 		hostNames[i] = p.hosts[i].Name
 		templateNames = append(templateNames, p.hosts[i].Name)
 		hostGroupNames = append(hostGroupNames, p.hosts[i].TemplateHostGroups...)
 		hostGroupNames = append(hostGroupNames, p.hosts[i].HostGroups...)
+		for _, name := range p.hosts[i].HostGroups {
+			targetHostGroupNames[name] = struct{}{}
+		}
 	}
 
 	if len(hostNames) == 0 {
@@ -369,27 +589,33 @@ func (p *Provisioner) LoadDataFromZabbix() error {
 		return errors.Errorf("error no templates are defined")
 	}
 	zabbixTemplates, err := p.api.TemplateGet(zabbix.Params{
-		"output": "extend",
+		"output":       "extend",
+		"selectGroups": []string{"groupid", "name"},
 		"filter": map[string][]string{
 			"host": templateNames,
 		},
 	})
-	log.Debugf("ZABBIX TEmpaltes: %+v\n", zabbixTemplates)
+	if err != nil {
+		return errors.Wrapf(err, "error getting templates: %v", templateNames)
+	}
 
+	templateIDs := make([]string, 0, len(zabbixTemplates))
+	templatesByID := make(map[string]*CustomTemplate, len(zabbixTemplates))
 	for _, zabbixTemplate := range zabbixTemplates {
-		zabbixHostGroups, err := p.api.HostGroupsGet(zabbix.Params{
-			"output":       "extend",
-			"selectGroups": "",
-			"templateids":  zabbixTemplate.TemplateID,
-		})
-		if err != nil {
-			return errors.Wrapf(err, "error getting hostgroup, hostid: %v", zabbixTemplate.TemplateID)
-		}
-		hostGroups := make(map[string]struct{}, len(zabbixHostGroups))
-		for _, zabbixHostGroup := range zabbixHostGroups {
+		hostGroups := make(map[string]struct{}, len(zabbixTemplate.Groups))
+		for _, zabbixHostGroup := range zabbixTemplate.Groups {
 			hostGroups[zabbixHostGroup.Name] = struct{}{}
 		}
 
+		if existing, ok := p.Templates[zabbixTemplate.Name]; ok && !HasManagedMarker(zabbixTemplate.Description) {
+			if p.adopt {
+				log.Infof("adopting template %s", zabbixTemplate.Name)
+			} else {
+				// The template only holds the managed items and triggers, its description is left untouched
+				existing.Description = zabbixTemplate.Description
+			}
+		}
+
 		oldTemplate := p.AddTemplate(&CustomTemplate{
 			State:        StateOld,
 			Template:     zabbixTemplate,
@@ -399,109 +625,210 @@ func (p *Provisioner) LoadDataFromZabbix() error {
 			Triggers:     map[string]*CustomTrigger{},
 		})
 		log.Debugf("Load template from Zabbix: %+v", oldTemplate)
-		zabbixApplications, err := p.api.ApplicationsGet(zabbix.Params{
-			"output":      "extend",
-			"templateids": oldTemplate.TemplateID,
-		})
-		if err != nil {
-			return errors.Wrapf(err, "error getting application, hostid: %v", oldTemplate.TemplateID)
+		templateIDs = append(templateIDs, oldTemplate.TemplateID)
+		templatesByID[oldTemplate.TemplateID] = oldTemplate
+	}
+
+	if p.cleanup.Templates != OrphanKeep {
+		if err := p.loadOrphanedTemplates(); err != nil {
+			return err
 		}
+	}
+
+	// Hosts are loaded from the host groups of all the configs
+	var hostGroupIDs []string
+	for name := range targetHostGroupNames {
+		if hostGroup, ok := p.HostGroups[name]; ok && hostGroup.GroupID != "" {
+			hostGroupIDs = append(hostGroupIDs, hostGroup.GroupID)
+		}
+	}
 
-		for _, zabbixApplication := range zabbixApplications {
+	// The template content and the hosts are loaded by one call per object type, the calls run concurrently
+	var (
+		zabbixApplications zabbix.Applications
+		zabbixItems        zabbix.Items
+		zabbixTriggers     zabbix.Triggers
+		zabbixHosts        zabbix.Hosts
+	)
+	var loads []func() error
+	// Empty groupids and templateids would select the objects of all the host groups and templates
+	if len(hostGroupIDs) != 0 {
+		loads = append(loads, func() error {
+			var err error
+			zabbixHosts, err = p.api.HostsGet(zabbix.Params{
+				"output":                "extend",
+				"groupids":              hostGroupIDs,
+				"selectGroups":          []string{"groupid", "name"},
+				"selectParentTemplates": []string{"templateid", "host"},
+				"selectInventory":       "extend",
+				"selectTags":            "extend",
+				"selectInterfaces":      "extend",
+			})
+			return errors.Wrapf(err, "error getting hosts of the hostgroups: %v", hostGroupIDs)
+		})
+	}
+	if len(templateIDs) != 0 {
+		loads = append(loads,
+			func() error {
+				var err error
+				zabbixApplications, err = p.api.ApplicationsGet(zabbix.Params{
+					"output":      "extend",
+					"templateids": templateIDs,
+				})
+				return errors.Wrapf(err, "error getting applications, templateids: %v", templateIDs)
+			},
+			func() error {
+				var err error
+				zabbixItems, err = p.api.ItemsGet(zabbix.Params{
+					"output":             "extend",
+					"templateids":        templateIDs,
+					"selectApplications": []string{"applicationid", "name"},
+				})
+				return errors.Wrapf(err, "error getting items, templateids: %v", templateIDs)
+			},
+			func() error {
+				var err error
+				zabbixTriggers, err = p.api.TriggersGet(zabbix.Params{
+					"output":           "extend",
+					"templateids":      templateIDs,
+					"expandExpression": true,
+					"selectTags":       "extend",
+					"selectHosts":      []string{"hostid"},
+				})
+				return errors.Wrapf(err, "error getting zabbix triggers, templateids: %v", templateIDs)
+			},
+		)
+	}
+	if err := runConcurrently(p.concurrency, loads...); err != nil {
+		return err
+	}
+
+	for _, zabbixApplication := range zabbixApplications {
+		if oldTemplate, ok := templatesByID[zabbixApplication.HostID]; ok {
 			oldTemplate.AddApplication(&CustomApplication{
 				State:       StateOld,
 				Application: zabbixApplication,
 			})
 		}
+	}
 
-		zabbixItems, err := p.api.ItemsGet(zabbix.Params{
-			"output":      "extend",
-			"templateids": oldTemplate.TemplateID,
-		})
-		if err != nil {
-			return errors.Wrapf(err, "error getting item, hostid: %v", oldTemplate.Template.TemplateID)
+	// Applications have no ownership marker, they are managed by zal when they hold managed items only.
+	// The applications of the items which aren't managed by zal and the empty ones must stay.
+	keepApplications := map[*CustomTemplate]map[string]struct{}{}
+	managedApplications := map[*CustomTemplate]map[string]struct{}{}
+	for _, zabbixItem := range zabbixItems {
+		oldTemplate, ok := templatesByID[zabbixItem.HostID]
+		if !ok {
+			continue
 		}
 
-		for _, zabbixItem := range zabbixItems {
-			newItem := &CustomItem{
-				State: StateOld,
-				Item:  zabbixItem,
-			}
+		newItem := &CustomItem{
+			State:        StateOld,
+			Item:         zabbixItem,
+			Applications: make(map[string]struct{}, len(zabbixItem.ItemApplications)),
+		}
+		for _, zabbixApplication := range zabbixItem.ItemApplications {
+			newItem.Applications[zabbixApplication.Name] = struct{}{}
+		}
 
-			zabbixApplications, err := p.api.ApplicationsGet(zabbix.Params{
-				"output":  "extend",
-				"itemids": zabbixItem.ItemID,
-			})
-			if err != nil {
-				return errors.Wrapf(err, "error getting item, itemid: %v", oldTemplate.Template.TemplateID)
+		if !HasManagedMarker(zabbixItem.Description) {
+			_, provisioned := oldTemplate.Items[zabbixItem.Key]
+			if !p.adoptable("item", zabbixItem.Key, provisioned) {
+				delete(oldTemplate.Items, zabbixItem.Key)
+				if keepApplications[oldTemplate] == nil {
+					keepApplications[oldTemplate] = map[string]struct{}{}
+				}
+				for name := range newItem.Applications {
+					keepApplications[oldTemplate][name] = struct{}{}
+				}
+				continue
 			}
+		}
 
-			newItem.Applications = make(map[string]struct{}, len(zabbixApplications))
-			for _, zabbixApplication := range zabbixApplications {
-				newItem.Applications[zabbixApplication.Name] = struct{}{}
-			}
+		if managedApplications[oldTemplate] == nil {
+			managedApplications[oldTemplate] = map[string]struct{}{}
+		}
+		for name := range newItem.Applications {
+			managedApplications[oldTemplate][name] = struct{}{}
+		}
+
+		// log.Debugf("Loading item from Zabbix: %+v", newItem)
+		oldTemplate.AddItem(newItem)
+	}
 
-			// log.Debugf("Loading item from Zabbix: %+v", newItem)
-			oldTemplate.AddItem(newItem)
+	for _, oldTemplate := range templatesByID {
+		for name, application := range oldTemplate.Applications {
+			_, keep := keepApplications[oldTemplate][name]
+			_, managed := managedApplications[oldTemplate][name]
+			if application.State == StateOld && (keep || !managed) {
+				application.State = StateEqual
+			}
 		}
+	}
 
-		zabbixTriggers, err := p.api.TriggersGet(zabbix.Params{
-			"output":           "extend",
-			"templateids":      oldTemplate.TemplateID,
-			"expandExpression": true,
-		})
-		if err != nil {
-			return errors.Wrapf(err, "error getting zabbix triggers, hostids: %v", oldTemplate.Template.TemplateID)
+	for _, zabbixTrigger := range zabbixTriggers {
+		var oldTemplate *CustomTemplate
+		for _, host := range zabbixTrigger.Hosts {
+			if template, ok := templatesByID[host.HostID]; ok {
+				oldTemplate = template
+				break
+			}
+		}
+		if oldTemplate == nil {
+			continue
 		}
 
-		for _, zabbixTrigger := range zabbixTriggers {
-			newTrigger := &CustomTrigger{
-				State:   StateOld,
-				Trigger: zabbixTrigger,
+		if !IsManaged(zabbixTrigger.Tags) {
+			_, provisioned := oldTemplate.Triggers[zabbixTrigger.Expression]
+			if !p.adoptable("trigger", zabbixTrigger.Description, provisioned) {
+				delete(oldTemplate.Triggers, zabbixTrigger.Expression)
+				continue
 			}
+		}
 
-			// log.Debugf("Loading trigger from Zabbix: %+v", newTrigger)
-			oldTemplate.AddTrigger(newTrigger)
+		newTrigger := &CustomTrigger{
+			State:   StateOld,
+			Trigger: zabbixTrigger,
 		}
-	}
-	/// Geting ZABBIX HOSTS
-	hgids := make([]string, 1)
-	for _, hg := range p.HostGroups {
-		// log.Debugf("HG: %v %s ", hg, hg.GroupID)
-		hgids = append(hgids, hg.GroupID)
-	}
 
-	zabbixHosts, err := p.api.HostsGet(zabbix.Params{
-		"output":   "extend",
-		"groupids": p.HostGroups["Prometheus"].GroupID,
-	})
-	if err != nil {
-		return errors.Wrapf(err, "error getting hosts: %v", hostNames)
+		// log.Debugf("Loading trigger from Zabbix: %+v", newTrigger)
+		oldTemplate.AddTrigger(newTrigger)
 	}
-	// log.Debugf("HOSTS from ZABBIXfor HGids: %+v %+v\n", hgids, zabbixHosts)
 
+	/// Geting ZABBIX HOSTS
 	for _, zabbixHost := range zabbixHosts {
-		zabbixHostGroups, err := p.api.HostGroupsGet(zabbix.Params{
-			"output":  "extend",
-			"hostids": zabbixHost.HostID,
-		})
-		if err != nil {
-			return errors.Wrapf(err, "error getting hostgroup, hostid: %v", zabbixHost.HostID)
+		if !IsManaged(zabbixHost.Tags) {
+			_, provisioned := p.Hosts[zabbixHost.Name]
+			if !p.adoptable("host", zabbixHost.Name, provisioned) {
+				delete(p.Hosts, zabbixHost.Name)
+				continue
+			}
 		}
 
-		hostGroups := make(map[string]struct{}, len(zabbixHostGroups))
-		for _, zabbixHostGroup := range zabbixHostGroups {
+		hostGroups := make(map[string]struct{}, len(zabbixHost.Groups))
+		for _, zabbixHostGroup := range zabbixHost.Groups {
 			hostGroups[zabbixHostGroup.Name] = struct{}{}
-			// log.Debugf("PHHHGGG: %+v\n", p.HostGroups["Prometheus"].State)
 		}
-		// log.Debugf("HHHGGG: %+v\n\n\n", hostGroups)
+		templates := map[string]struct{}{}
+		for _, parent := range zabbixHost.ParentTemplates {
+			if _, ok := p.Templates[parent.Name]; ok {
+				templates[parent.Name] = struct{}{}
+			}
+		}
 		// Remove hostid because the Zabbix api add it automatically and it breaks the comparison between new/old hosts
 		delete(zabbixHost.Inventory, "hostid")
+		// Zabbix returns all inventory fields, only the filled ones are compared
+		for field, value := range zabbixHost.Inventory {
+			if value == "" {
+				delete(zabbixHost.Inventory, field)
+			}
+		}
 
 		oldHost := p.AddHost(&CustomHost{
 			State:        StateOld,
 			Host:         zabbixHost,
 			HostGroups:   hostGroups,
+			Templates:    templates,
 			Items:        map[string]*CustomItem{},
 			Applications: map[string]*CustomApplication{},
 			Triggers:     map[string]*CustomTrigger{},
@@ -511,225 +838,225 @@ func (p *Provisioner) LoadDataFromZabbix() error {
 	return nil
 }
 
-//ApplyChanges ...
+//ApplyChanges applies the diff to Zabbix.
+// Every change is recorded, when one fails the changes of the failed template or host are rolled back
+// and the provisioning stops or continues with the next one according to the failure policy.
 func (p *Provisioner) ApplyChanges() error {
 	log.Debugln("===================================================================")
 	log.Debugln("=======================ApplyChanges================================")
 	log.Debugln("===================================================================")
-	hostGroupsByState := p.GetHostGroupsByState()
-	if len(hostGroupsByState[StateNew]) != 0 {
-		// log.Debugf("Creating HostGroups: %+v\n", hostGroupsByState[StateNew])
-		err := p.api.HostGroupsCreate(hostGroupsByState[StateNew])
-		if err != nil {
-			return errors.Wrap(err, "Failed in creating hostgroups")
-		}
+	if err := p.ApplyOrphanPolicies(time.Now()); err != nil {
+		return errors.Wrap(err, "error applying orphan policies")
 	}
 
-	// Make sure we update ids for the newly created host groups
-	p.PropagateCreatedHostGroups(hostGroupsByState[StateNew])
-
-	templatesByState := p.GetTemplatesByState()
-	if len(templatesByState[StateNew]) != 0 {
-		log.Debug("Creating Templates: %+v\n", templatesByState[StateNew])
-		err := p.api.TemplateCreate(templatesByState[StateNew])
-		log.Debugf("===TEMPLATES: %+v", templatesByState)
+	report := &ApplyError{}
 
-		if err != nil {
-			return errors.Wrap(err, "Failed in creating tempalte")
-		}
-	}
-	if len(templatesByState[StateUpdated]) != 0 {
-		log.Debugf("Updating Templates: %+v\n", templatesByState[StateUpdated])
-		log.Debugf("Updating Templates: %+v\n", templatesByState)
-		err := p.api.TemplatesUpdate(templatesByState[StateUpdated])
-		if err != nil {
-			return errors.Wrap(err, "Failed in updating host")
-		}
+	shared := newJournal("templates")
+	if err := p.applyTemplates(shared); err != nil {
+		report.fail(shared, err)
+		return report
 	}
-	templist := []string{}
-	log.Debugf("Updating tempalte, tempalteName: %s", p.Templates)
-	for _, template := range p.Templates {
-		templist = append(templist, template.TemplateID)
-		log.Debugf("Updating tempalte, tempalteName: %s", template.Name)
 
-		applicationsByState := template.GetApplicationsByState()
-		if len(applicationsByState[StateOld]) != 0 {
-			// log.Debugf("Deleting applications: %+v\n", applicationsByState[StateOld])
-			err := p.api.ApplicationsDelete(applicationsByState[StateOld])
-			if err != nil {
-				return errors.Wrap(err, "Failed in deleting applications")
-			}
+	log.Debugf("Updating tempalte, templates: %+v", p.Templates)
+	for _, template := range p.Templates {
+		if template.State == StateOld {
+			continue
 		}
+		log.Debugf("Updating tempalte, tempalteName: %s", template.Name)
 
-		if len(applicationsByState[StateNew]) != 0 {
-			// log.Debugf("Creating applications: %+v\n", applicationsByState[StateNew])
-			err := p.api.ApplicationsCreate(applicationsByState[StateNew])
-			if err != nil {
-				return errors.Wrap(err, "Failed in creating applications")
+		j := newJournal("template " + template.Name)
+		if err := p.applyObjects(j, template); err != nil {
+			report.fail(j, err)
+			if p.onFailure == FailureStop {
+				return report
 			}
 		}
-		template.PropagateCreatedApplications(applicationsByState[StateNew])
+	}
 
-		itemsByState := template.GetItemsByState()
-		triggersByState := template.GetTriggersByState()
+	shared = newJournal("hosts")
+	if err := p.applyHosts(shared); err != nil {
+		report.fail(shared, err)
+		return report
+	}
 
-		if len(triggersByState[StateOld]) != 0 {
-			// log.Debugf("Deleting triggers: %+v\n", triggersByState[StateOld])
-			err := p.api.TriggersDelete(triggersByState[StateOld])
-			if err != nil {
-				return errors.Wrap(err, "Failed in deleting triggers")
-			}
+	for _, host := range p.Hosts {
+		if host.State == StateOld {
+			continue
 		}
+		log.Debugf("Updating host, hostName: %s %s", host.Name, host.HostID)
 
-		if len(itemsByState[StateOld]) != 0 {
-			// log.Debugf("Deleting items: %+v\n", itemsByState[StateOld])
-			err := p.api.ItemsDelete(itemsByState[StateOld])
-			if err != nil {
-				return errors.Wrap(err, "Failed in deleting items")
+		j := newJournal("host " + host.Name)
+		if err := p.applyObjects(j, host); err != nil {
+			report.fail(j, err)
+			if p.onFailure == FailureStop {
+				return report
 			}
 		}
+	}
+	return report.errorOrNil()
+}
 
-		if len(itemsByState[StateUpdated]) != 0 {
-			// log.Debugf("Updating items: %+v\n", itemsByState[StateUpdated])
-			err := p.api.ItemsUpdate(itemsByState[StateUpdated])
-			if err != nil {
-				return errors.Wrap(err, "Failed in updating items")
-			}
-		}
+//applyTemplates applies the changes of the host groups and templates
+func (p *Provisioner) applyTemplates(j *journal) error {
+	hostGroupsByState := p.GetHostGroupsByState()
+	created := hostGroupsByState[StateNew]
+	err := j.apply("hostgroup", "created", len(created),
+		func() error { return p.api.HostGroupsCreate(created) },
+		func() error { return p.api.HostGroupsDelete(created) })
+	if err != nil {
+		return err
+	}
 
-		if len(triggersByState[StateUpdated]) != 0 {
-			// log.Debugf("Updating triggers: %+v\n", triggersByState[StateUpdated])
-			err := p.api.TriggersUpdate(triggersByState[StateUpdated])
-			if err != nil {
-				return errors.Wrap(err, "Failed in updating triggers")
-			}
-		}
+	// Make sure we update ids for the newly created host groups
+	p.PropagateCreatedHostGroups(hostGroupsByState[StateNew])
 
-		if len(itemsByState[StateNew]) != 0 {
-			// log.Debugf("Creating items: %+v\n", itemsByState[StateNew])
-			err := p.api.ItemsCreate(itemsByState[StateNew])
-			if err != nil {
-				return errors.Wrap(err, "Failed in creating items")
-			}
-		}
+	templatesByState := p.GetTemplatesByState()
+	newTemplates := templatesByState[StateNew]
+	log.Debugf("Creating Templates: %+v\n", newTemplates)
+	err = j.apply("template", "created", len(newTemplates),
+		func() error { return p.api.TemplateCreate(newTemplates) },
+		func() error { return p.api.TemplatesDelete(newTemplates) })
+	if err != nil {
+		return err
+	}
 
-		if len(triggersByState[StateNew]) != 0 {
-			// log.Debugf("Creating triggers: %+v\n", triggersByState[StateNew])
-			err := p.api.TriggersCreate(triggersByState[StateNew])
-			if err != nil {
-				return errors.Wrap(err, "Failed in creating triggers")
-			}
-		}
+	// Make sure we update ids for the newly created templates
+	p.PropagateCreatedTemplates(newTemplates)
+
+	log.Debugf("Updating Templates: %+v\n", templatesByState[StateUpdated])
+	err = j.apply("template", "updated", len(templatesByState[StateUpdated]),
+		func() error { return p.api.TemplatesUpdate(templatesByState[StateUpdated]) }, nil)
+	if err != nil {
+		return err
 	}
 
+	log.Debugf("Deleting Templates: %+v\n", templatesByState[StateOld])
+	return j.apply("template", "deleted", len(templatesByState[StateOld]),
+		func() error { return p.api.TemplatesDelete(templatesByState[StateOld]) }, nil)
+}
+
+//applyHosts applies the changes of the hosts
+func (p *Provisioner) applyHosts(j *journal) error {
 	hostsByState := p.GetHostsByState()
 	log.Debugf("=+=+=+=hostsByState :%v", hostsByState)
-	if len(hostsByState[StateNew]) != 0 {
-		log.Debugf("Creating Hosts: %+v\n", hostsByState[StateNew])
-		err := p.api.HostsCreate(hostsByState[StateNew])
-		if err != nil {
-			return errors.Wrap(err, "Failed in creating host")
-		}
+	newHosts := hostsByState[StateNew]
+	log.Debugf("Creating Hosts: %+v\n", newHosts)
+	err := j.apply("host", "created", len(newHosts),
+		func() error { return p.api.HostsCreate(newHosts) },
+		func() error { return p.api.HostsDelete(newHosts) })
+	if err != nil {
+		return err
 	}
 
 	// Make sure we update ids for the newly created hosts
-	p.PropagateCreatedHosts(hostsByState[StateNew])
+	p.PropagateCreatedHosts(newHosts)
 
-	if len(hostsByState[StateUpdated]) != 0 {
-		log.Debugf("Updating Hosts: %+v\n", hostsByState[StateUpdated])
-		err := p.api.HostsUpdate(hostsByState[StateUpdated])
-		if err != nil {
-			return errors.Wrap(err, "Failed in updating host")
-		}
+	log.Debugf("Updating Hosts: %+v\n", hostsByState[StateUpdated])
+	err = j.apply("host", "updated", len(hostsByState[StateUpdated]),
+		func() error { return p.api.HostsUpdate(hostsByState[StateUpdated]) }, nil)
+	if err != nil {
+		return err
 	}
-	hostlist := []map[string]string{}
-	for _, host := range p.Hosts {
-		log.Debugf("Updating host, hostName: %s %s", host.Name, host.HostID)
-		hostlist = append(hostlist, map[string]string{"hostid": host.HostID})
-
-		applicationsByState := host.GetApplicationsByState()
-		if len(applicationsByState[StateOld]) != 0 {
-			// log.Debugf("Deleting applications: %+v\n", applicationsByState[StateOld])
-			err := p.api.ApplicationsDelete(applicationsByState[StateOld])
-			if err != nil {
-				return errors.Wrap(err, "Failed in deleting applications")
-			}
-		}
 
-		if len(applicationsByState[StateNew]) != 0 {
-			// log.Debugf("Creating applications: %+v\n", applicationsByState[StateNew])
-			err := p.api.ApplicationsCreate(applicationsByState[StateNew])
-			if err != nil {
-				return errors.Wrap(err, "Failed in creating applications")
-			}
-		}
-		host.PropagateCreatedApplications(applicationsByState[StateNew])
+	log.Debugf("Deleting Hosts: %+v\n", hostsByState[StateOld])
+	return j.apply("host", "deleted", len(hostsByState[StateOld]),
+		func() error { return p.api.HostsDelete(hostsByState[StateOld]) }, nil)
+}
 
-		itemsByState := host.GetItemsByState()
-		triggersByState := host.GetTriggersByState()
+//objectsOwner is a template or a host owning applications, items and triggers
+type objectsOwner interface {
+	GetApplicationsByState() map[State]zabbix.Applications
+	PropagateCreatedApplications(applications zabbix.Applications)
+	GetItemsByState() map[State]zabbix.Items
+	GetTriggersByState() map[State]zabbix.Triggers
+}
 
-		if len(triggersByState[StateOld]) != 0 {
-			// log.Debugf("Deleting triggers: %+v\n", triggersByState[StateOld])
-			err := p.api.TriggersDelete(triggersByState[StateOld])
-			if err != nil {
-				return errors.Wrap(err, "Failed in deleting triggers")
-			}
-		}
+//applyObjects applies the changes of the applications, items and triggers of a template or a host
+func (p *Provisioner) applyObjects(j *journal, owner objectsOwner) error {
+	applicationsByState := owner.GetApplicationsByState()
+	deletedApplications := p.recreateApplications(applicationsByState[StateOld])
+	err := j.apply("application", "deleted", len(applicationsByState[StateOld]),
+		func() error { return p.api.ApplicationsDelete(applicationsByState[StateOld]) },
+		deletedApplications.recreate)
+	if err != nil {
+		return err
+	}
 
-		if len(itemsByState[StateOld]) != 0 {
-			// log.Debugf("Deleting items: %+v\n", itemsByState[StateOld])
-			err := p.api.ItemsDelete(itemsByState[StateOld])
-			if err != nil {
-				return errors.Wrap(err, "Failed in deleting items")
-			}
-		}
+	newApplications := applicationsByState[StateNew]
+	err = j.apply("application", "created", len(newApplications),
+		func() error { return p.api.ApplicationsCreate(newApplications) },
+		func() error { return p.api.ApplicationsDelete(newApplications) })
+	if err != nil {
+		return err
+	}
+	owner.PropagateCreatedApplications(newApplications)
 
-		if len(itemsByState[StateUpdated]) != 0 {
-			// log.Debugf("Updating items: %+v\n", itemsByState[StateUpdated])
-			err := p.api.ItemsUpdate(itemsByState[StateUpdated])
-			if err != nil {
-				return errors.Wrap(err, "Failed in updating items")
-			}
-		}
+	itemsByState := owner.GetItemsByState()
+	triggersByState := owner.GetTriggersByState()
 
-		if len(triggersByState[StateUpdated]) != 0 {
-			// log.Debugf("Updating triggers: %+v\n", triggersByState[StateUpdated])
-			err := p.api.TriggersUpdate(triggersByState[StateUpdated])
-			if err != nil {
-				return errors.Wrap(err, "Failed in updating triggers")
-			}
-		}
+	err = j.apply("trigger", "deleted", len(triggersByState[StateOld]),
+		func() error { return p.api.TriggersDelete(triggersByState[StateOld]) },
+		p.recreateTriggers(triggersByState[StateOld]))
+	if err != nil {
+		return err
+	}
 
-		if len(itemsByState[StateNew]) != 0 {
-			// log.Debugf("Creating items: %+v\n", itemsByState[StateNew])
-			err := p.api.ItemsCreate(itemsByState[StateNew])
-			if err != nil {
-				return errors.Wrap(err, "Failed in creating items")
-			}
-		}
+	err = j.apply("item", "deleted", len(itemsByState[StateOld]),
+		func() error { return p.api.ItemsDelete(itemsByState[StateOld]) },
+		p.recreateItems(itemsByState[StateOld], deletedApplications))
+	if err != nil {
+		return err
+	}
 
-		if len(triggersByState[StateNew]) != 0 {
-			// log.Debugf("Creating triggers: %+v\n", triggersByState[StateNew])
-			err := p.api.TriggersCreate(triggersByState[StateNew])
-			if err != nil {
-				return errors.Wrap(err, "Failed in creating triggers")
-			}
-		}
+	err = j.apply("item", "updated", len(itemsByState[StateUpdated]),
+		func() error { return p.api.ItemsUpdate(itemsByState[StateUpdated]) }, nil)
+	if err != nil {
+		return err
+	}
 
+	err = j.apply("trigger", "updated", len(triggersByState[StateUpdated]),
+		func() error { return p.api.TriggersUpdate(triggersByState[StateUpdated]) }, nil)
+	if err != nil {
+		return err
 	}
-	log.Debugf("HOSTLIST: %+v\n", hostlist, p.Templates)
-	templateUpd, err := p.api.TemplateUpdate(zabbix.Params{
-		// "templateid": templist[0],
-		"templateid": "10334",
-		"hosts": []map[string]string{
-			0: {"hostid": "10347"},
-			1: {"hostid": "10348"},
-			2: {"hostid": "10353"},
-		},
-	})
+
+	newItems := itemsByState[StateNew]
+	err = j.apply("item", "created", len(newItems),
+		func() error { return p.api.ItemsCreate(newItems) },
+		func() error { return p.api.ItemsDelete(newItems) })
 	if err != nil {
-		return errors.Wrap(err, "Failed in updating template")
+		return err
 	}
-	log.Debugf("HOSTLIST: %+v\n", templateUpd)
-	return nil
+
+	newTriggers := triggersByState[StateNew]
+	return j.apply("trigger", "created", len(newTriggers),
+		func() error { return p.api.TriggersCreate(newTriggers) },
+		func() error { return p.api.TriggersDelete(newTriggers) })
+}
+
+//runConcurrently runs the functions with at most limit of them at once and returns the first error
+func runConcurrently(limit int, fns ...func() error) error {
+	if limit < 1 {
+		limit = 1
+	}
+
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+	)
+	sem := make(chan struct{}, limit)
+	for _, fn := range fns {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(fn func() error) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if err := fn(); err != nil {
+				once.Do(func() { firstErr = err })
+			}
+		}(fn)
+	}
+	wg.Wait()
+	return firstErr
 }
@@ -1,16 +1,24 @@
 package provisioner_test
 
 import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/neogan74/zabbix-alertmanager/zabbixprovisioner/provisioner"
+	zabbix "github.com/neogan74/zabbix-alertmanager/zabbixprovisioner/zabbixclient"
 )
 
 const (
 	rulesOKpath      = "./testdata/testsOK/"
 	rulesErrReadFile = "./testdata/testsErr/read/"
 	rulesErrOpenDir  = ""
-	rulesErrSameName = "./testdata/testsErr/samename/"
+	rulesSameName    = "./testdata/testsSameName/"
+	rulesCRDpath     = "./testdata/testsCRD/"
+	rulesErrCRD      = "./testdata/testsErr/crd/"
 )
 
 func TestLoadPrometheusRulesFromPathOK(t *testing.T) {
@@ -38,9 +46,445 @@ func TestLoadPrometheusRulesFromPathErrorOpenDir(t *testing.T) {
 	}
 }
 
-func TestLoadPrometheusRulesFromPathErrorSameName(t *testing.T) {
-	_, err := provisioner.LoadPrometheusRulesFromDir(rulesErrSameName)
+func TestLoadPrometheusRulesFromPathSameNameInGroups(t *testing.T) {
+	rules, err := provisioner.LoadPrometheusRulesFromDir(rulesSameName)
+	if err != nil {
+		t.Fatal("Expected to work, got :", err)
+	}
+
+	withGroup := provisioner.ItemKeyConfig{IncludeGroup: true}
+	keys := map[string]struct{}{}
+	for _, rule := range rules {
+		key, err := withGroup.RuleKey("prometheus", rule)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys[key] = struct{}{}
+	}
+	if len(keys) != len(rules) {
+		t.Errorf("Expected %d different item keys with the group name, got %v", len(rules), keys)
+	}
+
+	keys = map[string]struct{}{}
+	for _, rule := range rules {
+		key, err := provisioner.ItemKeyConfig{}.RuleKey("prometheus", rule)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys[key] = struct{}{}
+	}
+	if len(keys) == len(rules) {
+		t.Errorf("Expected item keys of the same alertname to collide without the group name, got %v", keys)
+	}
+}
+
+func TestLoadPrometheusRulesFromPathGroupFields(t *testing.T) {
+	rules, err := provisioner.LoadPrometheusRulesFromDir(rulesCRDpath)
+	if err != nil {
+		t.Fatal("Expected to work, got :", err)
+	}
+
+	for _, rule := range rules {
+		if rule.Name != "NodeDown" {
+			continue
+		}
+		if rule.Group != "node" || rule.GroupInterval != "1m" || rule.For != "5m" || rule.KeepFiringFor != "10m" {
+			t.Errorf("Unexpected rule fields: %+v", rule)
+		}
+		if labels := rule.AllLabels(); labels["team"] != "infra" || labels["severity"] != "critical" {
+			t.Errorf("Expected group and rule labels, got %v", labels)
+		}
+		if key, _ := (provisioner.ItemKeyConfig{IncludeGroup: true, GroupLabel: "team"}).RuleKey("Prometheus", rule); key != "prometheus.infra.nodedown" {
+			t.Errorf("Unexpected item key: %s", key)
+		}
+		return
+	}
+	t.Error("Expected to find the NodeDown rule")
+}
+
+func TestLoadPrometheusRulesFromPathCRD(t *testing.T) {
+	expected := map[string]string{
+		"NodeDown":                     "testdata/testsCRD/prometheusrule.yaml (document 2)",
+		"NodeFilesystemFull":           "testdata/testsCRD/prometheusrule.yaml (document 2)",
+		"KubeletDown":                  "testdata/testsCRD/nested/bundle.yaml (document 1)",
+		"PrometheusConfigReloadFailed": "testdata/testsCRD/nested/bundle.yaml (document 2)",
+	}
+	rules, err := provisioner.LoadPrometheusRulesFromDir(rulesCRDpath)
+	if err != nil {
+		t.Fatal("Expected to work, got :", err)
+	}
+	if len(rules) != len(expected) {
+		t.Fatalf("Expeceted to get %d rules, but got %d: %+v", len(expected), len(rules), rules)
+	}
+	for _, rule := range rules {
+		if source, ok := expected[rule.Name]; !ok || rule.Source() != source {
+			t.Errorf("Unexpected rule %s from %s", rule.Name, rule.Source())
+		}
+	}
+}
+
+func TestLoadPrometheusRulesFromPathErrorCRD(t *testing.T) {
+	_, err := provisioner.LoadPrometheusRulesFromDir(rulesErrCRD)
+	if err == nil {
+		t.Fatal("Expected to get error, got :", err)
+	}
+	if !strings.Contains(err.Error(), "prometheusrule.yaml, document: 2") {
+		t.Error("Expected the error to point to the document, got :", err)
+	}
+}
+
+func TestItemKeyConfig(t *testing.T) {
+	rule := provisioner.PrometheusRule{
+		Name:        "HighLatency",
+		Group:       "api",
+		Labels:      map[string]string{"severity": "critical", "service": "Checkout API"},
+		GroupLabels: map[string]string{"rulegroup": "api"},
+	}
+	alertLabels := map[string]string{
+		"alertname": "HighLatency",
+		"rulegroup": "api",
+		"severity":  "critical",
+		"service":   "Checkout API",
+		"instance":  "10.0.0.1:8080",
+	}
+
+	tests := []struct {
+		config   provisioner.ItemKeyConfig
+		expected string
+	}{
+		{provisioner.ItemKeyConfig{}, "prometheus.highlatency"},
+		{provisioner.ItemKeyConfig{Strategy: "labels", Labels: []string{"severity", "service"}}, "prometheus.highlatency.critical.checkout_api"},
+		{provisioner.ItemKeyConfig{Strategy: "labels", Labels: []string{"severity"}, IncludeGroup: true}, "prometheus.api.highlatency.critical"},
+		{provisioner.ItemKeyConfig{Strategy: "hash", Labels: []string{"severity", "service"}}, ""},
+	}
+
+	for _, test := range tests {
+		if err := test.config.Validate(); err != nil {
+			t.Fatalf("Expected config %+v to be valid, got: %v", test.config, err)
+		}
+		ruleKey, err := test.config.RuleKey("Prometheus", rule)
+		if err != nil {
+			t.Fatal(err)
+		}
+		alertKey := test.config.AlertKey("prometheus", alertLabels)
+		if ruleKey != alertKey {
+			t.Errorf("Expected zal prov and zal send keys to match for %+v, got %s and %s", test.config, ruleKey, alertKey)
+		}
+		if test.expected != "" && ruleKey != test.expected {
+			t.Errorf("Expected key %s for %+v, got %s", test.expected, test.config, ruleKey)
+		}
+	}
+
+	// The group comes from the label the alerts carry, not from the group name
+	named := provisioner.PrometheusRule{Name: "HighLatency", Group: "api", Labels: map[string]string{"severity": "critical"}}
+	if _, err := (provisioner.ItemKeyConfig{IncludeGroup: true}).RuleKey("prometheus", named); err == nil {
+		t.Error("Expected error for a rule without the group label")
+	}
+	if _, err := (provisioner.ItemKeyConfig{Strategy: "hash", Labels: []string{"service"}}).RuleKey("prometheus", named); err == nil {
+		t.Error("Expected error for a rule without a label of the key")
+	}
+	sanitized := provisioner.PrometheusRule{Name: "Disk:Full"}
+	if key, _ := (provisioner.ItemKeyConfig{}).RuleKey("prometheus", sanitized); key != "prometheus.disk:full" {
+		t.Errorf("Expected the alertname strategy to keep the existing key, got %s", key)
+	}
+	if key, _ := (provisioner.ItemKeyConfig{Strategy: "labels", Labels: []string{}}).RuleKey("prometheus", sanitized); key != "prometheus.disk_full" {
+		t.Errorf("Expected the labels strategy to sanitise the key, got %s", key)
+	}
+
+	templated := provisioner.PrometheusRule{Name: "HighLatency", Labels: map[string]string{"severity": "{{ $labels.severity }}"}}
+	if _, err := (provisioner.ItemKeyConfig{Strategy: "labels", Labels: []string{"severity"}}).RuleKey("prometheus", templated); err == nil {
+		t.Error("Expected error for templated label in the item key")
+	}
+	if err := (provisioner.ItemKeyConfig{Strategy: "hash"}).Validate(); err == nil {
+		t.Error("Expected error for hash strategy without labels")
+	}
+}
+
+func TestSeverityMappingPriority(t *testing.T) {
+	mapping := provisioner.SeverityMapping{
+		Label: "severity",
+		Values: map[string]string{
+			"page":   "high",
+			"ticket": "warning",
+			"P1":     "disaster",
+		},
+		Default: "average",
+	}
+
+	tests := []struct {
+		labels   map[string]string
+		expected zabbix.PriorityType
+	}{
+		{map[string]string{"severity": "page"}, zabbix.High},
+		{map[string]string{"severity": "Ticket"}, zabbix.Warning},
+		{map[string]string{"severity": "p1"}, zabbix.Critical},
+		{map[string]string{"severity": "information"}, zabbix.Information},
+		{map[string]string{"severity": "unknown"}, zabbix.Average},
+		{map[string]string{}, zabbix.Average},
+	}
+
+	for _, test := range tests {
+		if got := mapping.Priority(test.labels); got != test.expected {
+			t.Errorf("Expected priority %d for labels %v, got %d", test.expected, test.labels, got)
+		}
+	}
+}
+
+func TestSeverityMappingPriorities(t *testing.T) {
+	mapping := provisioner.SeverityMapping{
+		Values: map[string]string{
+			"page":   "high",
+			"ticket": "warning",
+			"info":   "warning",
+		},
+	}
+
+	expected := []zabbix.PriorityType{zabbix.NotClassified, zabbix.Warning, zabbix.High}
+	got := mapping.Priorities()
+	if len(got) != len(expected) {
+		t.Fatalf("Expected priorities %v, got %v", expected, got)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Errorf("Expected priorities %v, got %v", expected, got)
+		}
+	}
+
+	if !mapping.IsDynamic(map[string]string{"severity": "{{ $labels.severity }}"}) {
+		t.Error("Expected templated severity to be dynamic")
+	}
+	if mapping.IsDynamic(map[string]string{"severity": "page"}) {
+		t.Error("Expected static severity not to be dynamic")
+	}
+	if mapping.IsDynamic(map[string]string{"team": "infra"}) {
+		t.Error("Expected a rule without severity not to be dynamic")
+	}
+}
+
+const rulesAPIResponse = `{
+	"status": "success",
+	"data": {
+		"groups": [
+			{
+				"name": "node",
+				"file": "/etc/prometheus/rules/node.yaml",
+				"rules": [
+					{
+						"type": "alerting",
+						"name": "InstanceDown",
+						"query": "up == 0",
+						"duration": 60,
+						"labels": {"severity": "critical"},
+						"annotations": {"summary": "Instance down"}
+					},
+					{
+						"type": "recording",
+						"name": "job:up:sum",
+						"query": "sum by (job) (up)"
+					}
+				]
+			}
+		]
+	}
+}`
+
+func TestLoadPrometheusRulesFromAPI(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/rules" {
+			http.NotFound(w, r)
+			return
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer secret" {
+			http.Error(w, fmt.Sprintf("unexpected authorization: %s", got), http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, rulesAPIResponse)
+	}
+	replica1 := httptest.NewServer(http.HandlerFunc(handler))
+	defer replica1.Close()
+	replica2 := httptest.NewServer(http.HandlerFunc(handler))
+	defer replica2.Close()
+
+	client, err := provisioner.HTTPClientConfig{BearerToken: "secret"}.NewClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rules, err := provisioner.LoadPrometheusRulesFromAPI(client, []string{replica1.URL, replica2.URL + "/api/v1/rules"})
+	if err != nil {
+		t.Fatal("Expected to work, got :", err)
+	}
+	if len(rules) != 1 {
+		t.Fatalf("Expected to get 1 rule, but got %d", len(rules))
+	}
+	if rules[0].Name != "InstanceDown" || rules[0].Expression != "up == 0" || rules[0].Labels["severity"] != "critical" || rules[0].For != "1m" || rules[0].Group != "node" {
+		t.Errorf("Unexpected rule: %+v", rules[0])
+	}
+}
+
+func TestLoadPrometheusRulesFromAPIError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"status": "error", "error": "unauthorized"}`)
+	}))
+	defer server.Close()
+
+	_, err := provisioner.LoadPrometheusRulesFromAPI(http.DefaultClient, []string{server.URL})
 	if err == nil {
 		t.Error("Expected to get error, got :", err)
 	}
 }
+
+const targetsAPIResponse = `{
+	"status": "success",
+	"data": {
+		"activeTargets": [
+			{"labels": {"instance": "node1.example.com:9100", "job": "node", "env": "prod"}, "health": "up"},
+			{"labels": {"instance": "node1.example.com:9256", "job": "node", "env": "prod"}, "health": "up"},
+			{"labels": {"instance": "[2001:db8::1]:9100", "job": "node", "env": "prod"}, "health": "up"},
+			{"labels": {"instance": "node2.example.com", "job": "node", "env": "prod"}, "health": "down"},
+			{"labels": {"instance": "node3.example.com:9100", "job": "node", "env": "dev"}, "health": "up"},
+			{"labels": {"instance": "https://example.com", "job": "blackbox", "env": "prod"}, "health": "up"}
+		],
+		"droppedTargets": []
+	}
+}`
+
+func TestLoadPrometheusTargets(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, ok := r.BasicAuth(); !ok || user != "zal" || password != "secret" {
+			http.Error(w, `{"status": "error", "error": "unauthorized"}`, http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, targetsAPIResponse)
+	}))
+	defer server.Close()
+
+	client, err := provisioner.HTTPClientConfig{BasicAuth: &provisioner.BasicAuth{Username: "zal", Password: "secret"}}.NewClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	selector, err := provisioner.NewTargetSelector(map[string]string{"job": "node|blackbox", "env": "prod"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	targets, err := provisioner.LoadPrometheusTargets(client, server.URL, selector)
+	if err != nil {
+		t.Fatal("Expected to work, got :", err)
+	}
+
+	expected := []string{"node1.example.com", "2001:db8::1", "node2.example.com", "example.com"}
+	if len(targets) != len(expected) {
+		t.Fatalf("Expected targets %v, got %+v", expected, targets)
+	}
+	for i, host := range expected {
+		if targets[i].Host != host {
+			t.Errorf("Expected target %s, got %s", host, targets[i].Host)
+		}
+	}
+
+	if _, err := provisioner.LoadPrometheusTargets(http.DefaultClient, server.URL, selector); err == nil {
+		t.Error("Expected to get error without credentials, got :", err)
+	}
+}
+
+func TestTargetHost(t *testing.T) {
+	tests := map[string]string{
+		"localhost:9100":        "localhost",
+		"10.0.0.1:9100":         "10.0.0.1",
+		"[2001:db8::1]:9100":    "2001:db8::1",
+		"[2001:db8::1]":         "2001:db8::1",
+		"2001:db8::1":           "2001:db8::1",
+		"localhost":             "localhost",
+		"http://localhost:8080": "localhost",
+	}
+	for instance, expected := range tests {
+		if got := provisioner.TargetHost(instance); got != expected {
+			t.Errorf("Expected host %s for instance %s, got %s", expected, instance, got)
+		}
+	}
+}
+
+func TestHostConfigTargetHostDefaults(t *testing.T) {
+	config := provisioner.HostConfig{Tag: "prometheus", DeploymentStatus: "0"}
+	host, err := config.TargetHost(provisioner.PrometheusTarget{Host: "10.0.0.1", Labels: map[string]string{"job": "node"}})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if host.Host != "10.0.0.1" || host.Name != "10.0.0.1" {
+		t.Errorf("Expected host and name 10.0.0.1, got %s and %s", host.Host, host.Name)
+	}
+
+	expected := zabbix.HostInterfaces{{IP: "10.0.0.1", Main: 1, Port: "10050", Type: zabbix.Agent, UseIP: 1}}
+	if !reflect.DeepEqual(host.Interfaces, expected) {
+		t.Errorf("Expected interfaces %+v, got %+v", expected, host.Interfaces)
+	}
+
+	inventory := zabbix.HostInventory{"tag": "prometheus", "deployment_status": "0"}
+	if !reflect.DeepEqual(host.Inventory, inventory) {
+		t.Errorf("Expected inventory %v, got %v", inventory, host.Inventory)
+	}
+}
+
+func TestHostConfigTargetHostTemplate(t *testing.T) {
+	config := provisioner.HostConfig{
+		Tag: "prometheus",
+		HostTemplate: provisioner.HostTemplate{
+			Host: "{{ .Labels.job }}-{{ .Host }}",
+			Name: "{{ .Labels.job }} on {{ .Host }}",
+			Interface: provisioner.InterfaceTemplate{
+				Type:    "snmp",
+				Address: "{{ .Labels.instance_name }}",
+			},
+			Inventory: map[string]string{"tag": "{{ .Labels.env }}", "location": "{{ .Labels.missing }}"},
+			Tags:      map[string]string{"job": "{{ .Labels.job }}", "env": "{{ .Labels.env }}"},
+		},
+	}
+	target := provisioner.PrometheusTarget{
+		Host:   "10.0.0.1",
+		Labels: map[string]string{"job": "snmp", "env": "prod", "instance_name": "switch.example.com"},
+	}
+
+	host, err := config.TargetHost(target)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if host.Host != "snmp-10.0.0.1" || host.Name != "snmp on 10.0.0.1" {
+		t.Errorf("Unexpected host %s or name %s", host.Host, host.Name)
+	}
+
+	iface := host.Interfaces[0]
+	if iface.Type != zabbix.SNMP || iface.DNS != "switch.example.com" || iface.UseIP != 0 || iface.Port != "161" {
+		t.Errorf("Unexpected interface %+v", iface)
+	}
+	if iface.Details == nil || iface.Details.Version != "2" {
+		t.Errorf("Expected SNMP details, got %+v", iface.Details)
+	}
+
+	inventory := zabbix.HostInventory{"tag": "prod"}
+	if !reflect.DeepEqual(host.Inventory, inventory) {
+		t.Errorf("Expected inventory %v, got %v", inventory, host.Inventory)
+	}
+
+	tags := []zabbix.Tag{{Tag: "env", Value: "prod"}, {Tag: "job", Value: "snmp"}}
+	if !reflect.DeepEqual(host.Tags, tags) {
+		t.Errorf("Expected tags %v, got %v", tags, host.Tags)
+	}
+}
+
+func TestHostConfigTargetHostErrors(t *testing.T) {
+	target := provisioner.PrometheusTarget{Host: "10.0.0.1"}
+	configs := map[string]provisioner.HostConfig{
+		"interface type": {HostTemplate: provisioner.HostTemplate{Interface: provisioner.InterfaceTemplate{Type: "http"}}},
+		"template":       {HostTemplate: provisioner.HostTemplate{Name: "{{ .Labels.job"}},
+		"empty host":     {HostTemplate: provisioner.HostTemplate{Host: "{{ .Labels.job }}"}},
+	}
+	for name, config := range configs {
+		if _, err := config.TargetHost(target); err == nil {
+			t.Errorf("Expected %s error", name)
+		}
+	}
+}
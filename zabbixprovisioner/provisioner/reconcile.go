@@ -0,0 +1,160 @@
+package provisioner
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	changesTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "provisioner_changes_total",
+			Help: "Number of Zabbix objects changed by the provisioner by object type and action",
+		},
+		[]string{"type", "action"},
+	)
+
+	runsTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "provisioner_runs_total",
+			Help: "Number of provisioning runs",
+		},
+	)
+
+	runErrorsTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "provisioner_run_errors_total",
+			Help: "Number of failed provisioning runs",
+		},
+	)
+
+	runDuration = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "provisioner_last_run_duration_seconds",
+			Help: "Duration of the last provisioning run",
+		},
+	)
+
+	lastSuccess = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "provisioner_last_success_timestamp_seconds",
+			Help: "Timestamp of the last successful provisioning run",
+		},
+	)
+)
+
+//recordChanges counts Zabbix objects changed by ApplyChanges
+func recordChanges(objectType, action string, n int) {
+	changesTotal.WithLabelValues(objectType, action).Add(float64(n))
+}
+
+//RunOnce runs the provisioning and records its outcome in the metrics
+func (p *Provisioner) RunOnce() error {
+	start := time.Now()
+	runsTotal.Inc()
+
+	err := p.Run()
+	runDuration.Set(time.Since(start).Seconds())
+	if err != nil {
+		runErrorsTotal.Inc()
+		atomic.StoreInt32(&p.ready, 0)
+		return err
+	}
+
+	lastSuccess.Set(float64(time.Now().Unix()))
+	atomic.StoreInt32(&p.ready, 1)
+	return nil
+}
+
+//RunEvery runs the provisioning every interval and when the rules directories change, until stop is closed.
+// Rules directories are checked for changes every checkInterval, zero disables the check.
+func (p *Provisioner) RunEvery(interval, checkInterval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var check <-chan time.Time
+	if checkInterval > 0 {
+		checkTicker := time.NewTicker(checkInterval)
+		defer checkTicker.Stop()
+		check = checkTicker.C
+	}
+
+	fingerprint := p.rulesFingerprint()
+	for {
+		if err := p.RunOnce(); err != nil {
+			log.Errorf("error provisioning zabbix items: %s", err)
+		} else {
+			log.Infof("provisioning finished, next run in %s", interval)
+		}
+
+	wait:
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				fingerprint = p.rulesFingerprint()
+				break wait
+			case <-check:
+				current := p.rulesFingerprint()
+				if current != fingerprint {
+					log.Info("rules changed, provisioning")
+					fingerprint = current
+					break wait
+				}
+			}
+		}
+	}
+}
+
+//rulesFingerprint summarizes names, sizes and modification times of the rule files
+func (p *Provisioner) rulesFingerprint() string {
+	var fingerprint string
+	for _, host := range p.hosts {
+		if host.HostAlertsDir == "" {
+			continue
+		}
+		err := filepath.Walk(host.HostAlertsDir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				return nil
+			}
+			// Stat follows symlinks, ConfigMap volumes swap the linked directory on updates
+			if target, err := os.Stat(path); err == nil {
+				info = target
+			}
+			fingerprint += fmt.Sprintf("%s:%d:%d;", path, info.Size(), info.ModTime().UnixNano())
+			return nil
+		})
+		if err != nil {
+			log.Warnf("can't check the rules directory %s for changes: %v", host.HostAlertsDir, err)
+		}
+	}
+	return fingerprint
+}
+
+//HandleHealthy reports that the provisioner is running
+func (p *Provisioner) HandleHealthy(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, "Healthy")
+}
+
+//HandleReady reports whether the last provisioning run succeeded
+func (p *Provisioner) HandleReady(w http.ResponseWriter, r *http.Request) {
+	if atomic.LoadInt32(&p.ready) == 0 {
+		http.Error(w, "Not ready", http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, "Ready")
+}
@@ -0,0 +1,177 @@
+package provisioner_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/neogan74/zabbix-alertmanager/zabbixprovisioner/provisioner"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+//metricValue returns the value of the counter or gauge without labels in the default registry
+func metricValue(t *testing.T, name string) float64 {
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, family := range families {
+		if family.GetName() != name || len(family.GetMetric()) == 0 {
+			continue
+		}
+		m := family.GetMetric()[0]
+		if m.GetCounter() != nil {
+			return m.GetCounter().GetValue()
+		}
+		return m.GetGauge().GetValue()
+	}
+	return 0
+}
+
+func readyCode(p *provisioner.Provisioner) int {
+	rr := httptest.NewRecorder()
+	p.HandleReady(rr, httptest.NewRequest("GET", "/-/ready", nil))
+	return rr.Code
+}
+
+//waitForCalls waits until the fake Zabbix got n calls of the method
+func waitForCalls(t *testing.T, z *fakeZabbix, method string, n int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, byMethod := z.callCount(); byMethod[method] >= n {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	_, byMethod := z.callCount()
+	t.Fatalf("Expected %d %s calls, got %d", n, method, byMethod[method])
+}
+
+//newRulesProvisioner provisioner of a template with the rules of the directory
+func newRulesProvisioner(t *testing.T, z *fakeZabbix, dir string) (*provisioner.Provisioner, *httptest.Server) {
+	ts := httptest.NewServer(z)
+	hosts := []provisioner.HostConfig{{
+		Name:               "node",
+		HostGroups:         []string{"Prometheus"},
+		TemplateHostGroups: []string{"Templates"},
+		HostAlertsDir:      dir,
+	}}
+	p, err := provisioner.New("", "prometheus", ts.URL, "user", "password", hosts, provisioner.Options{})
+	if err != nil {
+		ts.Close()
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return p, ts
+}
+
+func waitForReady(t *testing.T, p *provisioner.Provisioner) {
+	deadline := time.Now().Add(5 * time.Second)
+	for readyCode(p) != http.StatusOK {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected to be ready after the first run")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRunOnceReadiness(t *testing.T) {
+	z := newFakeZabbix(0, 0, 0)
+	p, ts := newRulesProvisioner(t, z, rulesOKpath)
+	defer ts.Close()
+
+	if code := readyCode(p); code != http.StatusServiceUnavailable {
+		t.Errorf("Expected not ready before the first run, got %d", code)
+	}
+
+	runs, runErrors := metricValue(t, "provisioner_runs_total"), metricValue(t, "provisioner_run_errors_total")
+	z.mu.Lock()
+	z.failures = map[string]int{"template.get": 1}
+	z.mu.Unlock()
+	if err := p.RunOnce(); err == nil {
+		t.Fatal("Expected an error")
+	}
+	if code := readyCode(p); code != http.StatusServiceUnavailable {
+		t.Errorf("Expected not ready after a failed run, got %d", code)
+	}
+	if metricValue(t, "provisioner_runs_total") != runs+1 || metricValue(t, "provisioner_run_errors_total") != runErrors+1 {
+		t.Errorf("Expected the failed run to be counted")
+	}
+
+	before := time.Now().Unix()
+	if err := p.RunOnce(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if code := readyCode(p); code != http.StatusOK {
+		t.Errorf("Expected ready after a successful run, got %d", code)
+	}
+	if metricValue(t, "provisioner_runs_total") != runs+2 || metricValue(t, "provisioner_run_errors_total") != runErrors+1 {
+		t.Errorf("Expected only the successful run to be added")
+	}
+	if metricValue(t, "provisioner_last_success_timestamp_seconds") < float64(before) {
+		t.Errorf("Expected the time of the successful run")
+	}
+	if metricValue(t, "provisioner_last_run_duration_seconds") <= 0 {
+		t.Errorf("Expected the duration of the run")
+	}
+
+	rr := httptest.NewRecorder()
+	p.HandleHealthy(rr, httptest.NewRequest("GET", "/-/healthy", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected healthy, got %d", rr.Code)
+	}
+}
+
+func TestRunEveryRulesChange(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rules")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	rules, err := ioutil.ReadFile(filepath.Join(rulesOKpath, "rulesOK_test.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "rules.yaml"), rules, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	z := newFakeZabbix(0, 0, 0)
+	p, ts := newRulesProvisioner(t, z, dir)
+	defer ts.Close()
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		p.RunEvery(time.Hour, 10*time.Millisecond, stop)
+		close(done)
+	}()
+
+	waitForReady(t, p)
+	waitForCalls(t, z, "template.create", 1)
+
+	// Unchanged rules don't trigger a run before the interval
+	time.Sleep(50 * time.Millisecond)
+	if _, byMethod := z.callCount(); byMethod["template.get"] != 1 {
+		t.Errorf("Expected no run without changes, got %d template.get calls", byMethod["template.get"])
+	}
+
+	changed, err := ioutil.ReadFile(filepath.Join(rulesOKpath, "rulesOKcycle_test.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "rules.yaml"), changed, 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitForCalls(t, z, "template.get", 2)
+
+	close(stop)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected RunEvery to return when stop is closed")
+	}
+}
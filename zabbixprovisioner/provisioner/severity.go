@@ -0,0 +1,118 @@
+package provisioner
+
+import (
+	"sort"
+	"strings"
+
+	zabbix "github.com/neogan74/zabbix-alertmanager/zabbixprovisioner/zabbixclient"
+)
+
+//DefaultSeverityLabel label used for the trigger priority when the mapping doesn't define one
+const DefaultSeverityLabel = "severity"
+
+//priorityNames names used for Zabbix priorities in the configuration and in dynamic severity item keys
+var priorityNames = map[zabbix.PriorityType]string{
+	zabbix.NotClassified: "not_classified",
+	zabbix.Information:   "information",
+	zabbix.Warning:       "warning",
+	zabbix.Average:       "average",
+	zabbix.High:          "high",
+	zabbix.Critical:      "disaster",
+}
+
+//SeverityMapping maps a Prometheus label value to a Zabbix trigger priority.
+// Values are matched case insensitive, values which are not in the table are parsed as Zabbix priority names.
+// When Dynamic is set, items and triggers are created per priority and zal send picks the item from the alert labels.
+type SeverityMapping struct {
+	Label   string            `yaml:"label"`
+	Values  map[string]string `yaml:"values"`
+	Default string            `yaml:"default"`
+	Dynamic bool              `yaml:"dynamic"`
+}
+
+//LabelName returns the label holding the severity
+func (m SeverityMapping) LabelName() string {
+	if m.Label == "" {
+		return DefaultSeverityLabel
+	}
+	return m.Label
+}
+
+//Priority returns the Zabbix priority for the given set of labels
+func (m SeverityMapping) Priority(labels map[string]string) zabbix.PriorityType {
+	if v, ok := labels[m.LabelName()]; ok {
+		if p, ok := m.lookup(v); ok {
+			return p
+		}
+	}
+
+	p, _ := ParseZabbixPriority(m.Default)
+	return p
+}
+
+//Priorities returns all priorities the mapping can produce, sorted from the lowest
+func (m SeverityMapping) Priorities() []zabbix.PriorityType {
+	set := map[zabbix.PriorityType]struct{}{}
+	for _, v := range m.Values {
+		if p, ok := ParseZabbixPriority(v); ok {
+			set[p] = struct{}{}
+		}
+	}
+	if len(m.Values) == 0 {
+		for p := range priorityNames {
+			set[p] = struct{}{}
+		}
+	}
+	p, _ := ParseZabbixPriority(m.Default)
+	set[p] = struct{}{}
+
+	priorities := make([]zabbix.PriorityType, 0, len(set))
+	for p := range set {
+		priorities = append(priorities, p)
+	}
+	sort.Slice(priorities, func(i, j int) bool { return priorities[i] < priorities[j] })
+	return priorities
+}
+
+//IsDynamic reports whether the rule severity is only known when the alert fires, when the label is templated.
+// Rules without the label get the default priority.
+func (m SeverityMapping) IsDynamic(labels map[string]string) bool {
+	return strings.Contains(labels[m.LabelName()], "{{")
+}
+
+func (m SeverityMapping) lookup(value string) (zabbix.PriorityType, bool) {
+	for k, v := range m.Values {
+		if strings.EqualFold(k, value) {
+			return ParseZabbixPriority(v)
+		}
+	}
+	return ParseZabbixPriority(value)
+}
+
+//PriorityName returns the name of the priority which is used as a suffix in dynamic severity item keys
+func PriorityName(p zabbix.PriorityType) string {
+	if name, ok := priorityNames[p]; ok {
+		return name
+	}
+	return priorityNames[zabbix.NotClassified]
+}
+
+//ParseZabbixPriority parses a Zabbix priority name, ok is false for unknown names
+func ParseZabbixPriority(name string) (zabbix.PriorityType, bool) {
+	switch strings.Replace(strings.ToLower(strings.TrimSpace(name)), " ", "_", -1) {
+	case "not_classified", "0":
+		return zabbix.NotClassified, true
+	case "information", "info", "1":
+		return zabbix.Information, true
+	case "warning", "2":
+		return zabbix.Warning, true
+	case "average", "3":
+		return zabbix.Average, true
+	case "high", "4":
+		return zabbix.High, true
+	case "disaster", "critical", "5":
+		return zabbix.Critical, true
+	default:
+		return zabbix.NotClassified, false
+	}
+}
@@ -0,0 +1,391 @@
+package provisioner
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	zabbix "github.com/neogan74/zabbix-alertmanager/zabbixprovisioner/zabbixclient"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	yaml "gopkg.in/yaml.v2"
+)
+
+//SnapshotVersion version of the snapshot format written by Snapshot
+const SnapshotVersion = 1
+
+//Snapshot of the Zabbix objects managed by the provisioner.
+// Objects are referenced by name, Zabbix ids are not kept so a snapshot can be restored after the objects were deleted.
+type Snapshot struct {
+	Version    int                `yaml:"version" json:"version"`
+	CreatedAt  time.Time          `yaml:"createdAt" json:"createdAt"`
+	HostGroups []string           `yaml:"hostGroups" json:"hostGroups"`
+	Templates  []SnapshotTemplate `yaml:"templates" json:"templates"`
+	Hosts      []SnapshotHost     `yaml:"hosts" json:"hosts"`
+}
+
+//SnapshotTemplate template with its applications, items and triggers
+type SnapshotTemplate struct {
+	Name         string            `yaml:"name" json:"name"`
+	DisplayName  string            `yaml:"displayName" json:"displayName"`
+	Description  string            `yaml:"description" json:"description"`
+	HostGroups   []string          `yaml:"hostGroups" json:"hostGroups"`
+	Applications []string          `yaml:"applications" json:"applications"`
+	Items        []SnapshotItem    `yaml:"items" json:"items"`
+	Triggers     []SnapshotTrigger `yaml:"triggers" json:"triggers"`
+}
+
+//SnapshotItem item of a template
+type SnapshotItem struct {
+	Key          string            `yaml:"key" json:"key"`
+	Name         string            `yaml:"name" json:"name"`
+	Type         zabbix.ItemType   `yaml:"type" json:"type"`
+	ValueType    zabbix.ValueType  `yaml:"valueType" json:"valueType"`
+	Delay        string            `yaml:"delay,omitempty" json:"delay,omitempty"`
+	Description  string            `yaml:"description" json:"description"`
+	Status       zabbix.StatusType `yaml:"status" json:"status"`
+	History      string            `yaml:"history,omitempty" json:"history,omitempty"`
+	Trends       string            `yaml:"trends,omitempty" json:"trends,omitempty"`
+	TrapperHosts string            `yaml:"trapperHosts,omitempty" json:"trapperHosts,omitempty"`
+	Applications []string          `yaml:"applications" json:"applications"`
+}
+
+//SnapshotTrigger trigger of a template
+type SnapshotTrigger struct {
+	Description string              `yaml:"description" json:"description"`
+	Expression  string              `yaml:"expression" json:"expression"`
+	Comments    string              `yaml:"comments,omitempty" json:"comments,omitempty"`
+	URL         string              `yaml:"url,omitempty" json:"url,omitempty"`
+	ManualClose int32               `yaml:"manualClose" json:"manualClose"`
+	Priority    zabbix.PriorityType `yaml:"priority" json:"priority"`
+	Status      zabbix.StatusType   `yaml:"status" json:"status"`
+	Tags        []zabbix.Tag        `yaml:"tags,omitempty" json:"tags,omitempty"`
+}
+
+//SnapshotHost host with its host groups, linked templates and main interfaces
+type SnapshotHost struct {
+	Host       string              `yaml:"host" json:"host"`
+	Name       string              `yaml:"name" json:"name"`
+	Status     zabbix.StatusType   `yaml:"status" json:"status"`
+	HostGroups []string            `yaml:"hostGroups" json:"hostGroups"`
+	Templates  []string            `yaml:"templates" json:"templates"`
+	Interfaces []SnapshotInterface `yaml:"interfaces" json:"interfaces"`
+	Inventory  map[string]string   `yaml:"inventory,omitempty" json:"inventory,omitempty"`
+	Tags       []zabbix.Tag        `yaml:"tags,omitempty" json:"tags,omitempty"`
+}
+
+//SnapshotInterface interface of a host
+type SnapshotInterface struct {
+	Type          zabbix.InterfaceType `yaml:"type" json:"type"`
+	Main          int                  `yaml:"main" json:"main"`
+	UseIP         int                  `yaml:"useIp" json:"useIp"`
+	IP            string               `yaml:"ip,omitempty" json:"ip,omitempty"`
+	DNS           string               `yaml:"dns,omitempty" json:"dns,omitempty"`
+	Port          string               `yaml:"port" json:"port"`
+	SNMPVersion   string               `yaml:"snmpVersion,omitempty" json:"snmpVersion,omitempty"`
+	SNMPBulk      string               `yaml:"snmpBulk,omitempty" json:"snmpBulk,omitempty"`
+	SNMPCommunity string               `yaml:"snmpCommunity,omitempty" json:"snmpCommunity,omitempty"`
+}
+
+//Snapshot loads the objects managed by the provisioner from Zabbix
+func (p *Provisioner) Snapshot() (*Snapshot, error) {
+	p.CustomZabbix = &CustomZabbix{
+		Hosts:      map[string]*CustomHost{},
+		Templates:  map[string]*CustomTemplate{},
+		HostGroups: map[string]*CustomHostGroup{},
+	}
+	if err := p.LoadDataFromZabbix(); err != nil {
+		return nil, errors.Wrap(err, "error loading zabbix objects")
+	}
+
+	s := &Snapshot{Version: SnapshotVersion, CreatedAt: time.Now().UTC()}
+	for name := range p.HostGroups {
+		s.HostGroups = append(s.HostGroups, name)
+	}
+	sort.Strings(s.HostGroups)
+
+	for _, tmpl := range p.Templates {
+		s.Templates = append(s.Templates, snapshotTemplate(tmpl))
+	}
+	sort.Slice(s.Templates, func(i, j int) bool { return s.Templates[i].Name < s.Templates[j].Name })
+
+	for _, host := range p.Hosts {
+		s.Hosts = append(s.Hosts, snapshotHost(host))
+	}
+	sort.Slice(s.Hosts, func(i, j int) bool { return s.Hosts[i].Host < s.Hosts[j].Host })
+
+	log.Infof("snapshot of %d host groups, %d templates and %d hosts", len(s.HostGroups), len(s.Templates), len(s.Hosts))
+	return s, nil
+}
+
+//Restore applies the snapshot as the desired state, objects are created or changed to match it.
+// Managed objects missing in the snapshot are handled by the orphan policies.
+func (p *Provisioner) Restore(s *Snapshot) error {
+	if s.Version != SnapshotVersion {
+		return errors.Errorf("unsupported snapshot version: %d", s.Version)
+	}
+
+	// Only the templates of the config are loaded from Zabbix, others would be created again on every restore
+	configured := make(map[string]struct{}, len(p.hosts))
+	for _, hostConfig := range p.hosts {
+		configured[hostConfig.Name] = struct{}{}
+	}
+	for _, tmpl := range s.Templates {
+		if _, ok := configured[tmpl.Name]; !ok {
+			return errors.Errorf("template %s of the snapshot isn't in the config", tmpl.Name)
+		}
+	}
+
+	p.CustomZabbix = &CustomZabbix{
+		Hosts:      map[string]*CustomHost{},
+		Templates:  map[string]*CustomTemplate{},
+		HostGroups: map[string]*CustomHostGroup{},
+	}
+	for _, name := range s.HostGroups {
+		p.AddHostGroup(&CustomHostGroup{State: StateNew, HostGroup: zabbix.HostGroup{Name: name}})
+	}
+	for _, tmpl := range s.Templates {
+		p.AddTemplate(tmpl.customTemplate())
+	}
+	for _, host := range s.Hosts {
+		p.AddHost(host.customHost())
+	}
+
+	if err := p.LoadDataFromZabbix(); err != nil {
+		return errors.Wrap(err, "error loading zabbix objects")
+	}
+	if err := p.ApplyChanges(); err != nil {
+		return errors.Wrap(err, "error applying changes")
+	}
+	return nil
+}
+
+func snapshotTemplate(tmpl *CustomTemplate) SnapshotTemplate {
+	res := SnapshotTemplate{
+		Name:        tmpl.Name,
+		DisplayName: tmpl.DisplayName,
+		Description: tmpl.Description,
+		HostGroups:  sortedSet(tmpl.HostGroups),
+	}
+	for name := range tmpl.Applications {
+		res.Applications = append(res.Applications, name)
+	}
+	sort.Strings(res.Applications)
+
+	for _, item := range tmpl.Items {
+		res.Items = append(res.Items, SnapshotItem{
+			Key:          item.Key,
+			Name:         item.Name,
+			Type:         item.Type,
+			ValueType:    item.ValueType,
+			Delay:        item.Delay,
+			Description:  item.Description,
+			Status:       item.Status,
+			History:      item.History,
+			Trends:       item.Trends,
+			TrapperHosts: item.TrapperHosts,
+			Applications: sortedSet(item.Applications),
+		})
+	}
+	sort.Slice(res.Items, func(i, j int) bool { return res.Items[i].Key < res.Items[j].Key })
+
+	for _, trigger := range tmpl.Triggers {
+		res.Triggers = append(res.Triggers, SnapshotTrigger{
+			Description: trigger.Description,
+			Expression:  trigger.Expression,
+			Comments:    trigger.Comments,
+			URL:         trigger.URL,
+			ManualClose: int32(trigger.ManualClose),
+			Priority:    trigger.Priority,
+			Status:      trigger.Status,
+			Tags:        trigger.Tags,
+		})
+	}
+	sort.Slice(res.Triggers, func(i, j int) bool { return res.Triggers[i].Expression < res.Triggers[j].Expression })
+	return res
+}
+
+func snapshotHost(host *CustomHost) SnapshotHost {
+	res := SnapshotHost{
+		Host:       host.Host.Host,
+		Name:       host.Name,
+		Status:     host.Status,
+		HostGroups: sortedSet(host.HostGroups),
+		Templates:  sortedSet(host.Templates),
+		Tags:       host.Tags,
+	}
+	if len(host.Inventory) != 0 {
+		res.Inventory = host.Inventory
+	}
+	for _, iface := range host.Interfaces {
+		snapshotIface := SnapshotInterface{
+			Type:  iface.Type,
+			Main:  int(iface.Main),
+			UseIP: int(iface.UseIP),
+			IP:    iface.IP,
+			DNS:   iface.DNS,
+			Port:  iface.Port,
+		}
+		if iface.Details != nil {
+			snapshotIface.SNMPVersion = iface.Details.Version
+			snapshotIface.SNMPBulk = iface.Details.Bulk
+			snapshotIface.SNMPCommunity = iface.Details.Community
+		}
+		res.Interfaces = append(res.Interfaces, snapshotIface)
+	}
+	return res
+}
+
+func (t SnapshotTemplate) customTemplate() *CustomTemplate {
+	tmpl := &CustomTemplate{
+		State: StateNew,
+		Template: zabbix.Template{
+			Name:        t.Name,
+			DisplayName: t.DisplayName,
+			Description: t.Description,
+		},
+		HostGroups:   setOf(t.HostGroups),
+		Applications: make(map[string]*CustomApplication, len(t.Applications)),
+		Items:        make(map[string]*CustomItem, len(t.Items)),
+		Triggers:     make(map[string]*CustomTrigger, len(t.Triggers)),
+	}
+	for _, name := range t.Applications {
+		tmpl.AddApplication(&CustomApplication{State: StateNew, Application: zabbix.Application{Name: name}})
+	}
+	for _, item := range t.Items {
+		tmpl.AddItem(&CustomItem{
+			State: StateNew,
+			Item: zabbix.Item{
+				Key:          item.Key,
+				Name:         item.Name,
+				Type:         item.Type,
+				ValueType:    item.ValueType,
+				Delay:        item.Delay,
+				Description:  item.Description,
+				Status:       item.Status,
+				History:      item.History,
+				Trends:       item.Trends,
+				TrapperHosts: item.TrapperHosts,
+			},
+			Applications: setOf(item.Applications),
+			Source:       "snapshot",
+		})
+	}
+	for _, trigger := range t.Triggers {
+		tmpl.AddTrigger(&CustomTrigger{
+			State: StateNew,
+			Trigger: zabbix.Trigger{
+				Description: trigger.Description,
+				Expression:  trigger.Expression,
+				Comments:    trigger.Comments,
+				URL:         trigger.URL,
+				ManualClose: zabbix.Int(trigger.ManualClose),
+				Priority:    trigger.Priority,
+				Status:      trigger.Status,
+				Tags:        trigger.Tags,
+			},
+		})
+	}
+	return tmpl
+}
+
+func (h SnapshotHost) customHost() *CustomHost {
+	host := &CustomHost{
+		State: StateNew,
+		Host: zabbix.Host{
+			Host:      h.Host,
+			Name:      h.Name,
+			Status:    h.Status,
+			Available: 1,
+			Tags:      h.Tags,
+		},
+		HostGroups:   setOf(h.HostGroups),
+		Templates:    setOf(h.Templates),
+		Applications: map[string]*CustomApplication{},
+		Items:        map[string]*CustomItem{},
+		Triggers:     map[string]*CustomTrigger{},
+	}
+	if len(h.Inventory) != 0 {
+		host.InventoryMode = zabbix.InventoryManual
+		host.Inventory = h.Inventory
+	}
+	for _, iface := range h.Interfaces {
+		hostIface := zabbix.HostInterface{
+			Type:  iface.Type,
+			Main:  zabbix.Int(iface.Main),
+			UseIP: zabbix.Int(iface.UseIP),
+			IP:    iface.IP,
+			DNS:   iface.DNS,
+			Port:  iface.Port,
+		}
+		if iface.Type == zabbix.SNMP {
+			hostIface.Details = &zabbix.HostInterfaceDetails{
+				Version:   iface.SNMPVersion,
+				Bulk:      iface.SNMPBulk,
+				Community: iface.SNMPCommunity,
+			}
+		}
+		host.Interfaces = append(host.Interfaces, hostIface)
+	}
+	return host
+}
+
+//WriteSnapshotToFile writes the snapshot as JSON when the file name ends with .json, YAML otherwise
+func WriteSnapshotToFile(filename string, s *Snapshot) error {
+	var (
+		data []byte
+		err  error
+	)
+	if isJSONFile(filename) {
+		data, err = json.MarshalIndent(s, "", "  ")
+	} else {
+		data, err = yaml.Marshal(s)
+	}
+	if err != nil {
+		return errors.Wrap(err, "can't encode snapshot")
+	}
+	return errors.Wrapf(ioutil.WriteFile(filename, data, 0644), "can't write snapshot file: %s", filename)
+}
+
+//LoadSnapshotFromFile reads a snapshot written by WriteSnapshotToFile
+func LoadSnapshotFromFile(filename string) (*Snapshot, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't open the snapshot file: %s", filename)
+	}
+
+	var s Snapshot
+	if isJSONFile(filename) {
+		err = json.Unmarshal(data, &s)
+	} else {
+		err = yaml.Unmarshal(data, &s)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't read the snapshot file: %s", filename)
+	}
+	return &s, nil
+}
+
+func isJSONFile(filename string) bool {
+	return strings.ToLower(filepath.Ext(filename)) == ".json"
+}
+
+func sortedSet(set map[string]struct{}) []string {
+	res := make([]string, 0, len(set))
+	for name := range set {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res
+}
+
+func setOf(names []string) map[string]struct{} {
+	res := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		res[name] = struct{}{}
+	}
+	return res
+}
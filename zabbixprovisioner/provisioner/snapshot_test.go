@@ -0,0 +1,158 @@
+package provisioner_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/neogan74/zabbix-alertmanager/zabbixprovisioner/provisioner"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	z := newFakeZabbix(2, 3, 2)
+	p, ts := newFakeProvisioner(t, z, provisioner.Options{})
+	defer ts.Close()
+
+	s, err := p.Snapshot()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(s.Templates) != 2 || len(s.Hosts) != 2 {
+		t.Fatalf("Expected 2 templates and 2 hosts, got %d and %d", len(s.Templates), len(s.Hosts))
+	}
+	template := s.Templates[1]
+	if template.Name != templateName(1) || len(template.Items) != 3 || len(template.Triggers) != 3 {
+		t.Errorf("Expected %s with 3 items and triggers, got %+v", templateName(1), template)
+	}
+	if host := s.Hosts[0]; len(host.Templates) != 1 || host.Templates[0] != templateName(0) || len(host.Interfaces) != 1 {
+		t.Errorf("Expected host linked to %s with its interface, got %+v", templateName(0), host)
+	}
+
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"snapshot.yaml", "snapshot.json"} {
+		filename := filepath.Join(dir, name)
+		if err := provisioner.WriteSnapshotToFile(filename, s); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		loaded, err := provisioner.LoadSnapshotFromFile(filename)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if !loaded.CreatedAt.Equal(s.CreatedAt) {
+			t.Errorf("%s: expected created at %s, got %s", name, s.CreatedAt, loaded.CreatedAt)
+		}
+		loaded.CreatedAt = s.CreatedAt
+		if !reflect.DeepEqual(loaded, s) {
+			t.Errorf("%s: expected %+v, got %+v", name, s, loaded)
+		}
+	}
+
+	// Restoring the unchanged state changes nothing
+	z.resetCalls()
+	if err := p.Restore(s); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	_, byMethod := z.callCount()
+	for method := range byMethod {
+		if len(z.changed(method)) != 0 {
+			t.Errorf("Expected no changes, got %s", method)
+		}
+	}
+
+	s.Version = 2
+	if err := p.Restore(s); err == nil {
+		t.Error("Expected to get error for unknown version, got :", err)
+	}
+}
+
+func TestSnapshotRestoreChanges(t *testing.T) {
+	z := newFakeZabbix(2, 3, 0)
+	p, ts := newFakeProvisioner(t, z, provisioner.Options{})
+	defer ts.Close()
+
+	s, err := p.Snapshot()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Alert2 is deleted in Zabbix, the name of the item Alert0 and the priority of the trigger Alert1 are changed
+	z.edit = func(method string, objects []map[string]interface{}) []map[string]interface{} {
+		var edited []map[string]interface{}
+		for _, object := range objects {
+			switch {
+			case method == "item.get" && object["key_"] == "prometheus.alert2",
+				method == "trigger.get" && object["description"] == "Alert2":
+				continue
+			case method == "item.get" && object["key_"] == "prometheus.alert0":
+				object["name"] = "Changed"
+			case method == "trigger.get" && object["description"] == "Alert1":
+				object["priority"] = "5"
+			}
+			edited = append(edited, object)
+		}
+		return edited
+	}
+	if err := p.Restore(s); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := map[string]string{
+		"item.create":    `"key_":"prometheus.alert2"`,
+		"item.update":    `"name":"Alert0"`,
+		"trigger.create": `"description":"Alert2"`,
+		"trigger.update": `"priority":3`,
+	}
+	for method, field := range expected {
+		changed := z.changed(method)
+		if len(changed) != z.templates {
+			t.Errorf("Expected a %s call per template, got %d", method, len(changed))
+			continue
+		}
+		for _, params := range changed {
+			var objects []json.RawMessage
+			if err := json.Unmarshal(params, &objects); err != nil {
+				t.Fatal(err)
+			}
+			if len(objects) != 1 || !strings.Contains(string(objects[0]), field) {
+				t.Errorf("Expected %s of one object with %s, got %s", method, field, params)
+			}
+		}
+	}
+	for _, method := range []string{"item.delete", "trigger.delete"} {
+		if len(z.changed(method)) != 0 {
+			t.Errorf("Expected no %s calls, got %s", method, z.changed(method))
+		}
+	}
+}
+
+func TestSnapshotRestoreRemovalLimit(t *testing.T) {
+	z := newFakeZabbix(1, 3, 0)
+	p, ts := newFakeProvisioner(t, z, provisioner.Options{Cleanup: provisioner.CleanupConfig{MaxRemovalPercent: 50}})
+	defer ts.Close()
+
+	s, err := p.Snapshot()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// A truncated snapshot would delete all the items and triggers
+	s.Templates[0].Items, s.Templates[0].Triggers = nil, nil
+	if err := p.Restore(s); err == nil {
+		t.Fatal("Expected the removal limit to abort the restore")
+	}
+	_, byMethod := z.callCount()
+	for method := range byMethod {
+		if len(z.changed(method)) != 0 {
+			t.Errorf("Expected no changes, got %s", method)
+		}
+	}
+}
@@ -0,0 +1,326 @@
+package provisioner
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+	yaml "gopkg.in/yaml.v2"
+)
+
+//Zabbix limits checked by ValidateConfigFile
+const (
+	maxItemKeyLength     = 255
+	maxTriggerNameLength = 255
+)
+
+var (
+	// History and trends are seconds with an optional time suffix, or a user macro
+	zabbixPeriodRe = regexp.MustCompile(`^(\d+[smhdw]?|\{\$[A-Z0-9_.]+(:.*)?\})$`)
+	yamlLineRe     = regexp.MustCompile(`line (\d+): `)
+)
+
+//Problem found by ValidateConfigFile, Line is 0 when the location in the file is unknown
+type Problem struct {
+	File    string
+	Line    int
+	Message string
+}
+
+func (p Problem) String() string {
+	if p.Line == 0 {
+		return fmt.Sprintf("%s: %s", p.File, p.Message)
+	}
+	return fmt.Sprintf("%s:%d: %s", p.File, p.Line, p.Message)
+}
+
+//ValidateConfigFile checks the provisioner config without calling Zabbix and returns all the problems found.
+// The config is decoded strictly, unknown fields are problems. The rules of every host config are loaded,
+// with offline set only the rules directories, and their item keys and triggers are computed like zal prov does.
+func ValidateConfigFile(filename, keyPrefix string, offline bool) []Problem {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return []Problem{{File: filename, Message: err.Error()}}
+	}
+
+	v := &validator{lines: newYAMLLines(filename, data), files: map[string]*yamlLines{}}
+
+	var hosts []HostConfig
+	if err := yaml.UnmarshalStrict(data, &hosts); err != nil {
+		typeErr, ok := err.(*yaml.TypeError)
+		if !ok {
+			return []Problem{yamlProblem(filename, err.Error())}
+		}
+		// Type errors and unknown fields don't stop the decoding, the rest of the config is still checked
+		for _, msg := range typeErr.Errors {
+			v.problems = append(v.problems, yamlProblem(filename, msg))
+		}
+	}
+
+	names := map[string]int{}
+	sendHosts := map[string]int{}
+	for i, host := range hosts {
+		if host.Name != "" {
+			if previous, ok := names[host.Name]; ok {
+				v.addf(i, "name", "duplicate name %s, also used by entry %d", host.Name, previous+1)
+			}
+			names[host.Name] = i
+		}
+		for _, sendHost := range host.Hosts {
+			if previous, ok := sendHosts[sendHost]; ok && previous != i {
+				v.addf(i, "hosts", "host %s is also listed by entry %d", sendHost, previous+1)
+			}
+			sendHosts[sendHost] = i
+		}
+		v.host(i, host, keyPrefix, offline)
+	}
+	if len(hosts) == 0 && len(v.problems) == 0 {
+		v.problems = append(v.problems, Problem{File: filename, Message: "no hosts are defined"})
+	}
+	return v.problems
+}
+
+type validator struct {
+	lines    *yamlLines
+	files    map[string]*yamlLines
+	problems []Problem
+}
+
+//addf adds a problem of a field of the config entry
+func (v *validator) addf(entry int, field, format string, args ...interface{}) {
+	v.problems = append(v.problems, Problem{
+		File:    v.lines.filename,
+		Line:    v.lines.field(entry, field),
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+func (v *validator) host(i int, host HostConfig, keyPrefix string, offline bool) {
+	if host.Name == "" {
+		v.addf(i, "name", "name is required")
+	}
+	if host.HostAlertsDir == "" && len(host.RulesURLs) == 0 {
+		v.addf(i, "alertsDir", "alertsDir or rulesUrls is required")
+	}
+	if host.HostAlertsDir != "" {
+		if info, err := os.Stat(host.HostAlertsDir); err != nil {
+			v.addf(i, "alertsDir", "%v", err)
+		} else if !info.IsDir() {
+			v.addf(i, "alertsDir", "alertsDir %s is not a directory", host.HostAlertsDir)
+		}
+	}
+	if host.ItemDefaultApplication == "" {
+		v.addf(i, "itemDefaultApplication", "itemDefaultApplication is required")
+	}
+	if len(host.TemplateHostGroups) == 0 {
+		v.addf(i, "templateHostGroups", "templateHostGroups is required")
+	}
+	if host.PrometheusUrl != "" && len(host.HostGroups) == 0 {
+		v.addf(i, "hostGroups", "hostGroups is required with prometheusUrl")
+	}
+
+	periods := []struct{ field, value string }{
+		{"itemDefaultHistory", host.ItemDefaultHistory},
+		{"itemDefaultTrends", host.ItemDefaultTrends},
+	}
+	for _, period := range periods {
+		if period.value != "" && !zabbixPeriodRe.MatchString(period.value) {
+			v.addf(i, period.field, "invalid %s %q, expected seconds with an optional s, m, h, d or w suffix or a user macro", period.field, period.value)
+		}
+	}
+
+	urls := host.RulesURLs
+	if host.PrometheusUrl != "" {
+		urls = append([]string{host.PrometheusUrl}, urls...)
+	}
+	for _, u := range urls {
+		// The scheme is optional like for the requests
+		if parsed, err := url.Parse(prometheusAPIURL(u, "")); err != nil || parsed.Host == "" {
+			v.addf(i, "prometheusUrl", "invalid url %q", u)
+		}
+	}
+
+	if err := host.ItemKey.Validate(); err != nil {
+		v.addf(i, "itemKey", "%v", err)
+	}
+	if host.SeverityMapping.Default != "" {
+		if _, ok := ParseZabbixPriority(host.SeverityMapping.Default); !ok {
+			v.addf(i, "default", "unknown severity default priority %s", host.SeverityMapping.Default)
+		}
+	}
+	for _, value := range sortedKeys(host.SeverityMapping.Values) {
+		if _, ok := ParseZabbixPriority(host.SeverityMapping.Values[value]); !ok {
+			v.addf(i, "values", "unknown priority %s for severity %s", host.SeverityMapping.Values[value], value)
+		}
+	}
+	if _, err := NewTargetSelector(host.TargetSelector); err != nil {
+		v.addf(i, "targetSelector", "%v", err)
+	}
+	target := PrometheusTarget{Host: "example.com", Labels: map[string]string{}}
+	if _, err := host.TargetHost(target); err != nil {
+		v.addf(i, "hostTemplate", "%v", err)
+	}
+	client, err := host.PrometheusHTTPConfig.NewClient()
+	if err != nil {
+		v.addf(i, "prometheusHttpConfig", "%v", err)
+	}
+
+	var rules []PrometheusRule
+	if host.HostAlertsDir != "" {
+		// Every file is loaded to report the problems of all of them
+		err := walkRuleFiles(host.HostAlertsDir, func(path string) error {
+			fileRules, err := LoadPrometheusRulesFromFile(path)
+			if err != nil {
+				v.problems = append(v.problems, yamlProblem(path, errors.Cause(err).Error()))
+			}
+			rules = append(rules, fileRules...)
+			return nil
+		})
+		if err != nil && !os.IsNotExist(errors.Cause(err)) {
+			v.addf(i, "alertsDir", "%v", err)
+		}
+	}
+	if len(host.RulesURLs) != 0 && !offline && client != nil {
+		apiRules, err := LoadPrometheusRulesFromAPI(client, host.RulesURLs)
+		if err != nil {
+			v.addf(i, "rulesUrls", "%v", err)
+		}
+		rules = append(rules, apiRules...)
+	}
+	v.rules(host, rules, keyPrefix)
+}
+
+//rules computes the items and triggers of the rules like LoadRulesFromPrometheus
+func (v *validator) rules(host HostConfig, rules []PrometheusRule, keyPrefix string) {
+	if host.ItemKey.Validate() != nil {
+		return
+	}
+
+	p := &Provisioner{keyPrefix: keyPrefix}
+	template := &CustomTemplate{
+		Applications: map[string]*CustomApplication{},
+		Items:        map[string]*CustomItem{},
+		Triggers:     map[string]*CustomTrigger{},
+	}
+	template.Name = host.Name
+
+	// Rules with the same alert name in a file are located by their occurrence
+	occurrences := map[string]int{}
+	for _, rule := range rules {
+		n := occurrences[rule.File+"\x00"+rule.Name]
+		occurrences[rule.File+"\x00"+rule.Name]++
+
+		for _, duration := range []struct{ field, value string }{{"for", rule.For}, {"keep_firing_for", rule.KeepFiringFor}} {
+			if _, err := model.ParseDuration(duration.value); duration.value != "" && err != nil {
+				v.rule(rule, n, "invalid %s %q of alert %s: %v", duration.field, duration.value, rule.Name, err)
+			}
+		}
+		if strings.TrimSpace(rule.Expression) == "" {
+			v.rule(rule, n, "empty expr of alert %s", rule.Name)
+		}
+		if len(rule.Name) > maxTriggerNameLength {
+			v.rule(rule, n, "alert name is longer than %d characters: %s", maxTriggerNameLength, rule.Name)
+		}
+		if key, err := host.ItemKey.RuleKey(keyPrefix, rule); err == nil && len(key) > maxItemKeyLength {
+			v.rule(rule, n, "item key of alert %s is longer than %d characters: %s", rule.Name, maxItemKeyLength, key)
+		}
+		if err := p.addRule(template, host, rule); err != nil {
+			v.rule(rule, n, "%v", err)
+		}
+	}
+}
+
+//rule adds a problem of the rule, located by the line of the nth alert with its name
+func (v *validator) rule(rule PrometheusRule, n int, format string, args ...interface{}) {
+	problem := Problem{File: rule.File, Message: fmt.Sprintf(format, args...)}
+	if rule.File != "" {
+		lines, ok := v.files[rule.File]
+		if !ok {
+			if data, err := ioutil.ReadFile(rule.File); err == nil {
+				lines = newYAMLLines(rule.File, data)
+			}
+			v.files[rule.File] = lines
+		}
+		if lines != nil {
+			problem.Line = lines.alert(rule.Name, n)
+		}
+	}
+	v.problems = append(v.problems, problem)
+}
+
+//yamlProblem turns a YAML error into a problem, using the line number of the message when there is one
+func yamlProblem(filename, msg string) Problem {
+	problem := Problem{File: filename, Message: msg}
+	if m := yamlLineRe.FindStringSubmatch(msg); m != nil {
+		problem.Line, _ = strconv.Atoi(m[1])
+		problem.Message = strings.Replace(msg, m[0], "", 1)
+	}
+	return problem
+}
+
+//yamlLines locates config entries and fields in the YAML text, the decoder doesn't keep the line numbers
+type yamlLines struct {
+	filename string
+	lines    []string
+	// entries first lines of the top level list entries, 0 based
+	entries []int
+}
+
+func newYAMLLines(filename string, data []byte) *yamlLines {
+	l := &yamlLines{filename: filename, lines: strings.Split(string(data), "\n")}
+
+	indent := -1
+	for i, line := range l.lines {
+		trimmed := strings.TrimLeft(line, " ")
+		if !strings.HasPrefix(trimmed, "- ") && trimmed != "-" {
+			continue
+		}
+		lineIndent := len(line) - len(trimmed)
+		if indent == -1 || lineIndent < indent {
+			indent = lineIndent
+			l.entries = l.entries[:0]
+		}
+		if lineIndent == indent {
+			l.entries = append(l.entries, i)
+		}
+	}
+	return l
+}
+
+//field returns the line of the field in the entry, or the line of the entry when the field isn't set
+func (l *yamlLines) field(entry int, field string) int {
+	if entry >= len(l.entries) {
+		return 0
+	}
+	end := len(l.lines)
+	if entry+1 < len(l.entries) {
+		end = l.entries[entry+1]
+	}
+	fieldRe := regexp.MustCompile(`^\s*(- )?"?` + regexp.QuoteMeta(field) + `"?\s*:`)
+	for i := l.entries[entry]; i < end; i++ {
+		if fieldRe.MatchString(l.lines[i]) {
+			return i + 1
+		}
+	}
+	return l.entries[entry] + 1
+}
+
+//alert returns the line of the nth rule with the alert name, 0 based
+func (l *yamlLines) alert(name string, n int) int {
+	alertRe := regexp.MustCompile(`^\s*(- )?alert\s*:\s*["']?` + regexp.QuoteMeta(name) + `["']?\s*(#.*)?$`)
+	for i, line := range l.lines {
+		if alertRe.MatchString(line) {
+			if n == 0 {
+				return i + 1
+			}
+			n--
+		}
+	}
+	return 0
+}
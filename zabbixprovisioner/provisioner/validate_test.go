@@ -0,0 +1,54 @@
+package provisioner_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/neogan74/zabbix-alertmanager/zabbixprovisioner/provisioner"
+)
+
+func TestValidateConfigFile(t *testing.T) {
+	problems := provisioner.ValidateConfigFile("testdata/validate/config.yaml", "prometheus", true)
+
+	const config = "testdata/validate/config.yaml"
+	const rules = "testdata/validate/rules/rules.yaml"
+	expected := []struct {
+		file string
+		line int
+	}{
+		{config, 15}, // unknown field alertDir
+		{config, 11}, // missing itemDefaultApplication
+		{config, 14}, // invalid history
+		{config, 18}, // unknown default priority
+		{rules, 4},   // invalid for, rules are located by the alert line
+		{rules, 7},   // duplicate item key
+		{rules, 9},   // empty expr
+		{config, 21}, // duplicate name
+		{config, 27}, // host listed by 2 entries
+	}
+
+	found := map[string]bool{}
+	for _, problem := range problems {
+		found[fmt.Sprintf("%s:%d", problem.File, problem.Line)] = true
+	}
+	for _, e := range expected {
+		if !found[fmt.Sprintf("%s:%d", e.file, e.line)] {
+			t.Errorf("Expected a problem at %s:%d, got %v", e.file, e.line, problems)
+		}
+	}
+	if len(problems) != len(expected) {
+		t.Errorf("Expected %d problems, got %d: %v", len(expected), len(problems), problems)
+	}
+}
+
+func TestValidateConfigFileErrors(t *testing.T) {
+	problems := provisioner.ValidateConfigFile("testdata/validate/missing.yaml", "prometheus", true)
+	if len(problems) != 1 {
+		t.Errorf("Expected one problem, got %v", problems)
+	}
+
+	problems = provisioner.ValidateConfigFile("testdata/testsErr/read/rulesErr_test.yml", "prometheus", true)
+	if len(problems) != 1 || problems[0].Line == 0 {
+		t.Errorf("Expected one problem with the line, got %v", problems)
+	}
+}
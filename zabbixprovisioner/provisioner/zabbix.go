@@ -1,8 +1,6 @@
 package provisioner
 
 import (
-	"strings"
-
 	zabbix "github.com/neogan74/zabbix-alertmanager/zabbixprovisioner/zabbixclient"
 	log "github.com/sirupsen/logrus"
 )
@@ -49,6 +47,8 @@ type CustomItem struct {
 	State State
 	zabbix.Item
 	Applications map[string]struct{}
+	// Source of the rule the item was created from
+	Source string
 }
 
 //CustomTemplate ..
@@ -65,7 +65,9 @@ type CustomTemplate struct {
 type CustomHost struct {
 	State State
 	zabbix.Host
-	HostGroups   map[string]struct{}
+	HostGroups map[string]struct{}
+	// Templates names of the provisioned templates linked to the host
+	Templates    map[string]struct{}
 	Applications map[string]*CustomApplication
 	Items        map[string]*CustomItem
 	Triggers     map[string]*CustomTrigger
@@ -165,6 +167,10 @@ func (tmpl *CustomTemplate) Equal(j *CustomTemplate) bool {
 		return false
 	}
 
+	if tmpl.Description != j.Description {
+		return false
+	}
+
 	if len(tmpl.HostGroups) != len(j.HostGroups) {
 		return false
 	}
@@ -191,15 +197,16 @@ func (z *CustomZabbix) GetTemplatesByState() (templateByState map[State]zabbix.T
 	newTemplateAmmount := 0
 	for _, tmpl := range z.Templates {
 		log.Debugf("ZTMPL: %+v", tmpl)
+		tmpl.GroupIds = nil
 		for hostGroupName := range tmpl.HostGroups {
 			tmpl.GroupIds = append(tmpl.GroupIds, zabbix.HostGroupID{GroupID: z.HostGroups[hostGroupName].GroupID})
 		}
 		templateByState[tmpl.State] = append(templateByState[tmpl.State], tmpl.Template)
 		if StateName[tmpl.State] == "New" || StateName[tmpl.State] == "Updated" {
 			newTemplateAmmount++
-			log.Infof("GetTemplatesByState = State: %s, Name: %s", StateName[tmpl.State], tmpl.Template)
+			log.Infof("GetTemplatesByState = State: %s, Name: %s", StateName[tmpl.State], tmpl.Name)
 		} else {
-			log.Debugf("GetTemplatesByState = State: %s, Name: %s", StateName[tmpl.State], tmpl.Template)
+			log.Debugf("GetTemplatesByState = State: %s, Name: %s", StateName[tmpl.State], tmpl.Name)
 		}
 	}
 
@@ -305,15 +312,17 @@ func (z *CustomZabbix) AddHost(host *CustomHost) (updatedHost *CustomHost) {
 		if existing.Equal(host) {
 			if host.State == StateOld {
 				existing.HostID = host.HostID
+				existing.ParentTemplates = host.ParentTemplates
 				existing.State = StateEqual
 				updatedHost = existing
 			}
 		} else {
 			if host.State == StateOld {
 				existing.HostID = host.HostID
+				existing.ParentTemplates = host.ParentTemplates
 			}
 			existing.State = StateUpdated
-			log.Debugf("=+=+=+UPDATED MFC host = State: %s, Expression: %s", existing)
+			log.Debugf("=+=+=+UPDATED MFC host = State: %s, Host: %+v", StateName[existing.State], existing)
 			updatedHost = existing
 		}
 	}
@@ -396,6 +405,10 @@ func (host *CustomHost) Equal(j *CustomHost) bool {
 		return false
 	}
 
+	if host.Status != j.Status {
+		return false
+	}
+
 	if len(host.HostGroups) != len(j.HostGroups) {
 		return false
 	}
@@ -406,6 +419,16 @@ func (host *CustomHost) Equal(j *CustomHost) bool {
 		}
 	}
 
+	if len(host.Templates) != len(j.Templates) {
+		return false
+	}
+
+	for templateName := range host.Templates {
+		if _, ok := j.Templates[templateName]; !ok {
+			return false
+		}
+	}
+
 	if len(host.Inventory) != len(j.Inventory) {
 		return false
 	}
@@ -418,6 +441,64 @@ func (host *CustomHost) Equal(j *CustomHost) bool {
 		}
 	}
 
+	if !equalTags(host.Tags, j.Tags) {
+		return false
+	}
+
+	return equalMainInterfaces(host.Interfaces, j.Interfaces)
+}
+
+//equalTags compares tags regardless of their order
+func equalTags(i, j []zabbix.Tag) bool {
+	if len(i) != len(j) {
+		return false
+	}
+
+	tags := make(map[zabbix.Tag]int, len(i))
+	for _, tag := range i {
+		tags[tag]++
+	}
+	for _, tag := range j {
+		if tags[tag] == 0 {
+			return false
+		}
+		tags[tag]--
+	}
+	return true
+}
+
+//equalMainInterfaces compares the addresses of the main interfaces by type
+func equalMainInterfaces(i, j zabbix.HostInterfaces) bool {
+	main := func(interfaces zabbix.HostInterfaces) map[zabbix.InterfaceType]zabbix.HostInterface {
+		res := make(map[zabbix.InterfaceType]zabbix.HostInterface, len(interfaces))
+		for _, iface := range interfaces {
+			if iface.Main == 1 {
+				res[iface.Type] = iface
+			}
+		}
+		return res
+	}
+
+	mainI, mainJ := main(i), main(j)
+	if len(mainI) != len(mainJ) {
+		return false
+	}
+
+	for interfaceType, ifaceI := range mainI {
+		ifaceJ, ok := mainJ[interfaceType]
+		if !ok {
+			return false
+		}
+		if ifaceI.UseIP != ifaceJ.UseIP || ifaceI.Port != ifaceJ.Port {
+			return false
+		}
+		if ifaceI.UseIP == 1 && ifaceI.IP != ifaceJ.IP {
+			return false
+		}
+		if ifaceI.UseIP == 0 && ifaceI.DNS != ifaceJ.DNS {
+			return false
+		}
+	}
 	return true
 }
 
@@ -443,6 +524,10 @@ func (i *CustomItem) Equal(j *CustomItem) bool {
 		return false
 	}
 
+	if i.Status != j.Status {
+		return false
+	}
+
 	if len(i.Applications) != len(j.Applications) {
 		return false
 	}
@@ -482,6 +567,14 @@ func (i *CustomTrigger) Equal(j *CustomTrigger) bool {
 		return false
 	}
 
+	if !equalTags(i.Tags, j.Tags) {
+		return false
+	}
+
+	if i.Status != j.Status {
+		return false
+	}
+
 	return true
 }
 
@@ -497,9 +590,11 @@ func (z *CustomZabbix) GetHostsByState() (hostByState map[State]zabbix.Hosts) {
 
 	newHostAmmount := 0
 	for _, host := range z.Hosts {
+		host.GroupIds = nil
 		for hostGroupName := range host.HostGroups {
 			host.GroupIds = append(host.GroupIds, zabbix.HostGroupID{GroupID: z.HostGroups[hostGroupName].GroupID})
 		}
+		host.TemplateIDs, host.TemplatesClear = z.hostTemplateIDs(host)
 		hostByState[host.State] = append(hostByState[host.State], host.Host)
 		if StateName[host.State] == "New" || StateName[host.State] == "Updated" {
 			newHostAmmount++
@@ -513,6 +608,25 @@ func (z *CustomZabbix) GetHostsByState() (hostByState map[State]zabbix.Hosts) {
 	return hostByState
 }
 
+//hostTemplateIDs returns the templates to link to the host and the provisioned templates to unlink from it.
+// Templates linked to the host which aren't provisioned stay linked.
+func (z *CustomZabbix) hostTemplateIDs(host *CustomHost) (link zabbix.TemplateIDs, clear zabbix.TemplateIDs) {
+	for templateName := range host.Templates {
+		if tmpl, ok := z.Templates[templateName]; ok && tmpl.TemplateID != "" {
+			link = append(link, zabbix.TemplateID{TemplateID: tmpl.TemplateID})
+		}
+	}
+
+	for _, parent := range host.ParentTemplates {
+		if _, ok := z.Templates[parent.Name]; !ok {
+			link = append(link, zabbix.TemplateID{TemplateID: parent.TemplateID})
+		} else if _, ok := host.Templates[parent.Name]; !ok {
+			clear = append(clear, zabbix.TemplateID{TemplateID: parent.TemplateID})
+		}
+	}
+	return link, clear
+}
+
 //GetHostGroupsByState ...
 func (z *CustomZabbix) GetHostGroupsByState() (hostGroupsByState map[State]zabbix.HostGroups) {
 
@@ -656,19 +770,6 @@ func (host *CustomHost) GetApplicationsByState() (applicationsByState map[State]
 
 //GetZabbixPriority ...
 func GetZabbixPriority(severity string) zabbix.PriorityType {
-
-	switch strings.ToLower(severity) {
-	case "information":
-		return zabbix.Information
-	case "warning":
-		return zabbix.Warning
-	case "average":
-		return zabbix.Average
-	case "high":
-		return zabbix.High
-	case "critical":
-		return zabbix.Critical
-	default:
-		return zabbix.NotClassified
-	}
+	priority, _ := ParseZabbixPriority(severity)
+	return priority
 }
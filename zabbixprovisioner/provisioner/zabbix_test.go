@@ -0,0 +1,544 @@
+package provisioner_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/neogan74/zabbix-alertmanager/zabbixprovisioner/provisioner"
+)
+
+//fakeZabbix JSON-RPC server with managed templates, each with the given number of items and triggers, and hosts.
+// It counts the calls by method and records the params and the returned ids of the calls changing objects.
+// The first calls of the methods in failures fail. With unmanaged set the objects have no managed markers,
+// edit changes the objects returned by the get methods.
+type fakeZabbix struct {
+	templates int
+	items     int
+	hosts     int
+	failures  map[string]int
+	unmanaged bool
+	edit      func(method string, objects []map[string]interface{}) []map[string]interface{}
+
+	mu      sync.Mutex
+	calls   map[string]int
+	changes map[string][]json.RawMessage
+	ids     map[string][][]string
+	lastID  int
+}
+
+func newFakeZabbix(templates, items, hosts int) *fakeZabbix {
+	return &fakeZabbix{templates: templates, items: items, hosts: hosts,
+		calls: map[string]int{}, changes: map[string][]json.RawMessage{}, ids: map[string][][]string{}}
+}
+
+func (z *fakeZabbix) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Method string          `json:"method"`
+		Params json.RawMessage `json:"params"`
+		ID     int             `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	z.mu.Lock()
+	z.calls[req.Method]++
+	fail := z.failures[req.Method] > 0
+	if fail {
+		z.failures[req.Method]--
+	}
+	z.mu.Unlock()
+
+	if fail {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"jsonrpc": "2.0",
+			"error":   map[string]interface{}{"code": -32500, "message": "Application error.", "data": "injected failure"},
+			"id":      req.ID,
+		})
+		return
+	}
+
+	var result interface{}
+	if parts := strings.SplitN(req.Method, ".", 2); len(parts) == 2 && parts[1] != "get" && parts[1] != "login" && parts[1] != "version" {
+		ids, idsKey := z.change(parts[0], req.Params)
+		result = map[string]interface{}{idsKey: ids}
+		z.mu.Lock()
+		z.changes[req.Method] = append(z.changes[req.Method], req.Params)
+		z.ids[req.Method] = append(z.ids[req.Method], ids)
+		z.mu.Unlock()
+	} else {
+		result = z.result(req.Method)
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "result": result, "id": req.ID})
+}
+
+//change returns the ids of the created, updated or deleted objects and the result field holding them
+func (z *fakeZabbix) change(objectType string, params json.RawMessage) ([]string, string) {
+	var objects []interface{}
+	if err := json.Unmarshal(params, &objects); err != nil {
+		objects = []interface{}{params}
+	}
+
+	idsKey := map[string]string{"hostgroup": "groupids"}[objectType]
+	if idsKey == "" {
+		idsKey = objectType + "ids"
+	}
+
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	ids := make([]string, len(objects))
+	for i := range objects {
+		z.lastID++
+		ids[i] = fmt.Sprintf("%d", 90000+z.lastID)
+	}
+	return ids, idsKey
+}
+
+//changed returns the params of the calls of the method
+func (z *fakeZabbix) changed(method string) []json.RawMessage {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	return z.changes[method]
+}
+
+//changedIDs returns the ids returned by the calls of the method
+func (z *fakeZabbix) changedIDs(method string) [][]string {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	return z.ids[method]
+}
+
+func (z *fakeZabbix) callCount() (total int, byMethod map[string]int) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	byMethod = make(map[string]int, len(z.calls))
+	for method, n := range z.calls {
+		byMethod[method] = n
+		total += n
+	}
+	return total, byMethod
+}
+
+func (z *fakeZabbix) resetCalls() {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	z.calls = map[string]int{}
+}
+
+func (z *fakeZabbix) result(method string) interface{} {
+	managedTags := []map[string]string{{"tag": provisioner.ManagedTagName, "value": provisioner.ManagedTagValue}}
+	marker := provisioner.ManagedMarker
+	if z.unmanaged {
+		managedTags, marker = []map[string]string{}, ""
+	}
+	var res []map[string]interface{}
+
+	switch method {
+	case "APIInfo.version":
+		return "4.4.0"
+	case "user.login":
+		return "token"
+	case "hostgroup.get":
+		res = append(res,
+			map[string]interface{}{"groupid": "1", "name": "Prometheus"},
+			map[string]interface{}{"groupid": "2", "name": "Templates"},
+		)
+	case "template.get":
+		for t := 0; t < z.templates; t++ {
+			res = append(res, map[string]interface{}{
+				"templateid":  templateID(t),
+				"host":        templateName(t),
+				"name":        templateName(t),
+				"description": marker,
+				"groups":      []map[string]string{{"groupid": "2", "name": "Templates"}},
+			})
+		}
+	case "application.get":
+		for t := 0; t < z.templates; t++ {
+			res = append(res, map[string]interface{}{"applicationid": templateID(t), "hostid": templateID(t), "name": "prometheus"})
+		}
+	case "item.get":
+		for t := 0; t < z.templates; t++ {
+			for i := 0; i < z.items; i++ {
+				res = append(res, map[string]interface{}{
+					"itemid":       fmt.Sprintf("%d%06d", t+1, i),
+					"hostid":       templateID(t),
+					"key_":         fmt.Sprintf("prometheus.alert%d", i),
+					"name":         fmt.Sprintf("Alert%d", i),
+					"type":         "2",
+					"value_type":   "3",
+					"description":  marker,
+					"applications": []map[string]string{{"applicationid": templateID(t), "name": "prometheus"}},
+				})
+			}
+		}
+	case "trigger.get":
+		for t := 0; t < z.templates; t++ {
+			for i := 0; i < z.items; i++ {
+				res = append(res, map[string]interface{}{
+					"triggerid":   fmt.Sprintf("%d%06d", t+1, i),
+					"description": fmt.Sprintf("Alert%d", i),
+					"expression":  fmt.Sprintf("{%s:prometheus.alert%d.last()}>0", templateName(t), i),
+					"priority":    "3",
+					"tags":        managedTags,
+					"hosts":       []map[string]string{{"hostid": templateID(t)}},
+				})
+			}
+		}
+	case "host.get":
+		for h := 0; h < z.hosts; h++ {
+			res = append(res, map[string]interface{}{
+				"hostid":          fmt.Sprintf("%d", 20000+h),
+				"host":            fmt.Sprintf("host%d", h),
+				"name":            fmt.Sprintf("host%d", h),
+				"tags":            managedTags,
+				"groups":          []map[string]string{{"groupid": "1", "name": "Prometheus"}},
+				"parentTemplates": []map[string]string{{"templateid": templateID(0), "host": templateName(0)}},
+				"inventory":       []string{},
+				"interfaces":      []map[string]string{{"ip": "127.0.0.1", "main": "1", "port": "10050", "type": "1", "useip": "1"}},
+			})
+		}
+	}
+
+	if z.edit != nil {
+		res = z.edit(method, res)
+	}
+	if res == nil {
+		return []interface{}{}
+	}
+	return res
+}
+
+func templateID(t int) string {
+	return fmt.Sprintf("%d", 10000+t)
+}
+
+func templateName(t int) string {
+	return fmt.Sprintf("template%d", t)
+}
+
+func newFakeProvisioner(tb testing.TB, z *fakeZabbix, options provisioner.Options) (*provisioner.Provisioner, *httptest.Server) {
+	ts := httptest.NewServer(z)
+
+	var hosts []provisioner.HostConfig
+	for t := 0; t < z.templates; t++ {
+		hosts = append(hosts, provisioner.HostConfig{
+			Name:               templateName(t),
+			HostGroups:         []string{"Prometheus"},
+			TemplateHostGroups: []string{"Templates"},
+		})
+	}
+
+	p, err := provisioner.New("", "prometheus", ts.URL, "user", "password", hosts, options)
+	if err != nil {
+		ts.Close()
+		tb.Fatalf("Unexpected error: %v", err)
+	}
+	return p, ts
+}
+
+func resetZabbixState(p *provisioner.Provisioner) {
+	p.CustomZabbix = &provisioner.CustomZabbix{
+		Hosts:      map[string]*provisioner.CustomHost{},
+		Templates:  map[string]*provisioner.CustomTemplate{},
+		HostGroups: map[string]*provisioner.CustomHostGroup{},
+	}
+}
+
+func TestLoadDataFromZabbixBatched(t *testing.T) {
+	for _, concurrency := range []int{1, 4} {
+		z := newFakeZabbix(3, 50, 20)
+		p, ts := newFakeProvisioner(t, z, provisioner.Options{Concurrency: concurrency})
+		defer ts.Close()
+
+		resetZabbixState(p)
+		z.resetCalls()
+		if err := p.LoadDataFromZabbix(); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		total, byMethod := z.callCount()
+		for method, n := range byMethod {
+			if n != 1 {
+				t.Errorf("Expected one %s call, got %d", method, n)
+			}
+		}
+		if total != 6 {
+			t.Errorf("Expected 6 calls, got %d: %v", total, byMethod)
+		}
+
+		if len(p.Templates) != 3 || len(p.Hosts) != 20 {
+			t.Fatalf("Expected 3 templates and 20 hosts, got %d and %d", len(p.Templates), len(p.Hosts))
+		}
+		template := p.Templates[templateName(1)]
+		if len(template.Items) != 50 || len(template.Triggers) != 50 || len(template.Applications) != 1 {
+			t.Errorf("Expected 50 items, 50 triggers and 1 application, got %d, %d and %d",
+				len(template.Items), len(template.Triggers), len(template.Applications))
+		}
+		if _, ok := template.HostGroups["Templates"]; !ok {
+			t.Errorf("Expected template host groups from selectGroups, got %v", template.HostGroups)
+		}
+		for _, item := range template.Items {
+			if _, ok := item.Applications["prometheus"]; !ok {
+				t.Errorf("Expected item applications from selectApplications, got %v", item.Applications)
+				break
+			}
+		}
+		if _, ok := p.Hosts["host1"].HostGroups["Prometheus"]; !ok {
+			t.Errorf("Expected host groups from selectGroups, got %v", p.Hosts["host1"].HostGroups)
+		}
+	}
+}
+
+func TestLoadDataFromZabbixKeepsUnmanagedApplications(t *testing.T) {
+	z := newFakeZabbix(1, 2, 0)
+	z.edit = func(method string, objects []map[string]interface{}) []map[string]interface{} {
+		if method == "application.get" {
+			objects = append(objects, map[string]interface{}{"applicationid": "30000", "hostid": templateID(0), "name": "manual"})
+		}
+		return objects
+	}
+	p, ts := newFakeProvisioner(t, z, provisioner.Options{})
+	defer ts.Close()
+
+	resetZabbixState(p)
+	if err := p.LoadDataFromZabbix(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Only the application holding the managed items is deleted with them
+	applications := p.Templates[templateName(0)].Applications
+	if state := applications["prometheus"].State; state != provisioner.StateOld {
+		t.Errorf("Expected the application of the managed items to be deleted, got state %v", state)
+	}
+	if state := applications["manual"].State; state != provisioner.StateEqual {
+		t.Errorf("Expected the application without managed items to stay, got state %v", state)
+	}
+}
+
+func BenchmarkLoadDataFromZabbix(b *testing.B) {
+	for _, concurrency := range []int{1, 4} {
+		b.Run(fmt.Sprintf("concurrency=%d", concurrency), func(b *testing.B) {
+			z := newFakeZabbix(5, 1000, 100)
+			p, ts := newFakeProvisioner(b, z, provisioner.Options{Concurrency: concurrency})
+			defer ts.Close()
+			z.resetCalls()
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				resetZabbixState(p)
+				if err := p.LoadDataFromZabbix(); err != nil {
+					b.Fatalf("Unexpected error: %v", err)
+				}
+			}
+			b.StopTimer()
+
+			total, _ := z.callCount()
+			b.ReportMetric(float64(total)/float64(b.N), "calls/op")
+		})
+	}
+}
+
+func TestRunMultipleConfigs(t *testing.T) {
+	z := newFakeZabbix(0, 0, 0)
+	ts := httptest.NewServer(z)
+	defer ts.Close()
+
+	prom := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, targetsAPIResponse)
+	}))
+	defer prom.Close()
+
+	var hosts []provisioner.HostConfig
+	for _, name := range []string{"node", "blackbox"} {
+		hosts = append(hosts, provisioner.HostConfig{
+			Name:               name,
+			HostGroups:         []string{"Prometheus"},
+			TemplateHostGroups: []string{"Templates"},
+			HostAlertsDir:      rulesOKpath,
+			PrometheusUrl:      prom.URL,
+			TargetSelector:     map[string]string{"instance": "node1.example.com:9100"},
+		})
+	}
+
+	p, err := provisioner.New("", "prometheus", ts.URL, "user", "password", hosts, provisioner.Options{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := p.Run(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	_, byMethod := z.callCount()
+	for _, method := range []string{"template.get", "host.get", "template.create", "host.create"} {
+		if byMethod[method] != 1 {
+			t.Errorf("Expected one %s call, got %d", method, byMethod[method])
+		}
+	}
+	if byMethod["template.update"] != 0 {
+		t.Errorf("Expected no template.update calls, got %d", byMethod["template.update"])
+	}
+
+	var created []struct {
+		Host      string `json:"host"`
+		Templates []struct {
+			TemplateID string `json:"templateid"`
+		} `json:"templates"`
+	}
+	if err := json.Unmarshal(z.changed("host.create")[0], &created); err != nil {
+		t.Fatal(err)
+	}
+	if len(created) != 1 || created[0].Host != "node1.example.com" {
+		t.Fatalf("Expected host node1.example.com to be created once, got %+v", created)
+	}
+	if len(created[0].Templates) != 2 || created[0].Templates[0].TemplateID == created[0].Templates[1].TemplateID {
+		t.Errorf("Expected the host linked to both templates, got %+v", created[0].Templates)
+	}
+}
+
+func TestApplyChangesRollback(t *testing.T) {
+	for _, policy := range []provisioner.FailurePolicy{provisioner.FailureStop, provisioner.FailureContinue} {
+		z := newFakeZabbix(2, 3, 0)
+		z.failures = map[string]int{"trigger.create": 1}
+		ts := httptest.NewServer(z)
+		defer ts.Close()
+
+		var hosts []provisioner.HostConfig
+		for t := 0; t < z.templates; t++ {
+			hosts = append(hosts, provisioner.HostConfig{
+				Name:               templateName(t),
+				TemplateHostGroups: []string{"Templates"},
+				HostAlertsDir:      rulesOKpath,
+			})
+		}
+		p, err := provisioner.New("", "prometheus", ts.URL, "user", "password", hosts, provisioner.Options{OnFailure: policy})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		resetZabbixState(p)
+		for _, host := range hosts {
+			if err := p.LoadRulesFromPrometheus(host); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+		}
+		if err := p.LoadDataFromZabbix(); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		err = p.ApplyChanges()
+		applyErr, ok := err.(*provisioner.ApplyError)
+		if !ok {
+			t.Fatalf("Expected an ApplyError, got %v", err)
+		}
+		if len(applyErr.Failures) != 1 {
+			t.Errorf("Expected one failure, got %v", applyErr.Failures)
+		}
+		if len(applyErr.Inconsistent) != 0 {
+			t.Errorf("Expected the rollback to revert everything, got %v", applyErr.Inconsistent)
+		}
+
+		// The failed template deletes its created items and recreates the deleted items and triggers,
+		// the other template is applied only when the run continues
+		templatesApplied := 1
+		if policy == provisioner.FailureContinue {
+			templatesApplied = 2
+		}
+		_, byMethod := z.callCount()
+		expected := map[string]int{
+			"item.delete":    templatesApplied + 1,
+			"item.create":    templatesApplied + 1,
+			"trigger.delete": templatesApplied,
+			"trigger.create": templatesApplied + 1,
+		}
+		for method, n := range expected {
+			if byMethod[method] != n {
+				t.Errorf("%s: expected %d %s calls, got %d", policy, n, method, byMethod[method])
+			}
+		}
+
+		var recreated []struct {
+			ItemID       string   `json:"itemid"`
+			Key          string   `json:"key_"`
+			Applications []string `json:"applications"`
+		}
+		if err := json.Unmarshal(z.changed("item.create")[1], &recreated); err != nil {
+			t.Fatal(err)
+		}
+		if len(recreated) != z.items || recreated[0].ItemID != "" || !strings.HasPrefix(recreated[0].Key, "prometheus.alert") {
+			t.Errorf("Expected the deleted items to be recreated without ids, got %+v", recreated)
+		}
+
+		// The deleted application prometheus is recreated before its items, which are linked to the new id
+		var recreatedApplications []struct {
+			Name string `json:"name"`
+		}
+		createdApplications := z.changed("application.create")
+		if err := json.Unmarshal(createdApplications[1], &recreatedApplications); err != nil {
+			t.Fatal(err)
+		}
+		if len(recreatedApplications) != 1 || recreatedApplications[0].Name != "prometheus" {
+			t.Fatalf("Expected the application prometheus to be recreated, got %+v", recreatedApplications)
+		}
+		applicationID := z.changedIDs("application.create")[1][0]
+		for _, item := range recreated {
+			if len(item.Applications) != 1 || item.Applications[0] != applicationID {
+				t.Errorf("Expected item %s to be linked to the recreated application %s, got %v", item.Key, applicationID, item.Applications)
+			}
+		}
+	}
+}
+
+func TestRunLinksListedHosts(t *testing.T) {
+	z := newFakeZabbix(0, 0, 0)
+	z.edit = func(method string, objects []map[string]interface{}) []map[string]interface{} {
+		if method != "host.get" {
+			return objects
+		}
+		return append(objects, map[string]interface{}{
+			"hostid": "30000", "host": "prometheus", "name": "prometheus", "tags": []string{},
+			"parentTemplates": []map[string]string{{"templateid": "10500", "host": "Template OS Linux"}},
+		})
+	}
+	ts := httptest.NewServer(z)
+	defer ts.Close()
+
+	hosts := []provisioner.HostConfig{{
+		Name:               "node",
+		HostGroups:         []string{"Prometheus"},
+		TemplateHostGroups: []string{"Templates"},
+		HostAlertsDir:      rulesOKpath,
+		Hosts:              []string{"prometheus", "missing"},
+	}}
+	p, err := provisioner.New("", "prometheus", ts.URL, "user", "password", hosts, provisioner.Options{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := p.Run(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	links := z.changed("template.massadd")
+	if len(links) != 1 {
+		t.Fatalf("Expected one template.massadd call, got %d", len(links))
+	}
+	var link struct {
+		Templates []map[string]string `json:"templates"`
+		Hosts     []map[string]string `json:"hosts"`
+	}
+	if err := json.Unmarshal(links[0], &link); err != nil {
+		t.Fatal(err)
+	}
+	templateID := z.changedIDs("template.create")[0][0]
+	if len(link.Templates) != 1 || link.Templates[0]["templateid"] != templateID ||
+		len(link.Hosts) != 1 || link.Hosts[0]["hostid"] != "30000" {
+		t.Errorf("Expected template %s linked to host prometheus only, got %+v", templateID, link)
+	}
+}
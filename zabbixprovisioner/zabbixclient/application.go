@@ -1,7 +1,7 @@
 package zabbixclient
 
 import (
-	reflector "github.com/neogan74/zabbix-alertmanager/zabbixprovisioner/zabbixutil"
+	"context"
 )
 
 //Application struct https://www.zabbix.com/documentation/4.4/manual/appendix/api/application/definitions
@@ -16,23 +16,35 @@ type Applications []Application
 
 //ApplicationsGet ...Wrapper for application.get: https://www.zabbix.com/documentation/4.4/manual/appendix/api/application/get
 func (api *API) ApplicationsGet(params Params) (Applications, error) {
+	return api.ApplicationsGetContext(context.Background(), params)
+}
+
+//ApplicationsGetContext is ApplicationsGet with a context.
+func (api *API) ApplicationsGetContext(ctx context.Context, params Params) (Applications, error) {
 	var res Applications
 	if _, present := params["output"]; !present {
 		params["output"] = "extend"
 	}
-	response, err := api.CallWithError("application.get", params)
+	response, err := api.CallWithErrorContext(ctx, "application.get", params)
 	if err != nil {
 		return nil, err
 	}
 
-	reflector.MapsToStructs2(response.Result.([]interface{}), &res, reflector.Strconv, "json")
+	if err := response.Decode(&res); err != nil {
+		return nil, err
+	}
 	return res, nil
 }
 
 //ApplicationGetByID Gets application by Id only if there is exactly 1 matching application.
 func (api *API) ApplicationGetByID(id string) (*Application, error) {
+	return api.ApplicationGetByIDContext(context.Background(), id)
+}
+
+//ApplicationGetByIDContext is ApplicationGetByID with a context.
+func (api *API) ApplicationGetByIDContext(ctx context.Context, id string) (*Application, error) {
 	var res *Application
-	apps, err := api.ApplicationsGet(Params{"applicationids": id})
+	apps, err := api.ApplicationsGetContext(ctx, Params{"applicationids": id})
 	if err != nil {
 		return nil, err
 	}
@@ -49,8 +61,13 @@ func (api *API) ApplicationGetByID(id string) (*Application, error) {
 
 //ApplicationGetByHostIDAndName Gets application by host Id and name only if there is exactly 1 matching application.
 func (api *API) ApplicationGetByHostIDAndName(hostID, name string) (*Application, error) {
+	return api.ApplicationGetByHostIDAndNameContext(context.Background(), hostID, name)
+}
+
+//ApplicationGetByHostIDAndNameContext is ApplicationGetByHostIDAndName with a context.
+func (api *API) ApplicationGetByHostIDAndNameContext(ctx context.Context, hostID, name string) (*Application, error) {
 	var res *Application
-	apps, err := api.ApplicationsGet(Params{"hostids": hostID, "filter": map[string]string{"name": name}})
+	apps, err := api.ApplicationsGetContext(ctx, Params{"hostids": hostID, "filter": map[string]string{"name": name}})
 	if err != nil {
 		return nil, err
 	}
@@ -67,15 +84,19 @@ func (api *API) ApplicationGetByHostIDAndName(hostID, name string) (*Application
 
 //ApplicationsCreate Wrapper for application.create: https://www.zabbix.com/documentation/2.2/manual/appendix/api/application/create
 func (api *API) ApplicationsCreate(apps Applications) error {
-	response, err := api.CallWithError("application.create", apps)
+	return api.ApplicationsCreateContext(context.Background(), apps)
+}
+
+//ApplicationsCreateContext is ApplicationsCreate with a context.
+func (api *API) ApplicationsCreateContext(ctx context.Context, apps Applications) error {
+	response, err := api.CallWithErrorContext(ctx, "application.create", apps)
 	if err != nil {
 		return err
 	}
 
-	result := response.Result.(map[string]interface{})
-	applicationids := result["applicationids"].([]interface{})
+	applicationids := resultIDs([]Response{response}, "applicationids")
 	for i, id := range applicationids {
-		apps[i].ApplicationID = id.(string)
+		apps[i].ApplicationID = id
 	}
 	return nil
 }
@@ -83,12 +104,17 @@ func (api *API) ApplicationsCreate(apps Applications) error {
 //ApplicationsDelete Wrapper for application.delete: https://www.zabbix.com/documentation/2.2/manual/appendix/api/application/delete
 // Cleans ApplicationID in all apps elements if call succeed.
 func (api *API) ApplicationsDelete(apps Applications) error {
+	return api.ApplicationsDeleteContext(context.Background(), apps)
+}
+
+//ApplicationsDeleteContext is ApplicationsDelete with a context.
+func (api *API) ApplicationsDeleteContext(ctx context.Context, apps Applications) error {
 	ids := make([]string, len(apps))
 	for i, app := range apps {
 		ids[i] = app.ApplicationID
 	}
 
-	err := api.ApplicationsDeleteByIDs(ids)
+	err := api.ApplicationsDeleteByIDsContext(ctx, ids)
 	if err != nil {
 		return err
 	}
@@ -102,13 +128,19 @@ func (api *API) ApplicationsDelete(apps Applications) error {
 
 //ApplicationsDeleteByIDs Wrapper for application.delete: https://www.zabbix.com/documentation/2.2/manual/appendix/api/application/delete
 func (api *API) ApplicationsDeleteByIDs(ids []string) error {
-	response, err := api.CallWithError("application.delete", ids)
+	return api.ApplicationsDeleteByIDsContext(context.Background(), ids)
+}
+
+//ApplicationsDeleteByIDsContext is ApplicationsDeleteByIDs with a context.
+func (api *API) ApplicationsDeleteByIDsContext(ctx context.Context, ids []string) error {
+	responses, err := api.callChunks(ctx, "application.delete", len(ids), func(from, to int) interface{} {
+		return ids[from:to]
+	})
 	if err != nil {
 		return err
 	}
 
-	result := response.Result.(map[string]interface{})
-	applicationids := result["applicationids"].([]interface{})
+	applicationids := resultIDs(responses, "applicationids")
 	if len(ids) != len(applicationids) {
 		err = &ExpectedMore{len(ids), len(applicationids)}
 		return err
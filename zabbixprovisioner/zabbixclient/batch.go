@@ -0,0 +1,211 @@
+package zabbixclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+)
+
+//DefaultBatchSize is the number of objects sent in one request of a batch, used by NewAPI
+const DefaultBatchSize = 100
+
+//BatchCall is one request of a JSON-RPC batch
+type BatchCall struct {
+	Method string
+	Params interface{}
+}
+
+//SetBatchSize sets the number of objects per request when the create and delete wrappers split them
+// into a JSON-RPC batch, 0 sends all the objects in one request.
+func (api *API) SetBatchSize(size int) {
+	api.batchSize = size
+}
+
+//BatchSupported reports whether the server wasn't found to reject batches.
+func (api *API) BatchSupported() bool {
+	return atomic.LoadInt32(&api.noBatch) == 0
+}
+
+//CallBatch Calls the methods in one JSON-RPC 2.0 batch and returns their responses in the order of the calls.
+// err is something network or marshaling related. Caller should inspect the Error of every response to get API errors.
+// When the server rejects batches the calls are made one by one, for this and all the next batches.
+func (api *API) CallBatch(calls []BatchCall) ([]Response, error) {
+	return api.CallBatchContext(context.Background(), calls)
+}
+
+//CallBatchContext is CallBatch with a context.
+func (api *API) CallBatchContext(ctx context.Context, calls []BatchCall) ([]Response, error) {
+	if len(calls) == 0 {
+		return nil, nil
+	}
+	if len(calls) == 1 || !api.BatchSupported() {
+		return api.callSequentially(ctx, calls)
+	}
+
+	auth, bearer := api.session()
+	responses, err := api.callBatch(ctx, calls, auth, bearer)
+	if _, ok := err.(*batchRejected); ok {
+		api.printf("Batch rejected, calling the methods one by one: %s", err)
+		atomic.StoreInt32(&api.noBatch, 1)
+		return api.callSequentially(ctx, calls)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// The session expires for all the calls, the batch is repeated once logged in again
+	if responses[0].Error != nil && IsSessionExpired(responses[0].Error) && api.canRelogin() {
+		api.printf("Session expired, logging in again")
+		if err := api.relogin(ctx, auth); err != nil {
+			return responses, err
+		}
+		auth, bearer = api.session()
+		responses, err = api.callBatch(ctx, calls, auth, bearer)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for i, response := range responses {
+		if response.Error != nil {
+			requestErrors.WithLabelValues(calls[i].Method, errorApplication).Inc()
+		}
+	}
+	return responses, nil
+}
+
+//batchRejected is returned by callBatch when the server doesn't accept batches
+type batchRejected struct {
+	reason string
+}
+
+func (e *batchRejected) Error() string {
+	return e.reason
+}
+
+func (api *API) callBatch(ctx context.Context, calls []BatchCall, auth string, bearer bool) ([]Response, error) {
+	requests := make([]request, len(calls))
+	ids := make(map[int32]int, len(calls))
+	for i, call := range calls {
+		id := atomic.AddInt32(&api.id, 1)
+		requests[i] = request{"2.0", call.Method, call.Params, auth, id}
+		if bearer {
+			requests[i].Auth = ""
+		}
+		ids[id] = i
+	}
+	b, err := json.Marshal(requests)
+	if err != nil {
+		return nil, err
+	}
+	api.printf("Request (POST): %s", b)
+
+	reads := true
+	for _, call := range calls {
+		reads = reads && idempotent(call.Method)
+	}
+	b, err = api.send(ctx, batchMethod(calls), b, auth, bearer, reads)
+	if err != nil {
+		if e, ok := err.(*HTTPError); ok && !e.Temporary() {
+			return nil, &batchRejected{e.Error()}
+		}
+		return nil, err
+	}
+
+	var batch []Response
+	if err := json.Unmarshal(b, &batch); err != nil {
+		// Servers without batches answer with one error
+		var response Response
+		if json.Unmarshal(b, &response) == nil && response.Error != nil {
+			return nil, &batchRejected{response.Error.Error()}
+		}
+		return nil, err
+	}
+
+	responses := make([]Response, len(calls))
+	found := make([]bool, len(calls))
+	for _, response := range batch {
+		i, ok := ids[response.ID]
+		if !ok {
+			continue
+		}
+		responses[i] = response
+		found[i] = true
+	}
+	for i := range found {
+		if !found[i] {
+			return nil, fmt.Errorf("no response to %s in the batch", calls[i].Method)
+		}
+	}
+	return responses, nil
+}
+
+func (api *API) callSequentially(ctx context.Context, calls []BatchCall) ([]Response, error) {
+	responses := make([]Response, len(calls))
+	for i, call := range calls {
+		response, err := api.CallWithErrorContext(ctx, call.Method, call.Params)
+		if _, ok := err.(*Error); err != nil && !ok {
+			return nil, err
+		}
+		responses[i] = response
+	}
+	return responses, nil
+}
+
+//batchMethod is the method label of the metrics of the batch
+func batchMethod(calls []BatchCall) string {
+	for _, call := range calls[1:] {
+		if call.Method != calls[0].Method {
+			return "batch"
+		}
+	}
+	return calls[0].Method
+}
+
+//callChunks calls the method with the n objects split by chunk in requests of the batch size.
+// err is the first error of the responses.
+func (api *API) callChunks(ctx context.Context, method string, n int, chunk func(from, to int) interface{}) ([]Response, error) {
+	size := api.batchSize
+	if size <= 0 || size > n {
+		size = n
+	}
+	var calls []BatchCall
+	for from := 0; from < n || from == 0; from += size {
+		to := from + size
+		if to > n {
+			to = n
+		}
+		calls = append(calls, BatchCall{Method: method, Params: chunk(from, to)})
+		if size == 0 {
+			break
+		}
+	}
+
+	responses, err := api.CallBatchContext(ctx, calls)
+	if err != nil {
+		return nil, err
+	}
+	for _, response := range responses {
+		if response.Error != nil {
+			return responses, response.Error
+		}
+	}
+	return responses, nil
+}
+
+//resultIDs returns the ids of the successful responses, some versions return a map instead of a list
+func resultIDs(responses []Response, key string) []string {
+	var ids []string
+	for _, response := range responses {
+		var result map[string]json.RawMessage
+		if response.Error != nil || response.Decode(&result) != nil {
+			continue
+		}
+		var objectIDs objectIDs
+		if json.Unmarshal(result[key], &objectIDs) == nil {
+			ids = append(ids, objectIDs...)
+		}
+	}
+	return ids
+}
@@ -0,0 +1,177 @@
+package zabbixclient_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	zabbix "github.com/neogan74/zabbix-alertmanager/zabbixprovisioner/zabbixclient"
+)
+
+type rpcRequest struct {
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+	ID     int               `json:"id"`
+}
+
+//fakeBatches Zabbix API creating and deleting items, optionally without batches or failing the nth item.create
+type fakeBatches struct {
+	noBatch  bool
+	failCall int
+
+	mu      sync.Mutex
+	posts   int
+	creates int
+	lastID  int
+	deleted []string
+}
+
+func (f *fakeBatches) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var body json.RawMessage
+	json.NewDecoder(r.Body).Decode(&body)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.posts++
+
+	var requests []rpcRequest
+	if err := json.Unmarshal(body, &requests); err != nil {
+		var req rpcRequest
+		json.Unmarshal(body, &req)
+		json.NewEncoder(w).Encode(f.response(req))
+		return
+	}
+	if f.noBatch {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"jsonrpc": "2.0",
+			"error":   map[string]interface{}{"code": -32600, "message": "Invalid Request.", "data": "Batch requests are not supported."},
+			"id":      nil,
+		})
+		return
+	}
+	// the responses don't have to be in the order of the requests
+	responses := make([]interface{}, len(requests))
+	for i := range requests {
+		responses[len(requests)-1-i] = f.response(requests[i])
+	}
+	json.NewEncoder(w).Encode(responses)
+}
+
+func (f *fakeBatches) response(req rpcRequest) map[string]interface{} {
+	switch req.Method {
+	case "item.create":
+		f.creates++
+		if f.creates == f.failCall {
+			return map[string]interface{}{
+				"jsonrpc": "2.0",
+				"error":   map[string]interface{}{"code": -32602, "message": "Invalid params.", "data": "Item already exists."},
+				"id":      req.ID,
+			}
+		}
+		ids := make([]string, len(req.Params))
+		for i := range req.Params {
+			f.lastID++
+			ids[i] = fmt.Sprint(f.lastID)
+		}
+		return map[string]interface{}{"jsonrpc": "2.0", "result": map[string]interface{}{"itemids": ids}, "id": req.ID}
+	case "item.delete":
+		ids := make([]string, len(req.Params))
+		for i, param := range req.Params {
+			json.Unmarshal(param, &ids[i])
+		}
+		f.deleted = append(f.deleted, ids...)
+		return map[string]interface{}{"jsonrpc": "2.0", "result": map[string]interface{}{"itemids": ids}, "id": req.ID}
+	}
+	return map[string]interface{}{"jsonrpc": "2.0", "result": []interface{}{}, "id": req.ID}
+}
+
+func newItems(n int) zabbix.Items {
+	items := make(zabbix.Items, n)
+	for i := range items {
+		items[i].Key = fmt.Sprintf("key%d", i)
+	}
+	return items
+}
+
+func TestItemsCreateBatch(t *testing.T) {
+	f := &fakeBatches{}
+	ts := httptest.NewServer(f)
+	defer ts.Close()
+
+	api := zabbix.NewAPI(ts.URL)
+	api.SetBatchSize(2)
+	items := newItems(5)
+	if err := api.ItemsCreate(items); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if f.posts != 1 {
+		t.Errorf("expected 1 request, got %d", f.posts)
+	}
+	for i, item := range items {
+		if item.ItemID != fmt.Sprint(i+1) {
+			t.Errorf("expected item %d to have id %d, got %s", i, i+1, item.ItemID)
+		}
+	}
+
+	if err := api.ItemsDelete(items); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if f.posts != 2 || len(f.deleted) != 5 {
+		t.Errorf("expected 5 items deleted in 1 request, got %d in %d", len(f.deleted), f.posts-1)
+	}
+}
+
+func TestBatchFallback(t *testing.T) {
+	f := &fakeBatches{noBatch: true}
+	ts := httptest.NewServer(f)
+	defer ts.Close()
+
+	api := zabbix.NewAPI(ts.URL)
+	api.SetBatchSize(2)
+	items := newItems(5)
+	if err := api.ItemsCreate(items); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if api.BatchSupported() {
+		t.Error("expected batches to be disabled")
+	}
+	// the rejected batch and 3 requests
+	if f.posts != 4 {
+		t.Errorf("expected 4 requests, got %d", f.posts)
+	}
+	if items[4].ItemID != "5" {
+		t.Errorf("expected the last item to have id 5, got %s", items[4].ItemID)
+	}
+
+	if err := api.ItemsCreate(newItems(3)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if f.posts != 6 {
+		t.Errorf("expected no more batches, got %d requests", f.posts)
+	}
+}
+
+func TestBatchFailureDeletesCreated(t *testing.T) {
+	f := &fakeBatches{failCall: 2}
+	ts := httptest.NewServer(f)
+	defer ts.Close()
+
+	api := zabbix.NewAPI(ts.URL)
+	api.SetBatchSize(2)
+	items := newItems(5)
+	err := api.ItemsCreate(items)
+	if _, ok := err.(*zabbix.Error); !ok {
+		t.Fatalf("expected the Zabbix error, got %v", err)
+	}
+	if len(f.deleted) != 3 {
+		t.Errorf("expected the 3 created items to be deleted, got %v", f.deleted)
+	}
+	for _, item := range items {
+		if item.ItemID != "" {
+			t.Errorf("expected no item id after the failure, got %s", item.ItemID)
+		}
+	}
+}
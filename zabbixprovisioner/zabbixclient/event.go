@@ -0,0 +1,172 @@
+package zabbixclient
+
+import (
+	"context"
+	"encoding/json"
+)
+
+//AcknowledgeAction flags of event.acknowledge, combined with |
+type AcknowledgeAction int
+
+//AckClose ...
+const (
+	AckClose          AcknowledgeAction = 1
+	AckAcknowledge    AcknowledgeAction = 2
+	AckMessage        AcknowledgeAction = 4
+	AckChangeSeverity AcknowledgeAction = 8
+	// AckUnacknowledge requires Zabbix 5.0 or newer
+	AckUnacknowledge AcknowledgeAction = 16
+
+	// EventSourceTrigger events created by triggers, the only source of problems handled here
+	EventSourceTrigger = 0
+	// EventObjectTrigger events related to triggers
+	EventObjectTrigger = 0
+)
+
+//UnmarshalJSON decodes a number or a string holding a number
+func (a *AcknowledgeAction) UnmarshalJSON(data []byte) error { return unmarshalInt(data, (*int)(a)) }
+
+//ProblemEvent https://www.zabbix.com/documentation/4.4/manual/api/reference/problem/object
+// Problem already names the problem value of triggers
+type ProblemEvent struct {
+	EventID      string        `json:"eventid"`
+	Source       Int           `json:"source"`
+	Object       Int           `json:"object"`
+	ObjectID     string        `json:"objectid"`
+	Clock        Int           `json:"clock"`
+	REventID     string        `json:"r_eventid"`
+	RClock       Int           `json:"r_clock"`
+	Name         string        `json:"name"`
+	Acknowledged Int           `json:"acknowledged"`
+	Severity     PriorityType  `json:"severity"`
+	Suppressed   Int           `json:"suppressed"`
+	Tags         []Tag         `json:"tags,omitempty"`
+	Acknowledges []Acknowledge `json:"acknowledges,omitempty"`
+}
+
+//Problems ...
+type Problems []ProblemEvent
+
+//Event https://www.zabbix.com/documentation/4.4/manual/api/reference/event/object
+type Event struct {
+	EventID      string           `json:"eventid"`
+	Source       Int              `json:"source"`
+	Object       Int              `json:"object"`
+	ObjectID     string           `json:"objectid"`
+	Clock        Int              `json:"clock"`
+	Value        TriggerValueType `json:"value"`
+	Acknowledged Int              `json:"acknowledged"`
+	Name         string           `json:"name"`
+	Severity     PriorityType     `json:"severity"`
+	REventID     string           `json:"r_eventid"`
+	Tags         []Tag            `json:"tags,omitempty"`
+	Acknowledges []Acknowledge    `json:"acknowledges,omitempty"`
+
+	// Hosts filled only by selectHosts
+	Hosts Hosts `json:"-"`
+}
+
+//Events ...
+type Events []Event
+
+//Acknowledge update of an event, filled by selectAcknowledges
+type Acknowledge struct {
+	AcknowledgeID string            `json:"acknowledgeid"`
+	UserID        string            `json:"userid"`
+	EventID       string            `json:"eventid"`
+	Clock         Int               `json:"clock"`
+	Message       string            `json:"message"`
+	Action        AcknowledgeAction `json:"action"`
+	// Username is returned by Zabbix 5.4 and newer, Alias by the older versions
+	Username string `json:"username,omitempty"`
+	Alias    string `json:"alias,omitempty"`
+}
+
+//User name of the user who updated the event, the id when the name isn't returned
+func (a Acknowledge) User() string {
+	switch {
+	case a.Username != "":
+		return a.Username
+	case a.Alias != "":
+		return a.Alias
+	}
+	return a.UserID
+}
+
+//UnmarshalJSON decodes the event with its hosts
+func (e *Event) UnmarshalJSON(data []byte) error {
+	type event Event
+	var v struct {
+		event
+		Hosts Hosts `json:"hosts"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*e = Event(v.event)
+	e.Hosts = v.Hosts
+	return nil
+}
+
+//ProblemsGet Wrapper for problem.get: https://www.zabbix.com/documentation/4.4/manual/api/reference/problem/get
+func (api *API) ProblemsGet(params Params) (Problems, error) {
+	return api.ProblemsGetContext(context.Background(), params)
+}
+
+//ProblemsGetContext is ProblemsGet with a context.
+func (api *API) ProblemsGetContext(ctx context.Context, params Params) (Problems, error) {
+	var res Problems
+	if _, present := params["output"]; !present {
+		params["output"] = "extend"
+	}
+	response, err := api.CallWithErrorContext(ctx, "problem.get", params)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := response.Decode(&res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+//EventsGet Wrapper for event.get: https://www.zabbix.com/documentation/4.4/manual/api/reference/event/get
+func (api *API) EventsGet(params Params) (Events, error) {
+	return api.EventsGetContext(context.Background(), params)
+}
+
+//EventsGetContext is EventsGet with a context.
+func (api *API) EventsGetContext(ctx context.Context, params Params) (Events, error) {
+	var res Events
+	if _, present := params["output"]; !present {
+		params["output"] = "extend"
+	}
+	response, err := api.CallWithErrorContext(ctx, "event.get", params)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := response.Decode(&res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+//EventsAcknowledge Wrapper for event.acknowledge: https://www.zabbix.com/documentation/4.4/manual/api/reference/event/acknowledge
+// The message is required by AckMessage and ignored otherwise. Returns the ids of the updated events.
+func (api *API) EventsAcknowledge(eventIDs []string, action AcknowledgeAction, message string) ([]string, error) {
+	return api.EventsAcknowledgeContext(context.Background(), eventIDs, action, message)
+}
+
+//EventsAcknowledgeContext is EventsAcknowledge with a context.
+func (api *API) EventsAcknowledgeContext(ctx context.Context, eventIDs []string, action AcknowledgeAction, message string) ([]string, error) {
+	params := Params{"eventids": eventIDs, "action": action}
+	if action&AckMessage != 0 {
+		params["message"] = message
+	}
+	response, err := api.CallWithErrorContext(ctx, "event.acknowledge", params)
+	if err != nil {
+		return nil, err
+	}
+	return resultIDs([]Response{response}, "eventids"), nil
+}
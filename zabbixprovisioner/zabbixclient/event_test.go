@@ -0,0 +1,86 @@
+package zabbixclient_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	zabbix "github.com/neogan74/zabbix-alertmanager/zabbixprovisioner/zabbixclient"
+)
+
+func TestProblemsEventsGetRecorded(t *testing.T) {
+	ts := recordedZabbix(t)
+	defer ts.Close()
+	api := zabbix.NewAPI(ts.URL)
+
+	problems, err := api.ProblemsGet(zabbix.Params{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expectedProblems := zabbix.Problems{{
+		EventID: "1205", ObjectID: "16043", Clock: 1700000000, REventID: "0", Name: "NodeDown",
+		Acknowledged: 1, Severity: zabbix.High,
+		Tags: []zabbix.Tag{{Tag: "severity", Value: "critical"}},
+		Acknowledges: []zabbix.Acknowledge{{
+			AcknowledgeID: "7", UserID: "1", EventID: "1205", Clock: 1700000060, Message: "on it",
+			Action: zabbix.AckAcknowledge | zabbix.AckMessage, Alias: "Admin",
+		}},
+	}}
+	if !reflect.DeepEqual(problems, expectedProblems) {
+		t.Errorf("expected problems %+v, got %+v", expectedProblems, problems)
+	} else if user := problems[0].Acknowledges[0].User(); user != "Admin" {
+		t.Errorf("expected the user Admin, got %s", user)
+	}
+
+	events, err := api.EventsGet(zabbix.Params{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expectedEvents := zabbix.Events{{
+		EventID: "1206", ObjectID: "16043", Clock: 1700000300, Value: zabbix.OK, Name: "NodeDown", REventID: "0",
+		Tags:  []zabbix.Tag{},
+		Hosts: zabbix.Hosts{{HostID: "10084", Host: "node1.example.com"}},
+	}}
+	if !reflect.DeepEqual(events, expectedEvents) {
+		t.Errorf("expected events %+v, got %+v", expectedEvents, events)
+	}
+}
+
+func TestEventsAcknowledge(t *testing.T) {
+	var params map[string]interface{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Method string                 `json:"method"`
+			Params map[string]interface{} `json:"params"`
+			ID     int                    `json:"id"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		params = req.Params
+		// Zabbix returns the ids as numbers
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"jsonrpc": "2.0", "result": map[string]interface{}{"eventids": []int{1205, 1207}}, "id": req.ID,
+		})
+	}))
+	defer ts.Close()
+	api := zabbix.NewAPI(ts.URL)
+
+	ids, err := api.EventsAcknowledge([]string{"1205", "1207"}, zabbix.AckClose|zabbix.AckMessage, "resolved")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(ids, []string{"1205", "1207"}) {
+		t.Errorf("unexpected event ids %v", ids)
+	}
+	if params["action"] != float64(5) || params["message"] != "resolved" {
+		t.Errorf("unexpected params %v", params)
+	}
+
+	if _, err := api.EventsAcknowledge([]string{"1205"}, zabbix.AckAcknowledge, "ignored"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := params["message"]; ok {
+		t.Errorf("expected no message without AckMessage, got %v", params)
+	}
+}
@@ -1,9 +1,7 @@
 package zabbixclient
 
 import (
-	"reflect"
-
-	reflector "github.com/neogan74/zabbix-alertmanager/zabbixprovisioner/zabbixutil"
+	"context"
 )
 
 //AvailableType ...
@@ -37,12 +35,21 @@ type Host struct {
 	Name      string        `json:"name"`
 	Status    StatusType    `json:"status"`
 
-	InventoryMode InventoryType     `json:"inventory_mode"`
-	Inventory     map[string]string `json:"inventory"`
+	InventoryMode InventoryType `json:"inventory_mode"`
+	Inventory     HostInventory `json:"inventory"`
+	Tags          []Tag         `json:"tags,omitempty"`
 
 	// Fields below used only when creating hosts
 	GroupIds   HostGroupIDs   `json:"groups,omitempty"`
 	Interfaces HostInterfaces `json:"interfaces,omitempty"`
+
+	// Fields below used only when creating and updating hosts, the linked templates are replaced
+	TemplateIDs    TemplateIDs `json:"templates,omitempty"`
+	TemplatesClear TemplateIDs `json:"templates_clear,omitempty"`
+
+	// Fields below filled only by selectGroups and selectParentTemplates
+	Groups          HostGroups `json:"-"`
+	ParentTemplates Templates  `json:"-"`
 }
 
 //Hosts ...
@@ -50,51 +57,59 @@ type Hosts []Host
 
 //HostsGet Wrapper for host.get: https://www.zabbix.com/documentation/2.2/manual/appendix/api/host/get
 func (api *API) HostsGet(params Params) (Hosts, error) {
+	return api.HostsGetContext(context.Background(), params)
+}
+
+//HostsGetContext is HostsGet with a context.
+func (api *API) HostsGetContext(ctx context.Context, params Params) (Hosts, error) {
 	var res Hosts
 	if _, present := params["output"]; !present {
 		params["output"] = "extend"
 	}
-	response, err := api.CallWithError("host.get", params)
+	response, err := api.CallWithErrorContext(ctx, "host.get", params)
 	if err != nil {
 		return nil, err
 	}
 
-	reflector.MapsToStructs2(response.Result.([]interface{}), &res, reflector.Strconv, "json")
-
-	if _, ok := params["selectInventory"]; ok {
-		results := response.Result.([]interface{})
-		for i := range results {
-			host := results[i].(map[string]interface{})
-			if reflect.TypeOf(host["inventory"]).Kind() == reflect.Map {
-				inventory := host["inventory"].(map[string]interface{})
-				res[i].Inventory = make(map[string]string, len(inventory))
-				for key, value := range inventory {
-					res[i].Inventory[key] = value.(string)
-				}
-			}
-		}
+	if err := response.Decode(&res); err != nil {
+		return nil, err
 	}
 	return res, nil
 }
 
 //HostsGetByHostGroupIDs Gets hosts by host group Ids.
 func (api *API) HostsGetByHostGroupIDs(IDs []string) (res Hosts, err error) {
-	return api.HostsGet(Params{"groupids": IDs})
+	return api.HostsGetByHostGroupIDsContext(context.Background(), IDs)
+}
+
+//HostsGetByHostGroupIDsContext is HostsGetByHostGroupIDs with a context.
+func (api *API) HostsGetByHostGroupIDsContext(ctx context.Context, IDs []string) (res Hosts, err error) {
+	return api.HostsGetContext(ctx, Params{"groupids": IDs})
 }
 
 //HostsGetByHostGroups Gets hosts by host groups.
 func (api *API) HostsGetByHostGroups(hostGroups HostGroups) (res Hosts, err error) {
+	return api.HostsGetByHostGroupsContext(context.Background(), hostGroups)
+}
+
+//HostsGetByHostGroupsContext is HostsGetByHostGroups with a context.
+func (api *API) HostsGetByHostGroupsContext(ctx context.Context, hostGroups HostGroups) (res Hosts, err error) {
 	IDs := make([]string, len(hostGroups))
 	for i, id := range hostGroups {
 		IDs[i] = id.GroupID
 	}
-	return api.HostsGetByHostGroupIDs(IDs)
+	return api.HostsGetByHostGroupIDsContext(ctx, IDs)
 }
 
 //HostGetByID Gets host by Id only if there is exactly 1 matching host.
 func (api *API) HostGetByID(id string) (*Host, error) {
+	return api.HostGetByIDContext(context.Background(), id)
+}
+
+//HostGetByIDContext is HostGetByID with a context.
+func (api *API) HostGetByIDContext(ctx context.Context, id string) (*Host, error) {
 	var res *Host
-	hosts, err := api.HostsGet(Params{"hostids": id})
+	hosts, err := api.HostsGetContext(ctx, Params{"hostids": id})
 	if err != nil {
 		return nil, err
 	}
@@ -111,8 +126,13 @@ func (api *API) HostGetByID(id string) (*Host, error) {
 
 //HostGetByHost Gets host by Host only if there is exactly 1 matching host.
 func (api *API) HostGetByHost(host string) (*Host, error) {
+	return api.HostGetByHostContext(context.Background(), host)
+}
+
+//HostGetByHostContext is HostGetByHost with a context.
+func (api *API) HostGetByHostContext(ctx context.Context, host string) (*Host, error) {
 	var res *Host
-	hosts, err := api.HostsGet(Params{"filter": map[string]string{"host": host}})
+	hosts, err := api.HostsGetContext(ctx, Params{"filter": map[string]string{"host": host}})
 	if err != nil {
 		return nil, err
 	}
@@ -129,22 +149,31 @@ func (api *API) HostGetByHost(host string) (*Host, error) {
 
 //HostsCreate Wrapper for host.create: https://www.zabbix.com/documentation/2.2/manual/appendix/api/host/create
 func (api *API) HostsCreate(hosts Hosts) error {
-	response, err := api.CallWithError("host.create", hosts)
+	return api.HostsCreateContext(context.Background(), hosts)
+}
+
+//HostsCreateContext is HostsCreate with a context.
+func (api *API) HostsCreateContext(ctx context.Context, hosts Hosts) error {
+	response, err := api.CallWithErrorContext(ctx, "host.create", hosts)
 	if err != nil {
 		return err
 	}
 
-	result := response.Result.(map[string]interface{})
-	hostids := result["hostids"].([]interface{})
+	hostids := resultIDs([]Response{response}, "hostids")
 	for i, id := range hostids {
-		hosts[i].HostID = id.(string)
+		hosts[i].HostID = id
 	}
 	return nil
 }
 
 //HostsUpdate Wrapper for host.update: https://www.zabbix.com/documentation/2.2/manual/appendix/api/host/update
 func (api *API) HostsUpdate(hosts Hosts) error {
-	_, err := api.CallWithError("host.update", hosts)
+	return api.HostsUpdateContext(context.Background(), hosts)
+}
+
+//HostsUpdateContext is HostsUpdate with a context.
+func (api *API) HostsUpdateContext(ctx context.Context, hosts Hosts) error {
+	_, err := api.CallWithErrorContext(ctx, "host.update", hosts)
 	if err != nil {
 		return err
 	}
@@ -154,12 +183,17 @@ func (api *API) HostsUpdate(hosts Hosts) error {
 //HostsDelete Wrapper for host.delete: https://www.zabbix.com/documentation/2.2/manual/appendix/api/host/delete
 // Cleans HostId in all hosts elements if call succeed.
 func (api *API) HostsDelete(hosts Hosts) error {
+	return api.HostsDeleteContext(context.Background(), hosts)
+}
+
+//HostsDeleteContext is HostsDelete with a context.
+func (api *API) HostsDeleteContext(ctx context.Context, hosts Hosts) error {
 	ids := make([]string, len(hosts))
 	for i, host := range hosts {
 		ids[i] = host.HostID
 	}
 
-	err := api.HostsDeleteByIDs(ids)
+	err := api.HostsDeleteByIDsContext(ctx, ids)
 	if err != nil {
 		return err
 	}
@@ -172,16 +206,21 @@ func (api *API) HostsDelete(hosts Hosts) error {
 
 //HostsDeleteByIDs Wrapper for host.delete: https://www.zabbix.com/documentation/2.2/manual/appendix/api/host/delete
 func (api *API) HostsDeleteByIDs(IDs []string) error {
+	return api.HostsDeleteByIDsContext(context.Background(), IDs)
+}
+
+//HostsDeleteByIDsContext is HostsDeleteByIDs with a context.
+func (api *API) HostsDeleteByIDsContext(ctx context.Context, IDs []string) error {
 	hostIds := make([]map[string]string, len(IDs))
 	for i, id := range IDs {
 		hostIds[i] = map[string]string{"hostid": id}
 	}
 
-	response, err := api.CallWithError("host.delete", hostIds)
+	response, err := api.CallWithErrorContext(ctx, "host.delete", hostIds)
 	if err != nil {
 		// Zabbix 2.4 uses new syntax only
 		if e, ok := err.(*Error); ok && e.Code == -32500 {
-			response, err = api.CallWithError("host.delete", IDs)
+			response, err = api.CallWithErrorContext(ctx, "host.delete", IDs)
 			if err != nil {
 				return err
 			}
@@ -191,8 +230,7 @@ func (api *API) HostsDeleteByIDs(IDs []string) error {
 		return err
 	}
 
-	result := response.Result.(map[string]interface{})
-	hostids := result["hostids"].([]interface{})
+	hostids := resultIDs([]Response{response}, "hostids")
 	if len(IDs) != len(hostids) {
 		err = &ExpectedMore{len(IDs), len(hostids)}
 		return err
@@ -1,7 +1,7 @@
 package zabbixclient
 
 import (
-	reflector "github.com/neogan74/zabbix-alertmanager/zabbixprovisioner/zabbixutil"
+	"context"
 )
 
 //InternalType ...
@@ -33,23 +33,35 @@ type HostGroupIDs []HostGroupID
 
 //HostGroupsGet Wrapper for hostgroup.get: https://www.zabbix.com/documentation/4.4/manual/appendix/api/hostgroup/get
 func (api *API) HostGroupsGet(params Params) (HostGroups, error) {
+	return api.HostGroupsGetContext(context.Background(), params)
+}
+
+//HostGroupsGetContext is HostGroupsGet with a context.
+func (api *API) HostGroupsGetContext(ctx context.Context, params Params) (HostGroups, error) {
 	var res HostGroups
 	if _, present := params["output"]; !present {
 		params["output"] = "extend"
 	}
-	response, err := api.CallWithError("hostgroup.get", params)
+	response, err := api.CallWithErrorContext(ctx, "hostgroup.get", params)
 	if err != nil {
 		return nil, err
 	}
 
-	reflector.MapsToStructs2(response.Result.([]interface{}), &res, reflector.Strconv, "json")
+	if err := response.Decode(&res); err != nil {
+		return nil, err
+	}
 	return res, nil
 }
 
 //HostGroupGetByID Gets host group by Id only if there is exactly 1 matching host group.
 func (api *API) HostGroupGetByID(id string) (*HostGroup, error) {
+	return api.HostGroupGetByIDContext(context.Background(), id)
+}
+
+//HostGroupGetByIDContext is HostGroupGetByID with a context.
+func (api *API) HostGroupGetByIDContext(ctx context.Context, id string) (*HostGroup, error) {
 	var res *HostGroup
-	groups, err := api.HostGroupsGet(Params{"groupids": id})
+	groups, err := api.HostGroupsGetContext(ctx, Params{"groupids": id})
 	if err != nil {
 		return nil, err
 	}
@@ -66,15 +78,19 @@ func (api *API) HostGroupGetByID(id string) (*HostGroup, error) {
 
 //HostGroupsCreate Wrapper for hostgroup.create: https://www.zabbix.com/documentation/4.4/manual/appendix/api/hostgroup/create
 func (api *API) HostGroupsCreate(hostGroups HostGroups) error {
-	response, err := api.CallWithError("hostgroup.create", hostGroups)
+	return api.HostGroupsCreateContext(context.Background(), hostGroups)
+}
+
+//HostGroupsCreateContext is HostGroupsCreate with a context.
+func (api *API) HostGroupsCreateContext(ctx context.Context, hostGroups HostGroups) error {
+	response, err := api.CallWithErrorContext(ctx, "hostgroup.create", hostGroups)
 	if err != nil {
 		return err
 	}
 
-	result := response.Result.(map[string]interface{})
-	groupids := result["groupids"].([]interface{})
+	groupids := resultIDs([]Response{response}, "groupids")
 	for i, id := range groupids {
-		hostGroups[i].GroupID = id.(string)
+		hostGroups[i].GroupID = id
 	}
 	return nil
 }
@@ -82,12 +98,17 @@ func (api *API) HostGroupsCreate(hostGroups HostGroups) error {
 //HostGroupsDelete Wrapper for hostgroup.delete: https://www.zabbix.com/documentation/4.4/manual/appendix/api/hostgroup/delete
 // Cleans GroupId in all hostGroups elements if call succeed.
 func (api *API) HostGroupsDelete(hostGroups HostGroups) error {
+	return api.HostGroupsDeleteContext(context.Background(), hostGroups)
+}
+
+//HostGroupsDeleteContext is HostGroupsDelete with a context.
+func (api *API) HostGroupsDeleteContext(ctx context.Context, hostGroups HostGroups) error {
 	ids := make([]string, len(hostGroups))
 	for i, group := range hostGroups {
 		ids[i] = group.GroupID
 	}
 
-	err := api.HostGroupsDeleteByIds(ids)
+	err := api.HostGroupsDeleteByIdsContext(ctx, ids)
 	if err != nil {
 		return err
 	}
@@ -101,13 +122,19 @@ func (api *API) HostGroupsDelete(hostGroups HostGroups) error {
 
 //HostGroupsDeleteByIds Wrapper for hostgroup.delete: https://www.zabbix.com/documentation/4.4/manual/appendix/api/hostgroup/delete
 func (api *API) HostGroupsDeleteByIds(ids []string) error {
-	response, err := api.CallWithError("hostgroup.delete", ids)
+	return api.HostGroupsDeleteByIdsContext(context.Background(), ids)
+}
+
+//HostGroupsDeleteByIdsContext is HostGroupsDeleteByIds with a context.
+func (api *API) HostGroupsDeleteByIdsContext(ctx context.Context, ids []string) error {
+	responses, err := api.callChunks(ctx, "hostgroup.delete", len(ids), func(from, to int) interface{} {
+		return ids[from:to]
+	})
 	if err != nil {
 		return err
 	}
 
-	result := response.Result.(map[string]interface{})
-	groupids := result["groupids"].([]interface{})
+	groupids := resultIDs(responses, "groupids")
 	if len(ids) != len(groupids) {
 		err = &ExpectedMore{len(ids), len(groupids)}
 		return err
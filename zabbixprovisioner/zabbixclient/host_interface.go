@@ -15,10 +15,23 @@ const (
 type HostInterface struct {
 	DNS   string        `json:"dns"`
 	IP    string        `json:"ip"`
-	Main  int           `json:"main"`
+	Main  Int           `json:"main"`
 	Port  string        `json:"port"`
 	Type  InterfaceType `json:"type"`
-	UseIP int           `json:"useip"`
+	UseIP Int           `json:"useip"`
+
+	// Details used only by SNMP interfaces
+	Details *HostInterfaceDetails `json:"details,omitempty"`
+
+	// Available filled only by the get methods of Zabbix 5.4 and newer, read only
+	Available AvailableType `json:"-"`
+}
+
+//HostInterfaceDetails SNMP details of the interface
+type HostInterfaceDetails struct {
+	Version   string `json:"version"`
+	Bulk      string `json:"bulk"`
+	Community string `json:"community,omitempty"`
 }
 
 //HostInterfaces ...
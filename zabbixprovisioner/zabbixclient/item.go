@@ -1,9 +1,8 @@
 package zabbixclient
 
 import (
+	"context"
 	"fmt"
-
-	reflector "github.com/neogan74/zabbix-alertmanager/zabbixprovisioner/zabbixutil"
 )
 
 //ItemType ...
@@ -56,23 +55,27 @@ const (
 
 //Item https://www.zabbix.com/documentation/4.4/manual/appendix/api/item/definitions
 type Item struct {
-	ItemID       string    `json:"itemid,omitempty"`
-	Delay        string    `json:"delay"`
-	HostID       string    `json:"hostid"`
-	InterfaceID  string    `json:"interfaceid,omitempty"`
-	Key          string    `json:"key_"`
-	Name         string    `json:"name"`
-	Type         ItemType  `json:"type"`
-	ValueType    ValueType `json:"value_type"`
-	DataType     DataType  `json:"data_type"`
-	Delta        DeltaType `json:"delta"`
-	Description  string    `json:"description"`
-	Error        string    `json:"error"`
-	History      string    `json:"history,omitempty"`
-	Trends       string    `json:"trends,omitempty"`
-	TrapperHosts string    `json:"trapper_hosts,omitempty"`
+	ItemID       string     `json:"itemid,omitempty"`
+	Delay        string     `json:"delay"`
+	HostID       string     `json:"hostid"`
+	InterfaceID  string     `json:"interfaceid,omitempty"`
+	Key          string     `json:"key_"`
+	Name         string     `json:"name"`
+	Type         ItemType   `json:"type"`
+	ValueType    ValueType  `json:"value_type"`
+	DataType     DataType   `json:"data_type"`
+	Delta        DeltaType  `json:"delta"`
+	Description  string     `json:"description"`
+	Status       StatusType `json:"status"`
+	Error        string     `json:"error"`
+	History      string     `json:"history,omitempty"`
+	Trends       string     `json:"trends,omitempty"`
+	TrapperHosts string     `json:"trapper_hosts,omitempty"`
 
 	ApplicationIds []string `json:"applications,omitempty"`
+
+	// ItemApplications filled only by selectApplications
+	ItemApplications Applications `json:"-"`
 }
 
 //Items ...
@@ -93,42 +96,70 @@ func (items Items) ByKey() map[string]Item {
 
 //ItemsGet Wrapper for item.get https://www.zabbix.com/documentation/2.2/manual/appendix/api/item/get
 func (api *API) ItemsGet(params Params) (Items, error) {
+	return api.ItemsGetContext(context.Background(), params)
+}
+
+//ItemsGetContext is ItemsGet with a context.
+func (api *API) ItemsGetContext(ctx context.Context, params Params) (Items, error) {
 	var res Items
 	if _, present := params["output"]; !present {
 		params["output"] = "extend"
 	}
-	response, err := api.CallWithError("item.get", params)
+	response, err := api.CallWithErrorContext(ctx, "item.get", params)
 	if err != nil {
 		return nil, err
 	}
 
-	reflector.MapsToStructs2(response.Result.([]interface{}), &res, reflector.Strconv, "json")
+	if err := response.Decode(&res); err != nil {
+		return nil, err
+	}
 	return res, nil
 }
 
 //ItemsGetByApplicationID Gets items by application Id.
 func (api *API) ItemsGetByApplicationID(id string) (res Items, err error) {
-	return api.ItemsGet(Params{"applicationids": id})
+	return api.ItemsGetByApplicationIDContext(context.Background(), id)
+}
+
+//ItemsGetByApplicationIDContext is ItemsGetByApplicationID with a context.
+func (api *API) ItemsGetByApplicationIDContext(ctx context.Context, id string) (res Items, err error) {
+	return api.ItemsGetContext(ctx, Params{"applicationids": id})
 }
 
 //ItemsCreate Wrapper for item.create: https://www.zabbix.com/documentation/2.2/manual/appendix/api/item/create
 func (api *API) ItemsCreate(items Items) error {
-	response, err := api.CallWithError("item.create", items)
+	return api.ItemsCreateContext(context.Background(), items)
+}
+
+//ItemsCreateContext is ItemsCreate with a context.
+func (api *API) ItemsCreateContext(ctx context.Context, items Items) error {
+	responses, err := api.callChunks(ctx, "item.create", len(items), func(from, to int) interface{} {
+		return items[from:to]
+	})
+	itemids := resultIDs(responses, "itemids")
 	if err != nil {
+		// the items of the successful requests of the batch are removed again
+		if len(itemids) != 0 {
+			if err := api.ItemsDeleteByIDsContext(ctx, itemids); err != nil {
+				api.printf("Error deleting the items created by the failed batch: %s", err)
+			}
+		}
 		return err
 	}
-
-	result := response.Result.(map[string]interface{})
-	itemids := result["itemids"].([]interface{})
 	for i, id := range itemids {
-		items[i].ItemID = id.(string)
+		items[i].ItemID = id
 	}
 	return nil
 }
 
 //ItemsUpdate Wrapper for item.update: https://www.zabbix.com/documentation/2.2/manual/appendix/api/item/update
 func (api *API) ItemsUpdate(items Items) error {
-	_, err := api.CallWithError("item.update", items)
+	return api.ItemsUpdateContext(context.Background(), items)
+}
+
+//ItemsUpdateContext is ItemsUpdate with a context.
+func (api *API) ItemsUpdateContext(ctx context.Context, items Items) error {
+	_, err := api.CallWithErrorContext(ctx, "item.update", items)
 	if err != nil {
 		return err
 	}
@@ -138,13 +169,18 @@ func (api *API) ItemsUpdate(items Items) error {
 //ItemsDelete Wrapper for item.delete: https://www.zabbix.com/documentation/2.2/manual/appendix/api/item/delete
 // Cleans ItemId in all items elements if call succeed.
 func (api *API) ItemsDelete(items Items) error {
+	return api.ItemsDeleteContext(context.Background(), items)
+}
+
+//ItemsDeleteContext is ItemsDelete with a context.
+func (api *API) ItemsDeleteContext(ctx context.Context, items Items) error {
 	ids := make([]string, len(items))
 	for i, item := range items {
 		ids[i] = item.ItemID
 	}
 
-	err := api.ItemsDeleteByIDs(ids)
-	if err == nil {
+	err := api.ItemsDeleteByIDsContext(ctx, ids)
+	if err != nil {
 		return err
 	}
 	for i := range items {
@@ -155,18 +191,19 @@ func (api *API) ItemsDelete(items Items) error {
 
 //ItemsDeleteByIDs Wrapper for item.delete: https://www.zabbix.com/documentation/2.2/manual/appendix/api/item/delete
 func (api *API) ItemsDeleteByIDs(ids []string) error {
-	response, err := api.CallWithError("item.delete", ids)
+	return api.ItemsDeleteByIDsContext(context.Background(), ids)
+}
+
+//ItemsDeleteByIDsContext is ItemsDeleteByIDs with a context.
+func (api *API) ItemsDeleteByIDsContext(ctx context.Context, ids []string) error {
+	responses, err := api.callChunks(ctx, "item.delete", len(ids), func(from, to int) interface{} {
+		return ids[from:to]
+	})
 	if err != nil {
 		return err
 	}
 
-	result := response.Result.(map[string]interface{})
-	itemids1, ok := result["itemids"].([]interface{})
-	l := len(itemids1)
-	if !ok {
-		itemids2 := result["itemids"].(map[string]interface{})
-		l = len(itemids2)
-	}
+	l := len(resultIDs(responses, "itemids"))
 	if len(ids) != l {
 		err = &ExpectedMore{len(ids), l}
 		return err
@@ -0,0 +1,194 @@
+package zabbixclient
+
+import (
+	"context"
+	"encoding/json"
+)
+
+//MaintenanceType ...
+type MaintenanceType int
+
+//TimePeriodType ...
+type TimePeriodType int
+
+//MaintenanceWithData ...
+const (
+	MaintenanceWithData MaintenanceType = 0
+	MaintenanceNoData   MaintenanceType = 1
+
+	OneTimeOnly TimePeriodType = 0
+	Daily       TimePeriodType = 2
+	Weekly      TimePeriodType = 3
+	Monthly     TimePeriodType = 4
+)
+
+//UnmarshalJSON decodes a number or a string holding a number
+func (t *MaintenanceType) UnmarshalJSON(data []byte) error { return unmarshalInt(data, (*int)(t)) }
+
+//UnmarshalJSON decodes a number or a string holding a number
+func (t *TimePeriodType) UnmarshalJSON(data []byte) error { return unmarshalInt(data, (*int)(t)) }
+
+//Maintenance https://www.zabbix.com/documentation/4.4/manual/api/reference/maintenance/object
+type Maintenance struct {
+	MaintenanceID   string          `json:"maintenanceid,omitempty"`
+	Name            string          `json:"name"`
+	ActiveSince     Int             `json:"active_since"`
+	ActiveTill      Int             `json:"active_till"`
+	Description     string          `json:"description"`
+	MaintenanceType MaintenanceType `json:"maintenance_type"`
+	TimePeriods     TimePeriods     `json:"timeperiods"`
+	// Tags used only by maintenances with data collection, they limit the suppressed problems
+	Tags []MaintenanceTag `json:"tags,omitempty"`
+
+	// Fields below used only when creating and updating maintenances, the hosts and groups are replaced
+	HostIDs  []string `json:"hostids,omitempty"`
+	GroupIDs []string `json:"groupids,omitempty"`
+
+	// Fields below filled only by selectHosts and selectGroups
+	Hosts  Hosts      `json:"-"`
+	Groups HostGroups `json:"-"`
+}
+
+//Maintenances ...
+type Maintenances []Maintenance
+
+//TimePeriod https://www.zabbix.com/documentation/4.4/manual/api/reference/maintenance/object#time_period
+type TimePeriod struct {
+	TimePeriodType TimePeriodType `json:"timeperiod_type"`
+	// StartDate used only by one time periods
+	StartDate Int `json:"start_date,omitempty"`
+	Period    Int `json:"period"`
+	// Fields below used only by daily, weekly and monthly periods
+	Every     Int `json:"every,omitempty"`
+	DayOfWeek Int `json:"dayofweek,omitempty"`
+	Day       Int `json:"day,omitempty"`
+	Month     Int `json:"month,omitempty"`
+	StartTime Int `json:"start_time,omitempty"`
+}
+
+//TimePeriods ...
+type TimePeriods []TimePeriod
+
+//MaintenanceTag problem tag of a maintenance, Operator 0 is equals and 2 is contains
+type MaintenanceTag struct {
+	Tag      string `json:"tag"`
+	Operator Int    `json:"operator"`
+	Value    string `json:"value"`
+}
+
+//UnmarshalJSON decodes the maintenance with its hosts and groups
+func (m *Maintenance) UnmarshalJSON(data []byte) error {
+	type maintenance Maintenance
+	var v struct {
+		maintenance
+		Hosts  Hosts      `json:"hosts"`
+		Groups HostGroups `json:"groups"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*m = Maintenance(v.maintenance)
+	m.Hosts = v.Hosts
+	m.Groups = v.Groups
+	return nil
+}
+
+//MaintenancesGet Wrapper for maintenance.get: https://www.zabbix.com/documentation/4.4/manual/api/reference/maintenance/get
+func (api *API) MaintenancesGet(params Params) (Maintenances, error) {
+	return api.MaintenancesGetContext(context.Background(), params)
+}
+
+//MaintenancesGetContext is MaintenancesGet with a context.
+func (api *API) MaintenancesGetContext(ctx context.Context, params Params) (Maintenances, error) {
+	var res Maintenances
+	if _, present := params["output"]; !present {
+		params["output"] = "extend"
+	}
+	response, err := api.CallWithErrorContext(ctx, "maintenance.get", params)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := response.Decode(&res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+//MaintenancesCreate Wrapper for maintenance.create: https://www.zabbix.com/documentation/4.4/manual/api/reference/maintenance/create
+func (api *API) MaintenancesCreate(maintenances Maintenances) error {
+	return api.MaintenancesCreateContext(context.Background(), maintenances)
+}
+
+//MaintenancesCreateContext is MaintenancesCreate with a context.
+func (api *API) MaintenancesCreateContext(ctx context.Context, maintenances Maintenances) error {
+	response, err := api.CallWithErrorContext(ctx, "maintenance.create", maintenances)
+	if err != nil {
+		return err
+	}
+
+	maintenanceids := resultIDs([]Response{response}, "maintenanceids")
+	for i, id := range maintenanceids {
+		maintenances[i].MaintenanceID = id
+	}
+	return nil
+}
+
+//MaintenancesUpdate Wrapper for maintenance.update: https://www.zabbix.com/documentation/4.4/manual/api/reference/maintenance/update
+func (api *API) MaintenancesUpdate(maintenances Maintenances) error {
+	return api.MaintenancesUpdateContext(context.Background(), maintenances)
+}
+
+//MaintenancesUpdateContext is MaintenancesUpdate with a context.
+func (api *API) MaintenancesUpdateContext(ctx context.Context, maintenances Maintenances) error {
+	_, err := api.CallWithErrorContext(ctx, "maintenance.update", maintenances)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+//MaintenancesDelete Wrapper for maintenance.delete: https://www.zabbix.com/documentation/4.4/manual/api/reference/maintenance/delete
+// Cleans MaintenanceID in all maintenances elements if call succeed.
+func (api *API) MaintenancesDelete(maintenances Maintenances) error {
+	return api.MaintenancesDeleteContext(context.Background(), maintenances)
+}
+
+//MaintenancesDeleteContext is MaintenancesDelete with a context.
+func (api *API) MaintenancesDeleteContext(ctx context.Context, maintenances Maintenances) error {
+	ids := make([]string, len(maintenances))
+	for i, maintenance := range maintenances {
+		ids[i] = maintenance.MaintenanceID
+	}
+
+	err := api.MaintenancesDeleteByIDsContext(ctx, ids)
+	if err != nil {
+		return err
+	}
+
+	for i := range maintenances {
+		maintenances[i].MaintenanceID = ""
+	}
+	return nil
+}
+
+//MaintenancesDeleteByIDs Wrapper for maintenance.delete: https://www.zabbix.com/documentation/4.4/manual/api/reference/maintenance/delete
+func (api *API) MaintenancesDeleteByIDs(ids []string) error {
+	return api.MaintenancesDeleteByIDsContext(context.Background(), ids)
+}
+
+//MaintenancesDeleteByIDsContext is MaintenancesDeleteByIDs with a context.
+func (api *API) MaintenancesDeleteByIDsContext(ctx context.Context, ids []string) error {
+	responses, err := api.callChunks(ctx, "maintenance.delete", len(ids), func(from, to int) interface{} {
+		return ids[from:to]
+	})
+	if err != nil {
+		return err
+	}
+
+	maintenanceids := resultIDs(responses, "maintenanceids")
+	if len(ids) != len(maintenanceids) {
+		return &ExpectedMore{len(ids), len(maintenanceids)}
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package zabbixclient_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	zabbix "github.com/neogan74/zabbix-alertmanager/zabbixprovisioner/zabbixclient"
+)
+
+func TestMaintenancesGetRecorded(t *testing.T) {
+	ts := recordedZabbix(t)
+	defer ts.Close()
+
+	maintenances, err := zabbix.NewAPI(ts.URL).MaintenancesGet(zabbix.Params{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := zabbix.Maintenances{{
+		MaintenanceID:   "3",
+		Name:            "Alertmanager silence 8f0a",
+		ActiveSince:     1700000000,
+		ActiveTill:      1700003600,
+		Description:     "Created by alice in Alertmanager: disk swap\n\nmanaged_by: zal",
+		MaintenanceType: zabbix.MaintenanceWithData,
+		TimePeriods: zabbix.TimePeriods{{
+			TimePeriodType: zabbix.OneTimeOnly, StartDate: 1700000000, Period: 3600, Every: 1, Day: 1,
+		}},
+		Tags:   []zabbix.MaintenanceTag{{Tag: "service", Operator: 2, Value: "db"}},
+		Hosts:  zabbix.Hosts{{HostID: "10084"}, {HostID: "10085"}},
+		Groups: zabbix.HostGroups{},
+	}}
+	if !reflect.DeepEqual(maintenances, expected) {
+		t.Fatalf("expected maintenances %+v, got %+v", expected, maintenances)
+	}
+
+	maintenances[0].Hosts = nil
+	maintenances[0].Groups = nil
+	checkRoundTrip(t, maintenances)
+}
+
+func TestMaintenancesCreateDelete(t *testing.T) {
+	var methods []string
+	var created []map[string]interface{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Method string          `json:"method"`
+			Params json.RawMessage `json:"params"`
+			ID     int             `json:"id"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		methods = append(methods, req.Method)
+		result := map[string]interface{}{}
+		switch req.Method {
+		case "maintenance.create":
+			json.Unmarshal(req.Params, &created)
+			result["maintenanceids"] = []string{"7", "8"}
+		case "maintenance.delete":
+			var ids []string
+			json.Unmarshal(req.Params, &ids)
+			result["maintenanceids"] = ids
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "result": result, "id": req.ID})
+	}))
+	defer ts.Close()
+
+	api := zabbix.NewAPI(ts.URL)
+	maintenances := zabbix.Maintenances{
+		{Name: "a", ActiveSince: 60, ActiveTill: 660, HostIDs: []string{"10084"},
+			TimePeriods: zabbix.TimePeriods{{TimePeriodType: zabbix.OneTimeOnly, StartDate: 60, Period: 600}}},
+		{Name: "b", ActiveSince: 60, ActiveTill: 660, GroupIDs: []string{"15"},
+			TimePeriods: zabbix.TimePeriods{{TimePeriodType: zabbix.Daily, Every: 1, StartTime: 3600, Period: 600}}},
+	}
+	if err := api.MaintenancesCreate(maintenances); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if maintenances[0].MaintenanceID != "7" || maintenances[1].MaintenanceID != "8" {
+		t.Errorf("expected the created ids, got %+v", maintenances)
+	}
+	if len(created) != 2 || created[0]["hostids"] == nil || created[0]["groupids"] != nil || created[1]["groupids"] == nil {
+		t.Errorf("unexpected maintenances sent %v", created)
+	}
+
+	if err := api.MaintenancesDelete(maintenances); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if maintenances[0].MaintenanceID != "" || maintenances[1].MaintenanceID != "" {
+		t.Errorf("expected the ids to be cleaned, got %+v", maintenances)
+	}
+	if !reflect.DeepEqual(methods, []string{"maintenance.create", "maintenance.delete"}) {
+		t.Errorf("unexpected calls %v", methods)
+	}
+}
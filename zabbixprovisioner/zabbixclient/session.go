@@ -0,0 +1,139 @@
+package zabbixclient
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+//IsSessionExpired reports whether the error means the session ended and Login has to be called again
+func IsSessionExpired(err error) bool {
+	e, ok := err.(*Error)
+	if !ok {
+		return false
+	}
+	data := strings.ToLower(e.Data)
+	return strings.Contains(data, "session terminated") || strings.Contains(data, "not authorised") || strings.Contains(data, "not authorized")
+}
+
+//VersionAtLeast reports whether the Zabbix version, as returned by Version, is at least major.minor
+func VersionAtLeast(version string, major, minor int) bool {
+	parts := strings.SplitN(version, ".", 3)
+	if len(parts) < 2 {
+		return false
+	}
+	vMajor, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return false
+	}
+	vMinor, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return false
+	}
+	return vMajor > major || vMajor == major && vMinor >= minor
+}
+
+//Login Calls "user.login" API method and fills api.Auth field.
+// The credentials are kept to log in again when the session expires.
+// Zabbix 5.4 renamed the user parameter to username, the version is checked first.
+func (api *API) Login(user, password string) (string, error) {
+	userParam := "user"
+	if version, err := api.Version(); err == nil && VersionAtLeast(version, 5, 4) {
+		userParam = "username"
+	}
+
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	auth, err := api.login(context.Background(), userParam, user, password)
+	if err != nil {
+		return "", err
+	}
+	api.Auth = auth
+	api.user, api.password, api.userParam = user, password, userParam
+	api.token, api.bearer = "", false
+	return auth, nil
+}
+
+//LoginWithToken uses the API token for the calls, tokens are supported by Zabbix 5.4 and newer.
+// The token is sent in the Authorization header to Zabbix 6.4 and newer, in the auth field to older versions.
+func (api *API) LoginWithToken(token string) error {
+	version, err := api.Version()
+	if err != nil {
+		return err
+	}
+	if !VersionAtLeast(version, 5, 4) {
+		return fmt.Errorf("API tokens require Zabbix 5.4 or newer, got %s", version)
+	}
+
+	api.mu.Lock()
+	api.Auth = token
+	api.user, api.password, api.userParam = "", "", ""
+	api.token, api.bearer = token, VersionAtLeast(version, 6, 4)
+	api.mu.Unlock()
+
+	// Tokens are checked by a cheap call, user.login would fail with them
+	_, err = api.CallWithError("hostgroup.get", Params{"output": []string{"groupid"}, "limit": 1})
+	return err
+}
+
+//Logout Calls "user.logout" API method to end the session of Login and clears api.Auth.
+// API tokens don't have a session, the token is only forgotten.
+func (api *API) Logout() error {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+
+	auth := api.Auth
+	token := api.token
+	api.Auth = ""
+	api.user, api.password, api.userParam = "", "", ""
+	api.token, api.bearer = "", false
+	if auth == "" || token != "" {
+		return nil
+	}
+
+	_, err := api.callWithError(context.Background(), "user.logout", []string{}, auth, false)
+	return err
+}
+
+//session returns the auth to send and whether it goes to the Authorization header
+func (api *API) session() (string, bool) {
+	api.mu.RLock()
+	defer api.mu.RUnlock()
+	return api.Auth, api.bearer
+}
+
+func (api *API) canRelogin() bool {
+	api.mu.RLock()
+	defer api.mu.RUnlock()
+	return api.user != ""
+}
+
+//relogin logs in again unless another call did it since the expired auth was used
+func (api *API) relogin(ctx context.Context, expired string) error {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	if api.Auth != expired || api.user == "" {
+		return nil
+	}
+
+	auth, err := api.login(ctx, api.userParam, api.user, api.password)
+	if err != nil {
+		return err
+	}
+	api.Auth = auth
+	return nil
+}
+
+func (api *API) login(ctx context.Context, userParam, user, password string) (string, error) {
+	params := map[string]string{userParam: user, "password": password}
+	response, err := api.callWithError(ctx, "user.login", params, "", false)
+	if err != nil {
+		return "", err
+	}
+	var auth string
+	if err := response.Decode(&auth); err != nil {
+		return "", err
+	}
+	return auth, nil
+}
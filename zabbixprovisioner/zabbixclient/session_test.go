@@ -0,0 +1,169 @@
+package zabbixclient_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	zabbix "github.com/neogan74/zabbix-alertmanager/zabbixprovisioner/zabbixclient"
+)
+
+//fakeSessions Zabbix API accepting the current session or the token
+type fakeSessions struct {
+	version string
+	token   string
+
+	mu       sync.Mutex
+	session  string
+	logins   []map[string]string
+	logouts  int
+	lastAuth string
+	bearer   string
+}
+
+func (f *fakeSessions) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Method string          `json:"method"`
+		Params json.RawMessage `json:"params"`
+		Auth   string          `json:"auth"`
+		ID     int             `json:"id"`
+	}
+	json.NewDecoder(r.Body).Decode(&req)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.lastAuth = req.Auth
+	f.bearer = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+	var result interface{}
+	switch req.Method {
+	case "APIInfo.version":
+		result = f.version
+	case "user.login":
+		var params map[string]string
+		json.Unmarshal(req.Params, &params)
+		f.logins = append(f.logins, params)
+		f.session = fmt.Sprintf("session%d", len(f.logins))
+		result = f.session
+	case "user.logout":
+		f.logouts++
+		result = true
+	default:
+		if req.Auth == "" || req.Auth != f.session && req.Auth != f.token {
+			if f.bearer == "" || f.bearer != f.token {
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"jsonrpc": "2.0",
+					"error":   map[string]interface{}{"code": -32602, "message": "Invalid params.", "data": "Session terminated, re-login, please."},
+					"id":      req.ID,
+				})
+				return
+			}
+		}
+		result = []interface{}{}
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "result": result, "id": req.ID})
+}
+
+func (f *fakeSessions) expire() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.session = "expired"
+}
+
+func TestLoginUserParam(t *testing.T) {
+	for version, param := range map[string]string{"4.4.0": "user", "5.4.0": "username", "6.0.12": "username"} {
+		f := &fakeSessions{version: version}
+		ts := httptest.NewServer(f)
+		defer ts.Close()
+
+		api := zabbix.NewAPI(ts.URL)
+		if _, err := api.Login("admin", "secret"); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if f.logins[0][param] != "admin" {
+			t.Errorf("%s: expected the user in the %s parameter, got %v", version, param, f.logins[0])
+		}
+	}
+}
+
+func TestReloginOnSessionExpired(t *testing.T) {
+	f := &fakeSessions{version: "6.0.0"}
+	ts := httptest.NewServer(f)
+	defer ts.Close()
+
+	api := zabbix.NewAPI(ts.URL)
+	if _, err := api.Login("admin", "secret"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	f.expire()
+	if _, err := api.HostGroupsGet(zabbix.Params{}); err != nil {
+		t.Fatalf("Expected to log in again, got error: %v", err)
+	}
+	if len(f.logins) != 2 || api.Auth != "session2" {
+		t.Errorf("Expected a second login, got %d logins and auth %s", len(f.logins), api.Auth)
+	}
+
+	if err := api.Logout(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if f.logouts != 1 || f.lastAuth != "session2" || api.Auth != "" {
+		t.Errorf("Expected logout of session2, got %d logouts with auth %s", f.logouts, f.lastAuth)
+	}
+
+	// After logout the session isn't renewed
+	if _, err := api.HostGroupsGet(zabbix.Params{}); !zabbix.IsSessionExpired(err) {
+		t.Errorf("Expected session expired error, got %v", err)
+	}
+}
+
+func TestLoginWithToken(t *testing.T) {
+	tests := []struct {
+		version string
+		bearer  bool
+	}{
+		{"6.4.0", true},
+		{"7.0.1", true},
+		{"6.0.0", false},
+	}
+	for _, test := range tests {
+		f := &fakeSessions{version: test.version, token: "token"}
+		ts := httptest.NewServer(f)
+		defer ts.Close()
+
+		api := zabbix.NewAPI(ts.URL)
+		if err := api.LoginWithToken("token"); err != nil {
+			t.Fatalf("%s: unexpected error: %v", test.version, err)
+		}
+		if test.bearer && (f.bearer != "token" || f.lastAuth != "") {
+			t.Errorf("%s: expected the token in the Authorization header, got header %q and auth %q", test.version, f.bearer, f.lastAuth)
+		}
+		if !test.bearer && (f.bearer != "" || f.lastAuth != "token") {
+			t.Errorf("%s: expected the token in the auth field, got header %q and auth %q", test.version, f.bearer, f.lastAuth)
+		}
+
+		if err := api.Logout(); err != nil || f.logouts != 0 {
+			t.Errorf("%s: expected no user.logout for tokens, got %d, %v", test.version, f.logouts, err)
+		}
+	}
+
+	f := &fakeSessions{version: "5.2.0", token: "token"}
+	ts := httptest.NewServer(f)
+	defer ts.Close()
+	if err := zabbix.NewAPI(ts.URL).LoginWithToken("token"); err == nil {
+		t.Error("Expected to get error for Zabbix 5.2, got :", err)
+	}
+}
+
+func TestVersionAtLeast(t *testing.T) {
+	tests := map[string]bool{"5.4.0": true, "5.2.7": false, "6.0.0": true, "4.4.10": false, "10.0": true, "": false, "x.y": false}
+	for version, expected := range tests {
+		if got := zabbix.VersionAtLeast(version, 5, 4); got != expected {
+			t.Errorf("VersionAtLeast(%q, 5, 4) expected %v, got %v", version, expected, got)
+		}
+	}
+}
@@ -1,9 +1,7 @@
 package zabbixclient
 
 import (
-	"fmt"
-
-	reflector "github.com/neogan74/zabbix-alertmanager/zabbixprovisioner/zabbixutil"
+	"context"
 )
 
 //Template ...
@@ -15,51 +13,70 @@ type Template struct {
 
 	//Used only for creation.
 	GroupIds HostGroupIDs `json:"groups,omitempty"`
+
+	// Groups filled only by selectGroups
+	Groups HostGroups `json:"-"`
 }
 
-//HostGroupID ...
+//TemplateID ...
 type TemplateID struct {
-	TemplateID string `json:"templateids"`
+	TemplateID string `json:"templateid"`
 }
 
+//TemplateIDs ...
+type TemplateIDs []TemplateID
+
 // Templates ..
 type Templates []Template
 
 //TemplateGet ...
 func (api *API) TemplateGet(params Params) (Templates, error) {
+	return api.TemplateGetContext(context.Background(), params)
+}
+
+//TemplateGetContext is TemplateGet with a context.
+func (api *API) TemplateGetContext(ctx context.Context, params Params) (Templates, error) {
 	var res Templates
 	if _, exist := params["output"]; !exist {
 		params["output"] = "extend"
 	}
-	resp, err := api.CallWithError("template.get", params)
+	resp, err := api.CallWithErrorContext(ctx, "template.get", params)
 	if err != nil {
 		return nil, err
 	}
 
-	reflector.MapsToStructs2(resp.Result.([]interface{}), &res, reflector.Strconv, "json")
+	if err := resp.Decode(&res); err != nil {
+		return nil, err
+	}
 	return res, nil
 }
 
 //TemplateCreate ..
 func (api *API) TemplateCreate(tmpls Templates) error {
-	resp, err := api.CallWithError("template.create", tmpls)
-	fmt.Println(resp)
+	return api.TemplateCreateContext(context.Background(), tmpls)
+}
+
+//TemplateCreateContext is TemplateCreate with a context.
+func (api *API) TemplateCreateContext(ctx context.Context, tmpls Templates) error {
+	resp, err := api.CallWithErrorContext(ctx, "template.create", tmpls)
 	if err != nil {
 		return err
 	}
-	res := resp.Result.(map[string]interface{})
-	tmplids := res["templateids"].([]interface{})
-	fmt.Println(res)
-	fmt.Println(tmplids)
+	tmplids := resultIDs([]Response{resp}, "templateids")
 	for i, tmplid := range tmplids {
-		tmpls[i].TemplateID = tmplid.(string)
+		tmpls[i].TemplateID = tmplid
 	}
 	return nil
 }
 
 //TemplatesUpdate ...
 func (api *API) TemplatesUpdate(tmpls Templates) error {
-	_, err := api.CallWithError("template.update", tmpls)
+	return api.TemplatesUpdateContext(context.Background(), tmpls)
+}
+
+//TemplatesUpdateContext is TemplatesUpdate with a context.
+func (api *API) TemplatesUpdateContext(ctx context.Context, tmpls Templates) error {
+	_, err := api.CallWithErrorContext(ctx, "template.update", tmpls)
 	if err != nil {
 		return err
 	}
@@ -68,11 +85,59 @@ func (api *API) TemplatesUpdate(tmpls Templates) error {
 
 //TemplateUpdate ...
 func (api *API) TemplateUpdate(params Params) (Response, error) {
-	// var res []TemplateID
-	resp, err := api.CallWithError("template.update", params)
+	return api.TemplateUpdateContext(context.Background(), params)
+}
+
+//TemplateUpdateContext is TemplateUpdate with a context.
+func (api *API) TemplateUpdateContext(ctx context.Context, params Params) (Response, error) {
+	resp, err := api.CallWithErrorContext(ctx, "template.update", params)
 	if err != nil {
 		return resp, err
 	}
-	// reflector.MapsToStructs(resp.Result.([]map[string]interface{}), &res, reflector.Strconv, "json")
 	return resp, nil
 }
+
+//TemplatesLinkHosts Wrapper for template.massadd: https://www.zabbix.com/documentation/4.4/manual/api/reference/template/massadd
+// It links the templates to the hosts, keeping the templates already linked to them.
+func (api *API) TemplatesLinkHosts(tmpls TemplateIDs, hostIDs []string) error {
+	return api.TemplatesLinkHostsContext(context.Background(), tmpls, hostIDs)
+}
+
+//TemplatesLinkHostsContext is TemplatesLinkHosts with a context.
+func (api *API) TemplatesLinkHostsContext(ctx context.Context, tmpls TemplateIDs, hostIDs []string) error {
+	hosts := make([]map[string]string, len(hostIDs))
+	for i, id := range hostIDs {
+		hosts[i] = map[string]string{"hostid": id}
+	}
+	_, err := api.CallWithErrorContext(ctx, "template.massadd", Params{"templates": tmpls, "hosts": hosts})
+	return err
+}
+
+//TemplatesDelete Wrapper for template.delete: https://www.zabbix.com/documentation/4.4/manual/api/reference/template/delete
+func (api *API) TemplatesDelete(tmpls Templates) error {
+	return api.TemplatesDeleteContext(context.Background(), tmpls)
+}
+
+//TemplatesDeleteContext is TemplatesDelete with a context.
+func (api *API) TemplatesDeleteContext(ctx context.Context, tmpls Templates) error {
+	ids := make([]string, len(tmpls))
+	for i, tmpl := range tmpls {
+		ids[i] = tmpl.TemplateID
+	}
+
+	responses, err := api.callChunks(ctx, "template.delete", len(ids), func(from, to int) interface{} {
+		return ids[from:to]
+	})
+	if err != nil {
+		return err
+	}
+
+	templateids := resultIDs(responses, "templateids")
+	if len(ids) != len(templateids) {
+		return &ExpectedMore{len(ids), len(templateids)}
+	}
+	for i := range tmpls {
+		tmpls[i].TemplateID = ""
+	}
+	return nil
+}
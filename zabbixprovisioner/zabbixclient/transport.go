@@ -0,0 +1,229 @@
+package zabbixclient
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	requestDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "zabbix_api_request_duration_seconds",
+			Help:    "Duration of Zabbix API calls by method, including the retries",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"method"},
+	)
+
+	requestErrors = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "zabbix_api_request_errors_total",
+			Help: "Number of failed Zabbix API calls by method and error type: transport, http or application",
+		},
+		[]string{"method", "type"},
+	)
+
+	requestRetries = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "zabbix_api_request_retries_total",
+			Help: "Number of retried Zabbix API requests by method",
+		},
+		[]string{"method"},
+	)
+)
+
+//Error types of the zabbix_api_request_errors_total metric
+const (
+	errorTransport   = "transport"
+	errorHTTP        = "http"
+	errorApplication = "application"
+)
+
+//RetryConfig configures the retries of the requests failing with transport errors, 5xx or 429 responses.
+// Only the read methods (*.get, APIInfo.version and user.login) are retried that way, the writes only when the
+// connection to Zabbix failed, as they may have been applied even though no response was received.
+// Zabbix application errors are never retried.
+type RetryConfig struct {
+	// Retries is the number of retries after the first attempt, 0 disables them
+	Retries int
+	// MinBackoff is the wait before the first retry, doubled for every next one
+	MinBackoff time.Duration
+	// MaxBackoff caps the wait, also the one asked by the Retry-After header of 429 responses
+	MaxBackoff time.Duration
+}
+
+//DefaultRetryConfig is used by NewAPI
+var DefaultRetryConfig = RetryConfig{
+	Retries:    3,
+	MinBackoff: 500 * time.Millisecond,
+	MaxBackoff: 10 * time.Second,
+}
+
+//backoff returns the wait before the retry following the attempt, 0 based
+func (c RetryConfig) backoff(attempt int, retryAfter time.Duration) time.Duration {
+	wait := c.MinBackoff
+	for i := 0; i < attempt && (c.MaxBackoff <= 0 || wait < c.MaxBackoff); i++ {
+		wait *= 2
+	}
+	if retryAfter > wait {
+		wait = retryAfter
+	}
+	if c.MaxBackoff > 0 && wait > c.MaxBackoff {
+		wait = c.MaxBackoff
+	}
+	return wait
+}
+
+//HTTPError is returned when Zabbix answers with a non 2xx status code
+type HTTPError struct {
+	StatusCode int
+	Status     string
+	Body       string
+	retryAfter time.Duration
+}
+
+func (e *HTTPError) Error() string {
+	if e.Body == "" {
+		return fmt.Sprintf("unexpected HTTP status %s", e.Status)
+	}
+	return fmt.Sprintf("unexpected HTTP status %s: %s", e.Status, e.Body)
+}
+
+//Temporary reports whether the request may succeed when retried
+func (e *HTTPError) Temporary() bool {
+	return e.StatusCode >= 500 || e.StatusCode == http.StatusTooManyRequests
+}
+
+//maxErrorBody limits the response body kept in HTTPError
+const maxErrorBody = 512
+
+//SetRetry configures the retries of the requests.
+func (api *API) SetRetry(c RetryConfig) {
+	api.retry = c
+}
+
+//SetTimeout sets the timeout of every request attempt, 0 leaves only the timeout of the http.Client and the context.
+func (api *API) SetTimeout(timeout time.Duration) {
+	api.timeout = timeout
+}
+
+//idempotent reports whether calling the method twice has the same effect as calling it once
+func idempotent(method string) bool {
+	return strings.HasSuffix(method, ".get") || method == "APIInfo.version" || method == "user.login"
+}
+
+//notSent reports whether the request failed before it was sent, when the connection couldn't be made
+func notSent(err error) bool {
+	if e, ok := err.(*url.Error); ok {
+		err = e.Err
+	}
+	e, ok := err.(*net.OpError)
+	return ok && e.Op == "dial"
+}
+
+//retryable reports whether the failed attempt may be retried, writes only when the request wasn't sent
+func retryable(err error, idempotent bool) bool {
+	if httpErr, ok := err.(*HTTPError); ok {
+		return idempotent && httpErr.Temporary()
+	}
+	return idempotent || notSent(err)
+}
+
+//send posts the request, retrying it as configured, and returns the response body.
+// idempotent is set when all the methods of the request are read methods.
+func (api *API) send(ctx context.Context, method string, b []byte, auth string, bearer, idempotent bool) ([]byte, error) {
+	start := time.Now()
+	defer func() {
+		requestDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+	}()
+
+	for attempt := 0; ; attempt++ {
+		body, err := api.post(ctx, b, auth, bearer)
+		if err == nil {
+			return body, nil
+		}
+
+		httpErr, isHTTP := err.(*HTTPError)
+		if attempt >= api.retry.Retries || ctx.Err() != nil || !retryable(err, idempotent) {
+			if isHTTP {
+				requestErrors.WithLabelValues(method, errorHTTP).Inc()
+			} else {
+				requestErrors.WithLabelValues(method, errorTransport).Inc()
+			}
+			return nil, err
+		}
+
+		var retryAfter time.Duration
+		if isHTTP {
+			retryAfter = httpErr.retryAfter
+		}
+		wait := api.retry.backoff(attempt, retryAfter)
+		api.printf("Retrying %s in %s: %s", method, wait, err)
+		requestRetries.WithLabelValues(method).Inc()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			requestErrors.WithLabelValues(method, errorTransport).Inc()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+//post makes one attempt of the request
+func (api *API) post(ctx context.Context, b []byte, auth string, bearer bool) ([]byte, error) {
+	if api.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, api.timeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequest("POST", api.url, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.ContentLength = int64(len(b))
+	req.Header.Add("Content-Type", "application/json-rpc")
+	if bearer {
+		req.Header.Set("Authorization", "Bearer "+auth)
+	}
+
+	res, err := api.c.Do(req)
+	if err != nil {
+		api.printf("Error   : %s", err)
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	api.printf("Response (%d): %s", res.StatusCode, body)
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		if len(body) > maxErrorBody {
+			body = body[:maxErrorBody]
+		}
+		e := &HTTPError{StatusCode: res.StatusCode, Status: res.Status, Body: string(bytes.TrimSpace(body))}
+		if seconds, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil && seconds > 0 {
+			e.retryAfter = time.Duration(seconds) * time.Second
+		}
+		return nil, e
+	}
+	return body, nil
+}
@@ -0,0 +1,159 @@
+package zabbixclient_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	zabbix "github.com/neogan74/zabbix-alertmanager/zabbixprovisioner/zabbixclient"
+)
+
+//flakyZabbix answers with the statuses in order, then with the result or the Zabbix error
+type flakyZabbix struct {
+	statuses []int
+	appError bool
+	delay    time.Duration
+	calls    int32
+}
+
+func (f *flakyZabbix) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n := int(atomic.AddInt32(&f.calls, 1))
+	if f.delay > 0 {
+		time.Sleep(f.delay)
+	}
+	if n <= len(f.statuses) {
+		w.Header().Set("Retry-After", "0")
+		http.Error(w, "overloaded", f.statuses[n-1])
+		return
+	}
+	if f.appError {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"jsonrpc": "2.0",
+			"error":   map[string]interface{}{"code": -32602, "message": "Invalid params.", "data": "No permissions."},
+			"id":      1,
+		})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "result": []interface{}{}, "id": 1})
+}
+
+func newRetryingAPI(url string, retries int) *zabbix.API {
+	api := zabbix.NewAPI(url)
+	api.SetRetry(zabbix.RetryConfig{Retries: retries, MinBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond})
+	return api
+}
+
+func TestRetryTransient(t *testing.T) {
+	f := &flakyZabbix{statuses: []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}}
+	ts := httptest.NewServer(f)
+	defer ts.Close()
+
+	api := newRetryingAPI(ts.URL, 3)
+	if _, err := api.HostGroupsGet(zabbix.Params{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calls := atomic.LoadInt32(&f.calls); calls != 3 {
+		t.Errorf("expected 3 requests, got %d", calls)
+	}
+}
+
+func TestRetryExhausted(t *testing.T) {
+	f := &flakyZabbix{statuses: []int{500, 502, 503, 504}}
+	ts := httptest.NewServer(f)
+	defer ts.Close()
+
+	api := newRetryingAPI(ts.URL, 2)
+	_, err := api.CallWithError("hostgroup.get", zabbix.Params{})
+	httpErr, ok := err.(*zabbix.HTTPError)
+	if !ok {
+		t.Fatalf("expected an HTTP error, got %v", err)
+	}
+	if httpErr.StatusCode != 503 {
+		t.Errorf("expected the status of the last attempt, got %d", httpErr.StatusCode)
+	}
+	if calls := atomic.LoadInt32(&f.calls); calls != 3 {
+		t.Errorf("expected 3 requests, got %d", calls)
+	}
+}
+
+func TestNoRetry(t *testing.T) {
+	for name, f := range map[string]*flakyZabbix{
+		"client error":      {statuses: []int{http.StatusForbidden}},
+		"application error": {appError: true},
+	} {
+		ts := httptest.NewServer(f)
+		api := newRetryingAPI(ts.URL, 3)
+		if _, err := api.CallWithError("hostgroup.get", zabbix.Params{}); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+		if calls := atomic.LoadInt32(&f.calls); calls != 1 {
+			t.Errorf("%s: expected 1 request, got %d", name, calls)
+		}
+		ts.Close()
+	}
+}
+
+func TestCallContextCanceled(t *testing.T) {
+	f := &flakyZabbix{delay: 50 * time.Millisecond}
+	ts := httptest.NewServer(f)
+	defer ts.Close()
+
+	api := newRetryingAPI(ts.URL, 3)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := api.HostGroupsGetContext(ctx, zabbix.Params{}); err == nil {
+		t.Fatal("expected an error")
+	}
+	if calls := atomic.LoadInt32(&f.calls); calls != 1 {
+		t.Errorf("expected no retry after the context ended, got %d requests", calls)
+	}
+}
+
+func TestTimeoutRetried(t *testing.T) {
+	f := &flakyZabbix{delay: 30 * time.Millisecond}
+	ts := httptest.NewServer(f)
+	defer ts.Close()
+
+	api := newRetryingAPI(ts.URL, 1)
+	api.SetTimeout(5 * time.Millisecond)
+	if _, err := api.CallWithError("hostgroup.get", zabbix.Params{}); err == nil {
+		t.Fatal("expected a timeout")
+	}
+	if calls := atomic.LoadInt32(&f.calls); calls != 2 {
+		t.Errorf("expected the timed out request to be retried once, got %d requests", calls)
+	}
+}
+
+func TestTimeoutWriteNotRetried(t *testing.T) {
+	f := &flakyZabbix{delay: 30 * time.Millisecond}
+	ts := httptest.NewServer(f)
+	defer ts.Close()
+
+	api := newRetryingAPI(ts.URL, 3)
+	api.SetTimeout(5 * time.Millisecond)
+	if _, err := api.CallWithError("item.create", zabbix.Params{}); err == nil {
+		t.Fatal("expected a timeout")
+	}
+	if calls := atomic.LoadInt32(&f.calls); calls != 1 {
+		t.Errorf("expected the timed out create not to be retried, got %d requests", calls)
+	}
+}
+
+func TestWriteNotRetried(t *testing.T) {
+	f := &flakyZabbix{statuses: []int{http.StatusServiceUnavailable}}
+	ts := httptest.NewServer(f)
+	defer ts.Close()
+
+	api := newRetryingAPI(ts.URL, 3)
+	calls := []zabbix.BatchCall{{Method: "item.get", Params: zabbix.Params{}}, {Method: "item.create", Params: zabbix.Params{}}}
+	if _, err := api.CallBatch(calls); err == nil {
+		t.Fatal("expected an error")
+	}
+	if n := atomic.LoadInt32(&f.calls); n != 1 {
+		t.Errorf("expected the batch with a create not to be retried, got %d requests", n)
+	}
+}
@@ -1,12 +1,16 @@
 package zabbixclient
 
 import (
-	reflector "github.com/devopyio/zabbix-alertmanager/zabbixprovisioner/zabbixutil"
+	"context"
+	"encoding/json"
 )
 
 //PriorityType ...
 type PriorityType int
 
+//TriggerValueType ...
+type TriggerValueType int
+
 //NotClassified ...
 const (
 	NotClassified PriorityType = 0
@@ -19,8 +23,8 @@ const (
 	Enabled  StatusType = 0
 	Disabled StatusType = 1
 
-	OK      ValueType = 0
-	Problem ValueType = 1
+	OK      TriggerValueType = 0
+	Problem TriggerValueType = 1
 )
 
 //Trigger https://www.zabbix.com/documentation/4.4/manual/appendix/api/item/definitions
@@ -30,9 +34,26 @@ type Trigger struct {
 	Expression  string       `json:"expression"`
 	Comments    string       `json:"comments"`
 	URL         string       `json:"url"`
-	ManualClose int32        `json:"manual_close"`
+	ManualClose Int          `json:"manual_close"`
 	Priority    PriorityType `json:"priority"`
 	Status      StatusType   `json:"status"`
+	Tags        []Tag        `json:"tags"`
+
+	// Hosts filled only by selectHosts, templates are included
+	Hosts Hosts `json:"-"`
+	// Groups filled only by selectGroups, the groups of the hosts
+	Groups HostGroups `json:"-"`
+	// Value state of the trigger, OK or Problem, read only
+	Value TriggerValueType `json:"-"`
+}
+
+//MarshalJSON always sends the tags, trigger.update removes all the tags of the trigger with an empty list
+func (t Trigger) MarshalJSON() ([]byte, error) {
+	type trigger Trigger
+	if t.Tags == nil {
+		t.Tags = []Tag{}
+	}
+	return json.Marshal(trigger(t))
 }
 
 //Tag ...
@@ -46,37 +67,60 @@ type Triggers []Trigger
 
 //TriggersGet Wrapper for item.get https://www.zabbix.com/documentation/4.4/manual/appendix/api/item/get
 func (api *API) TriggersGet(params Params) (Triggers, error) {
+	return api.TriggersGetContext(context.Background(), params)
+}
+
+//TriggersGetContext is TriggersGet with a context.
+func (api *API) TriggersGetContext(ctx context.Context, params Params) (Triggers, error) {
 	var res Triggers
 	if _, present := params["output"]; !present {
 		params["output"] = "extend"
 	}
-	response, err := api.CallWithError("trigger.get", params)
+	response, err := api.CallWithErrorContext(ctx, "trigger.get", params)
 	if err != nil {
 		return nil, err
 	}
 
-	reflector.MapsToStructs2(response.Result.([]interface{}), &res, reflector.Strconv, "json")
+	if err := response.Decode(&res); err != nil {
+		return nil, err
+	}
 	return res, nil
 }
 
 //TriggersCreate Wrapper for item.create: https://www.zabbix.com/documentation/4.4/manual/appendix/api/item/create
 func (api *API) TriggersCreate(triggers Triggers) error {
-	response, err := api.CallWithError("trigger.create", triggers)
+	return api.TriggersCreateContext(context.Background(), triggers)
+}
+
+//TriggersCreateContext is TriggersCreate with a context.
+func (api *API) TriggersCreateContext(ctx context.Context, triggers Triggers) error {
+	responses, err := api.callChunks(ctx, "trigger.create", len(triggers), func(from, to int) interface{} {
+		return triggers[from:to]
+	})
+	triggerids := resultIDs(responses, "triggerids")
 	if err != nil {
+		// the triggers of the successful requests of the batch are removed again
+		if len(triggerids) != 0 {
+			if err := api.TriggersDeleteByIDsContext(ctx, triggerids); err != nil {
+				api.printf("Error deleting the triggers created by the failed batch: %s", err)
+			}
+		}
 		return err
 	}
-
-	result := response.Result.(map[string]interface{})
-	triggerids := result["triggerids"].([]interface{})
 	for i, id := range triggerids {
-		triggers[i].TriggerID = id.(string)
+		triggers[i].TriggerID = id
 	}
 	return nil
 }
 
 //TriggersUpdate Wrapper for item.update: https://www.zabbix.com/documentation/4.4/manual/appendix/api/item/update
 func (api *API) TriggersUpdate(triggers Triggers) error {
-	_, err := api.CallWithError("trigger.update", triggers)
+	return api.TriggersUpdateContext(context.Background(), triggers)
+}
+
+//TriggersUpdateContext is TriggersUpdate with a context.
+func (api *API) TriggersUpdateContext(ctx context.Context, triggers Triggers) error {
+	_, err := api.CallWithErrorContext(ctx, "trigger.update", triggers)
 	if err != nil {
 		return err
 	}
@@ -86,12 +130,17 @@ func (api *API) TriggersUpdate(triggers Triggers) error {
 //TriggersDelete Wrapper for item.delete: https://www.zabbix.com/documentation/4.4/manual/appendix/api/item/delete
 // Cleans ItemId in all items elements if call succeed.
 func (api *API) TriggersDelete(triggers Triggers) error {
+	return api.TriggersDeleteContext(context.Background(), triggers)
+}
+
+//TriggersDeleteContext is TriggersDelete with a context.
+func (api *API) TriggersDeleteContext(ctx context.Context, triggers Triggers) error {
 	ids := make([]string, len(triggers))
 	for i, trigger := range triggers {
 		ids[i] = trigger.TriggerID
 	}
 
-	err := api.TriggersDeleteByIDs(ids)
+	err := api.TriggersDeleteByIDsContext(ctx, ids)
 	if err != nil {
 		return err
 	}
@@ -105,19 +154,20 @@ func (api *API) TriggersDelete(triggers Triggers) error {
 
 //TriggersDeleteByIDs Wrapper for item.delete: https://www.zabbix.com/documentation/2.2/manual/appendix/api/item/delete
 func (api *API) TriggersDeleteByIDs(ids []string) error {
-	response, err := api.CallWithError("trigger.delete", ids)
+	return api.TriggersDeleteByIDsContext(context.Background(), ids)
+}
+
+//TriggersDeleteByIDsContext is TriggersDeleteByIDs with a context.
+func (api *API) TriggersDeleteByIDsContext(ctx context.Context, ids []string) error {
+	responses, err := api.callChunks(ctx, "trigger.delete", len(ids), func(from, to int) interface{} {
+		return ids[from:to]
+	})
 	if err != nil {
 		return err
 	}
 
-	result := response.Result.(map[string]interface{})
-	triggerids1, ok := result["triggerids"].([]interface{})
-	l := len(triggerids1)
-	if !ok {
-		// some versions actually return map there
-		triggerids2 := result["triggerids"].(map[string]interface{})
-		l = len(triggerids2)
-	}
+	// some versions actually return map there
+	l := len(resultIDs(responses, "triggerids"))
 	if len(ids) != l {
 		err = &ExpectedMore{len(ids), l}
 		return err
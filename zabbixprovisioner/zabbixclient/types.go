@@ -0,0 +1,233 @@
+package zabbixclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+//Zabbix encodes the numbers of its objects as strings in the responses, the types below decode
+// both strings and numbers. They are encoded as numbers, which Zabbix accepts in the requests.
+
+//Int integer property of a Zabbix object
+type Int int
+
+//UnmarshalJSON decodes a number or a string holding a number
+func (i *Int) UnmarshalJSON(data []byte) error { return unmarshalInt(data, (*int)(i)) }
+
+//UnmarshalJSON decodes a number or a string holding a number
+func (t *AvailableType) UnmarshalJSON(data []byte) error { return unmarshalInt(data, (*int)(t)) }
+
+//UnmarshalJSON decodes a number or a string holding a number
+func (t *StatusType) UnmarshalJSON(data []byte) error { return unmarshalInt(data, (*int)(t)) }
+
+//UnmarshalJSON decodes a number or a string holding a number
+func (t *InventoryType) UnmarshalJSON(data []byte) error { return unmarshalInt(data, (*int)(t)) }
+
+//UnmarshalJSON decodes a number or a string holding a number
+func (t *ItemType) UnmarshalJSON(data []byte) error { return unmarshalInt(data, (*int)(t)) }
+
+//UnmarshalJSON decodes a number or a string holding a number
+func (t *ValueType) UnmarshalJSON(data []byte) error { return unmarshalInt(data, (*int)(t)) }
+
+//UnmarshalJSON decodes a number or a string holding a number
+func (t *TriggerValueType) UnmarshalJSON(data []byte) error { return unmarshalInt(data, (*int)(t)) }
+
+//UnmarshalJSON decodes a number or a string holding a number
+func (t *DataType) UnmarshalJSON(data []byte) error { return unmarshalInt(data, (*int)(t)) }
+
+//UnmarshalJSON decodes a number or a string holding a number
+func (t *DeltaType) UnmarshalJSON(data []byte) error { return unmarshalInt(data, (*int)(t)) }
+
+//UnmarshalJSON decodes a number or a string holding a number
+func (t *PriorityType) UnmarshalJSON(data []byte) error { return unmarshalInt(data, (*int)(t)) }
+
+//UnmarshalJSON decodes a number or a string holding a number
+func (t *InterfaceType) UnmarshalJSON(data []byte) error { return unmarshalInt(data, (*int)(t)) }
+
+//UnmarshalJSON decodes a number or a string holding a number
+func (t *InternalType) UnmarshalJSON(data []byte) error { return unmarshalInt(data, (*int)(t)) }
+
+//unmarshalInt decodes a number or a string holding a number, null leaves v unchanged and an empty string is 0
+func unmarshalInt(data []byte, v *int) error {
+	s := string(data)
+	if s == "null" {
+		return nil
+	}
+	if len(data) != 0 && data[0] == '"' {
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		if s == "" {
+			*v = 0
+			return nil
+		}
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return fmt.Errorf("invalid Zabbix integer %s", data)
+	}
+	*v = n
+	return nil
+}
+
+//isEmptyList reports whether the JSON value is an empty list, returned by Zabbix for missing objects
+func isEmptyList(data []byte) bool {
+	return bytes.Equal(bytes.Join(bytes.Fields(data), nil), []byte("[]"))
+}
+
+//HostInventory inventory fields of a host, Zabbix returns an empty list when the inventory is disabled
+type HostInventory map[string]string
+
+//UnmarshalJSON decodes the inventory fields, an empty list is a nil inventory
+func (i *HostInventory) UnmarshalJSON(data []byte) error {
+	if isEmptyList(data) || string(data) == "null" {
+		*i = nil
+		return nil
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	inventory := make(HostInventory, len(fields))
+	for field, value := range fields {
+		var s string
+		if err := json.Unmarshal(value, &s); err != nil {
+			// inventory_mode is a number in some versions
+			s = string(value)
+		}
+		inventory[field] = s
+	}
+	*i = inventory
+	return nil
+}
+
+//objectIDs ids returned by the create and delete methods, some versions return a map instead of a list
+type objectIDs []string
+
+func (ids *objectIDs) UnmarshalJSON(data []byte) error {
+	var values []json.RawMessage
+	if err := json.Unmarshal(data, &values); err != nil {
+		var byKey map[string]json.RawMessage
+		if err := json.Unmarshal(data, &byKey); err != nil {
+			return err
+		}
+		for _, value := range byKey {
+			values = append(values, value)
+		}
+	}
+	res := make(objectIDs, len(values))
+	for i, value := range values {
+		var n Int
+		if err := json.Unmarshal(value, &res[i]); err != nil {
+			if err := n.UnmarshalJSON(value); err != nil {
+				return err
+			}
+			res[i] = strconv.Itoa(int(n))
+		}
+	}
+	*ids = res
+	return nil
+}
+
+//The objects below decode the properties requested by the select parameters of the get methods
+// into the fields which aren't sent back to Zabbix.
+
+//UnmarshalJSON decodes the host with its groups and parent templates
+func (h *Host) UnmarshalJSON(data []byte) error {
+	type host Host
+	var v struct {
+		host
+		Groups          HostGroups `json:"groups"`
+		ParentTemplates Templates  `json:"parentTemplates"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*h = Host(v.host)
+	h.Groups = v.Groups
+	h.ParentTemplates = v.ParentTemplates
+	return nil
+}
+
+//UnmarshalJSON decodes the interface with its availability, the details are sent back only for SNMP interfaces
+func (i *HostInterface) UnmarshalJSON(data []byte) error {
+	type hostInterface HostInterface
+	var v struct {
+		hostInterface
+		Details   json.RawMessage `json:"details"`
+		Available AvailableType   `json:"available"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*i = HostInterface(v.hostInterface)
+	i.Available = v.Available
+	if len(v.Details) != 0 && !isEmptyList(v.Details) && string(v.Details) != "null" {
+		i.Details = &HostInterfaceDetails{}
+		if err := json.Unmarshal(v.Details, i.Details); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//UnmarshalJSON decodes the template with its groups
+func (t *Template) UnmarshalJSON(data []byte) error {
+	type template Template
+	var v struct {
+		template
+		Groups HostGroups `json:"groups"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*t = Template(v.template)
+	t.Groups = v.Groups
+	return nil
+}
+
+//UnmarshalJSON decodes the item with its applications
+func (i *Item) UnmarshalJSON(data []byte) error {
+	type item Item
+	var v struct {
+		item
+		Applications json.RawMessage `json:"applications"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*i = Item(v.item)
+	if len(v.Applications) == 0 {
+		return nil
+	}
+	// selectApplications returns the objects, the ids are only sent
+	var applications []json.RawMessage
+	if err := json.Unmarshal(v.Applications, &applications); err != nil {
+		return err
+	}
+	if len(applications) != 0 && bytes.HasPrefix(bytes.TrimSpace(applications[0]), []byte(`"`)) {
+		return json.Unmarshal(v.Applications, &i.ApplicationIds)
+	}
+	return json.Unmarshal(v.Applications, &i.ItemApplications)
+}
+
+//UnmarshalJSON decodes the trigger with its hosts, groups and value
+func (t *Trigger) UnmarshalJSON(data []byte) error {
+	type trigger Trigger
+	var v struct {
+		trigger
+		Hosts  Hosts            `json:"hosts"`
+		Groups HostGroups       `json:"groups"`
+		Value  TriggerValueType `json:"value"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*t = Trigger(v.trigger)
+	t.Hosts = v.Hosts
+	t.Groups = v.Groups
+	t.Value = v.Value
+	return nil
+}
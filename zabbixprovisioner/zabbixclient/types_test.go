@@ -0,0 +1,234 @@
+package zabbixclient_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	zabbix "github.com/neogan74/zabbix-alertmanager/zabbixprovisioner/zabbixclient"
+)
+
+//recordedZabbix answers the get methods with the results recorded in testdata/<method>.json
+func recordedZabbix(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Method string `json:"method"`
+			ID     int    `json:"id"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		result, err := ioutil.ReadFile(filepath.Join("testdata", req.Method+".json"))
+		if err != nil {
+			t.Errorf("unexpected method %s", req.Method)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "result": json.RawMessage(result), "id": req.ID})
+	}))
+}
+
+//checkRoundTrip encodes the objects like they are sent to Zabbix, decodes them again into the same type
+// and checks they are encoded the same way
+func checkRoundTrip(t *testing.T, objects interface{}) {
+	data, err := json.Marshal(objects)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	decoded := reflect.New(reflect.TypeOf(objects))
+	if err := json.Unmarshal(data, decoded.Interface()); err != nil {
+		t.Fatalf("unexpected error decoding %s: %v", data, err)
+	}
+	again, err := json.Marshal(decoded.Elem().Interface())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(again) != string(data) {
+		t.Errorf("expected %s after a round trip, got %s", data, again)
+	}
+}
+
+func TestHostsGetRecorded(t *testing.T) {
+	ts := recordedZabbix(t)
+	defer ts.Close()
+
+	hosts, err := zabbix.NewAPI(ts.URL).HostsGet(zabbix.Params{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(hosts) != 2 {
+		t.Fatalf("expected 2 hosts, got %d", len(hosts))
+	}
+
+	host := hosts[0]
+	if host.HostID != "10084" || host.Host != "node1.example.com" || host.Name != "node1" ||
+		host.Status != zabbix.Monitored || host.Available != zabbix.Available || host.InventoryMode != zabbix.InventoryManual {
+		t.Errorf("unexpected host %+v", host)
+	}
+	if host.Inventory["tag"] != "prometheus" || host.Inventory["location"] != "dc1" {
+		t.Errorf("unexpected inventory %v", host.Inventory)
+	}
+	tags := []zabbix.Tag{{Tag: "managed_by", Value: "zal"}, {Tag: "env", Value: "prod"}}
+	if !reflect.DeepEqual(host.Tags, tags) {
+		t.Errorf("expected tags %v, got %v", tags, host.Tags)
+	}
+	interfaces := zabbix.HostInterfaces{
+		{DNS: "node1.example.com", Main: 1, Port: "10050", Type: zabbix.Agent, Available: zabbix.Available},
+		{IP: "10.0.0.1", Main: 1, Port: "161", Type: zabbix.SNMP, UseIP: 1,
+			Details: &zabbix.HostInterfaceDetails{Version: "2", Bulk: "1", Community: "{$SNMP_COMMUNITY}"}},
+	}
+	if !reflect.DeepEqual(host.Interfaces, interfaces) {
+		t.Errorf("expected interfaces %+v, got %+v", interfaces, host.Interfaces)
+	}
+	if len(host.Groups) != 1 || host.Groups[0].GroupID != "15" || host.Groups[0].Name != "Prometheus" {
+		t.Errorf("unexpected groups %+v", host.Groups)
+	}
+	templates := zabbix.Templates{{TemplateID: "10290", Name: "node"}, {TemplateID: "10291", Name: "blackbox"}}
+	if !reflect.DeepEqual(host.ParentTemplates, templates) {
+		t.Errorf("expected parent templates %+v, got %+v", templates, host.ParentTemplates)
+	}
+
+	disabled := hosts[1]
+	if disabled.Status != zabbix.Unmonitored || disabled.InventoryMode != zabbix.InventoryDisabled || disabled.Inventory != nil {
+		t.Errorf("unexpected host %+v", disabled)
+	}
+
+	// The selected groups and templates aren't sent back
+	for i := range hosts {
+		hosts[i].Groups = nil
+		hosts[i].ParentTemplates = nil
+	}
+	checkRoundTrip(t, hosts)
+}
+
+func TestItemsGetRecorded(t *testing.T) {
+	ts := recordedZabbix(t)
+	defer ts.Close()
+
+	items, err := zabbix.NewAPI(ts.URL).ItemsGet(zabbix.Params{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := zabbix.Items{
+		{
+			ItemID: "29181", Delay: "0", HostID: "10290", InterfaceID: "0", Key: "prometheus.node_down", Name: "NodeDown",
+			Type: zabbix.ZabbixTrapper, ValueType: zabbix.Unsigned, Description: "node is down\nmanaged_by: zal",
+			Status: zabbix.Enabled, History: "90d", Trends: "365d",
+			ItemApplications: zabbix.Applications{{ApplicationID: "1042", Name: "Prometheus"}},
+		},
+		{
+			ItemID: "29182", Delay: "0", HostID: "10290", Key: "prometheus.disk_full", Name: "DiskFull",
+			Type: zabbix.ZabbixTrapper, ValueType: zabbix.Text, Status: zabbix.Disabled,
+			History: "{$HISTORY}", Trends: "0", TrapperHosts: "10.0.0.0/8",
+			ItemApplications: zabbix.Applications{},
+		},
+	}
+	if !reflect.DeepEqual(items, expected) {
+		t.Fatalf("expected items %+v, got %+v", expected, items)
+	}
+
+	// Items are sent back with the ids of their applications
+	for i := range items {
+		for _, app := range items[i].ItemApplications {
+			items[i].ApplicationIds = append(items[i].ApplicationIds, app.ApplicationID)
+		}
+		items[i].ItemApplications = nil
+	}
+	checkRoundTrip(t, items)
+}
+
+func TestTriggersGetRecorded(t *testing.T) {
+	ts := recordedZabbix(t)
+	defer ts.Close()
+
+	triggers, err := zabbix.NewAPI(ts.URL).TriggersGet(zabbix.Params{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := zabbix.Triggers{{
+		TriggerID:   "16043",
+		Description: "NodeDown",
+		Expression:  "{node:prometheus.node_down.last()}>0",
+		Comments:    "node is down",
+		URL:         "http://prometheus.example.com/graph",
+		ManualClose: 1,
+		Priority:    zabbix.High,
+		Status:      zabbix.Enabled,
+		Tags:        []zabbix.Tag{{Tag: "managed_by", Value: "zal"}, {Tag: "severity", Value: "critical"}},
+		Hosts:       zabbix.Hosts{{HostID: "10290", Host: "node", Name: "node", Status: 3}},
+		Groups:      zabbix.HostGroups{{GroupID: "16", Name: "Templates/Prometheus"}},
+		Value:       zabbix.Problem,
+	}}
+	if !reflect.DeepEqual(triggers, expected) {
+		t.Fatalf("expected triggers %+v, got %+v", expected, triggers)
+	}
+
+	triggers[0].Hosts = nil
+	triggers[0].Groups = nil
+	triggers[0].Value = zabbix.OK
+	checkRoundTrip(t, triggers)
+}
+
+func TestTriggerWithoutTags(t *testing.T) {
+	// an empty list removes all the tags of the trigger on update
+	data, err := json.Marshal(zabbix.Triggers{{TriggerID: "16043"}, {TriggerID: "16044", Tags: []zabbix.Tag{}}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Count(string(data), `"tags":[]`) != 2 {
+		t.Errorf("expected the empty tags to be sent, got %s", data)
+	}
+}
+
+func TestTemplatesGroupsApplicationsGetRecorded(t *testing.T) {
+	ts := recordedZabbix(t)
+	defer ts.Close()
+	api := zabbix.NewAPI(ts.URL)
+
+	templates, err := api.TemplateGet(zabbix.Params{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expectedTemplates := zabbix.Templates{{
+		TemplateID:  "10290",
+		Name:        "node",
+		DisplayName: "node",
+		Description: "managed_by: zal",
+		Groups:      zabbix.HostGroups{{GroupID: "16", Name: "Templates/Prometheus"}},
+	}}
+	if !reflect.DeepEqual(templates, expectedTemplates) {
+		t.Errorf("expected templates %+v, got %+v", expectedTemplates, templates)
+	}
+
+	groups, err := api.HostGroupsGet(zabbix.Params{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expectedGroups := zabbix.HostGroups{
+		{GroupID: "4", Name: "Zabbix servers", Internal: zabbix.NotInternal},
+		{GroupID: "5", Name: "Discovered hosts", Internal: zabbix.Internal},
+	}
+	if !reflect.DeepEqual(groups, expectedGroups) {
+		t.Errorf("expected host groups %+v, got %+v", expectedGroups, groups)
+	}
+	checkRoundTrip(t, groups)
+
+	apps, err := api.ApplicationsGet(zabbix.Params{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expectedApps := zabbix.Applications{{ApplicationID: "1042", HostID: "10290", Name: "Prometheus"}}
+	if !reflect.DeepEqual(apps, expectedApps) {
+		t.Errorf("expected applications %+v, got %+v", expectedApps, apps)
+	}
+}
+
+func TestDecodeInvalidNumber(t *testing.T) {
+	var item zabbix.Item
+	if err := json.Unmarshal([]byte(`{"itemid": "1", "type": "trapper"}`), &item); err == nil {
+		t.Error("expected an error for a type which isn't a number")
+	}
+}
@@ -0,0 +1,254 @@
+package zabbixsvc
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/neogan74/zabbix-alertmanager/zabbixprovisioner/provisioner"
+	zabbix "github.com/neogan74/zabbix-alertmanager/zabbixprovisioner/zabbixclient"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	log "github.com/sirupsen/logrus"
+)
+
+//AckSilenceCreator creator of the silences of the acknowledged problems
+const AckSilenceCreator = "zal ack-sync"
+
+//DefaultAckSilenceDuration how long the silences of the acknowledged problems last, they are extended while
+// the problems stay open
+const DefaultAckSilenceDuration = time.Hour
+
+var (
+	ackSilences = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "ack_silences",
+			Help: "Current number of Alertmanager silences created for acknowledged Zabbix problems",
+		},
+	)
+
+	ackSyncErrorsTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "ack_sync_errors_total",
+			Help: "Current number of failed synchronizations of the acknowledged problems into silences",
+		},
+	)
+)
+
+//ackEventRegexp finds the Zabbix event in the comment of the silences created for the problems
+var ackEventRegexp = regexp.MustCompile(`Zabbix event (\d+)`)
+
+//AckSync silences in Alertmanager the alerts of the acknowledged Zabbix problems of the triggers managed by zal,
+// and expires the silences when the problems are closed.
+type AckSync struct {
+	API          *zabbix.API
+	Alertmanager *AlertmanagerClient
+	// HostLabel alert label matched with the Zabbix host of the problem, none when empty
+	HostLabel string
+	// TagLabels alert labels matched with the values of the problem tags, by tag
+	TagLabels map[string]string
+	// Duration of the silences, DefaultAckSilenceDuration when 0
+	Duration time.Duration
+}
+
+//Run synchronizes the acknowledged problems every interval until stop is closed
+func (s *AckSync) Run(interval time.Duration, stop <-chan struct{}) {
+	runEvery(interval, stop, func(ctx context.Context) {
+		if err := s.Sync(ctx); err != nil {
+			ackSyncErrorsTotal.Inc()
+			log.Errorf("error synchronizing the acknowledged problems into silences: %v", err)
+		}
+	})
+}
+
+//Sync creates, extends and expires the silences of the acknowledged problems once
+func (s *AckSync) Sync(ctx context.Context) error {
+	desired, err := s.desiredSilences(ctx)
+	if err != nil {
+		return err
+	}
+
+	silences, err := s.Alertmanager.Silences(ctx)
+	if err != nil {
+		return err
+	}
+
+	duration := s.Duration
+	if duration == 0 {
+		duration = DefaultAckSilenceDuration
+	}
+	now := time.Now()
+
+	found := map[string]bool{}
+	var errs []string
+	for _, silence := range silences {
+		if silence.CreatedBy != AckSilenceCreator || (silence.Status.State != SilenceActive && silence.Status.State != SilencePending) {
+			continue
+		}
+		eventID := ""
+		if m := ackEventRegexp.FindStringSubmatch(silence.Comment); m != nil {
+			eventID = m[1]
+		}
+		want, ok := desired[eventID]
+		if !ok || found[eventID] {
+			if err := s.Alertmanager.ExpireSilence(ctx, silence.ID); err != nil {
+				errs = append(errs, err.Error())
+				continue
+			}
+			log.Infof("expired silence %s of closed Zabbix event %s", silence.ID, eventID)
+			continue
+		}
+		found[eventID] = true
+		if silence.EndsAt.Sub(now) > duration/2 && silence.Comment == want.Comment && equalMatchers(silence.Matchers, want.Matchers) {
+			continue
+		}
+		want.ID = silence.ID
+		want.StartsAt = silence.StartsAt
+		want.EndsAt = now.Add(duration)
+		if _, err := s.Alertmanager.PutSilence(ctx, want); err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		log.Debugf("extended silence %s of Zabbix event %s", silence.ID, eventID)
+	}
+
+	eventIDs := make([]string, 0, len(desired))
+	for eventID := range desired {
+		eventIDs = append(eventIDs, eventID)
+	}
+	sort.Strings(eventIDs)
+	for _, eventID := range eventIDs {
+		if found[eventID] {
+			continue
+		}
+		silence := desired[eventID]
+		silence.StartsAt = now
+		silence.EndsAt = now.Add(duration)
+		id, err := s.Alertmanager.PutSilence(ctx, silence)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		log.Infof("created silence %s for acknowledged Zabbix event %s", id, eventID)
+	}
+
+	ackSilences.Set(float64(len(desired)))
+	if len(errs) != 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+//desiredSilences returns the silences of the acknowledged open problems of the triggers managed by zal, by event
+func (s *AckSync) desiredSilences(ctx context.Context) (map[string]Silence, error) {
+	problems, err := s.API.ProblemsGetContext(ctx, zabbix.Params{
+		"source":             zabbix.EventSourceTrigger,
+		"object":             zabbix.EventObjectTrigger,
+		"acknowledged":       true,
+		"selectAcknowledges": "extend",
+		"selectTags":         "extend",
+		// operator 1 matches the tag value exactly
+		"tags": []map[string]interface{}{{"tag": provisioner.ManagedTagName, "value": provisioner.ManagedTagValue, "operator": 1}},
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "can't get the acknowledged problems")
+	}
+
+	hosts := map[string]string{}
+	if s.HostLabel != "" && len(problems) != 0 {
+		triggerIDs := make([]string, len(problems))
+		for i, problem := range problems {
+			triggerIDs[i] = problem.ObjectID
+		}
+		triggers, err := s.API.TriggersGetContext(ctx, zabbix.Params{
+			"output":      []string{"triggerid"},
+			"triggerids":  triggerIDs,
+			"selectHosts": []string{"host"},
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "can't get the triggers of the acknowledged problems")
+		}
+		for _, trigger := range triggers {
+			if len(trigger.Hosts) != 0 {
+				hosts[trigger.TriggerID] = trigger.Hosts[0].Host
+			}
+		}
+	}
+
+	desired := map[string]Silence{}
+	for _, problem := range problems {
+		if !provisioner.IsManaged(problem.Tags) {
+			continue
+		}
+		matchers := []Matcher{{Name: "alertname", Value: alertName(problem.Name)}}
+		if s.HostLabel != "" {
+			host, ok := hosts[problem.ObjectID]
+			if !ok {
+				log.Warnf("can't find the host of Zabbix event %s, skipping it", problem.EventID)
+				continue
+			}
+			matchers = append(matchers, Matcher{Name: s.HostLabel, Value: host})
+		}
+		for _, tag := range problem.Tags {
+			if label, ok := s.TagLabels[tag.Tag]; ok {
+				matchers = append(matchers, Matcher{Name: label, Value: tag.Value})
+			}
+		}
+		sort.Slice(matchers[1:], func(i, j int) bool { return matchers[i+1].Name < matchers[j+1].Name })
+
+		desired[problem.EventID] = Silence{
+			Matchers:  matchers,
+			CreatedBy: AckSilenceCreator,
+			Comment:   ackComment(problem),
+		}
+	}
+	return desired, nil
+}
+
+//alertName the alert name of the problem, zal prov suffixes the names of the no data triggers
+func alertName(problemName string) string {
+	if i := strings.Index(problemName, " - no data for the last "); i != -1 {
+		return problemName[:i]
+	}
+	return problemName
+}
+
+//ackComment comment of the silence with the last acknowledgement of the problem, Zabbix returns them newest first
+func ackComment(problem zabbix.ProblemEvent) string {
+	var ack zabbix.Acknowledge
+	for _, a := range problem.Acknowledges {
+		if a.Action&zabbix.AckAcknowledge != 0 {
+			ack = a
+			break
+		}
+	}
+	comment := fmt.Sprintf("Acknowledged in Zabbix by %s", ack.User())
+	if ack.Message != "" {
+		comment = fmt.Sprintf("%s: %s", comment, ack.Message)
+	}
+	return fmt.Sprintf("%s\nZabbix event %s, %s", comment, problem.EventID, provisioner.ManagedMarker)
+}
+
+//equalMatchers compares the matchers of an existing silence with the wanted ones, regardless of their order
+func equalMatchers(existing, want []Matcher) bool {
+	if len(existing) != len(want) {
+		return false
+	}
+	names := map[string]string{}
+	for _, m := range existing {
+		if m.IsRegex || !m.Equal() {
+			return false
+		}
+		names[m.Name] = m.Value
+	}
+	for _, m := range want {
+		if v, ok := names[m.Name]; !ok || v != m.Value {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,190 @@
+package zabbixsvc_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	zabbix "github.com/neogan74/zabbix-alertmanager/zabbixprovisioner/zabbixclient"
+	"github.com/neogan74/zabbix-alertmanager/zabbixsender/zabbixsvc"
+)
+
+//fakeAlertmanager Alertmanager API keeping the silences in memory
+type fakeAlertmanager struct {
+	mu       sync.Mutex
+	lastID   int
+	silences map[string]zabbixsvc.Silence
+	puts     int
+}
+
+func (f *fakeAlertmanager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case r.Method == "GET" && r.URL.Path == "/api/v2/silences":
+		silences := []zabbixsvc.Silence{}
+		for _, silence := range f.silences {
+			silences = append(silences, silence)
+		}
+		json.NewEncoder(w).Encode(silences)
+	case r.Method == "POST" && r.URL.Path == "/api/v2/silences":
+		var silence zabbixsvc.Silence
+		json.NewDecoder(r.Body).Decode(&silence)
+		f.puts++
+		if silence.ID == "" {
+			f.lastID++
+			silence.ID = fmt.Sprint(f.lastID)
+		}
+		silence.Status.State = zabbixsvc.SilenceActive
+		f.silences[silence.ID] = silence
+		json.NewEncoder(w).Encode(map[string]string{"silenceID": silence.ID})
+	case r.Method == "DELETE" && strings.HasPrefix(r.URL.Path, "/api/v2/silence/"):
+		id := strings.TrimPrefix(r.URL.Path, "/api/v2/silence/")
+		silence, ok := f.silences[id]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		silence.Status.State = zabbixsvc.SilenceExpired
+		f.silences[id] = silence
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeAlertmanager) active() []zabbixsvc.Silence {
+	var res []zabbixsvc.Silence
+	for _, silence := range f.silences {
+		if silence.Status.State == zabbixsvc.SilenceActive {
+			res = append(res, silence)
+		}
+	}
+	return res
+}
+
+//fakeAckZabbix Zabbix API returning the problems, trigger 40 is on host node1
+func fakeAckZabbix(t *testing.T, problems *[]map[string]interface{}) *httptest.Server {
+	return newFakeZabbix(t, map[string]zabbixHandler{
+		"problem.get": func(map[string]interface{}) interface{} {
+			return *problems
+		},
+		"trigger.get": func(map[string]interface{}) interface{} {
+			return []map[string]interface{}{{"triggerid": "40", "hosts": []map[string]string{{"hostid": "1", "host": "node1"}}}}
+		},
+	})
+}
+
+func TestAckSync(t *testing.T) {
+	managed := map[string]interface{}{
+		"eventid": "1205", "objectid": "40", "name": "NodeDown", "acknowledged": "1",
+		"tags": []map[string]string{{"tag": "service", "value": "db"}, {"tag": "managed_by", "value": "zal"}},
+		"acknowledges": []map[string]string{
+			{"eventid": "1205", "userid": "3", "message": "looking", "action": "4"},
+			{"eventid": "1205", "userid": "2", "alias": "alice", "message": "disk swap", "action": "6"},
+		},
+	}
+	other := map[string]interface{}{
+		"eventid": "1206", "objectid": "41", "name": "Manual", "acknowledged": "1",
+		"tags": []map[string]string{{"tag": "managed_by", "value": "someone"}},
+	}
+	problems := []map[string]interface{}{managed, other}
+	ts := fakeAckZabbix(t, &problems)
+	defer ts.Close()
+
+	am := &fakeAlertmanager{silences: map[string]zabbixsvc.Silence{
+		"manual": {ID: "manual", Status: zabbixsvc.SilenceStatus{State: zabbixsvc.SilenceActive}, CreatedBy: "bob",
+			EndsAt: time.Now().Add(time.Hour)},
+	}}
+	amServer := httptest.NewServer(am)
+	defer amServer.Close()
+
+	s := &zabbixsvc.AckSync{
+		API:          zabbix.NewAPI(ts.URL),
+		Alertmanager: zabbixsvc.NewAlertmanagerClient(amServer.URL),
+		HostLabel:    "host",
+		TagLabels:    map[string]string{"service": "service"},
+	}
+	if err := s.Sync(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	active := am.active()
+	if len(active) != 2 {
+		t.Fatalf("expected a silence for the managed problem, got %+v", active)
+	}
+	silence := am.silences["1"]
+	matchers := []zabbixsvc.Matcher{{Name: "alertname", Value: "NodeDown"}, {Name: "host", Value: "node1"}, {Name: "service", Value: "db"}}
+	if !reflect.DeepEqual(silence.Matchers, matchers) {
+		t.Errorf("expected matchers %+v, got %+v", matchers, silence.Matchers)
+	}
+	if silence.CreatedBy != zabbixsvc.AckSilenceCreator ||
+		silence.Comment != "Acknowledged in Zabbix by alice: disk swap\nZabbix event 1205, managed_by: zal" {
+		t.Errorf("unexpected silence %+v", silence)
+	}
+	if d := silence.EndsAt.Sub(silence.StartsAt); d != zabbixsvc.DefaultAckSilenceDuration {
+		t.Errorf("expected the default duration, got %s", d)
+	}
+
+	// The silence is still long enough
+	if err := s.Sync(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if am.puts != 1 {
+		t.Errorf("expected no update, got %d puts", am.puts)
+	}
+
+	// The problem is closed
+	problems = nil
+	if err := s.Sync(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	active = am.active()
+	if len(active) != 1 || active[0].ID != "manual" {
+		t.Errorf("expected only the manual silence, got %+v", active)
+	}
+}
+
+func TestAckSyncExtendsSilences(t *testing.T) {
+	problems := []map[string]interface{}{{
+		"eventid": "1205", "objectid": "40", "name": "NodeDown - no data for the last 300 seconds", "acknowledged": "1",
+		"tags":         []map[string]string{{"tag": "managed_by", "value": "zal"}},
+		"acknowledges": []map[string]string{{"eventid": "1205", "userid": "2", "username": "alice", "action": "2"}},
+	}}
+	ts := fakeAckZabbix(t, &problems)
+	defer ts.Close()
+
+	am := &fakeAlertmanager{silences: map[string]zabbixsvc.Silence{}}
+	amServer := httptest.NewServer(am)
+	defer amServer.Close()
+
+	s := &zabbixsvc.AckSync{
+		API:          zabbix.NewAPI(ts.URL),
+		Alertmanager: zabbixsvc.NewAlertmanagerClient(amServer.URL),
+		Duration:     10 * time.Minute,
+	}
+	if err := s.Sync(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	silence := am.silences["1"]
+	if len(silence.Matchers) != 1 || silence.Matchers[0].Value != "NodeDown" ||
+		!strings.HasPrefix(silence.Comment, "Acknowledged in Zabbix by alice\n") {
+		t.Fatalf("unexpected silence %+v", silence)
+	}
+
+	// Less than half of the duration is left
+	silence.EndsAt = time.Now().Add(4 * time.Minute)
+	am.silences["1"] = silence
+	if err := s.Sync(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if am.puts != 2 || time.Until(am.silences["1"].EndsAt) < 9*time.Minute {
+		t.Errorf("expected the silence to be extended, got %+v after %d puts", am.silences["1"], am.puts)
+	}
+}
@@ -0,0 +1,160 @@
+package zabbixsvc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+//Silence states of the Alertmanager API
+const (
+	SilenceActive  = "active"
+	SilencePending = "pending"
+	SilenceExpired = "expired"
+)
+
+//AlertmanagerClient client of the Alertmanager API v2
+type AlertmanagerClient struct {
+	URL    string
+	Client *http.Client
+}
+
+//NewAlertmanagerClient creates the client of the Alertmanager at the URL, like http://alertmanager:9093
+func NewAlertmanagerClient(url string) *AlertmanagerClient {
+	return &AlertmanagerClient{URL: strings.TrimSuffix(url, "/"), Client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+//Silence of the Alertmanager API v2
+type Silence struct {
+	ID        string        `json:"id"`
+	Status    SilenceStatus `json:"status"`
+	Matchers  []Matcher     `json:"matchers"`
+	StartsAt  time.Time     `json:"startsAt"`
+	EndsAt    time.Time     `json:"endsAt"`
+	CreatedBy string        `json:"createdBy"`
+	Comment   string        `json:"comment"`
+}
+
+//SilenceStatus ...
+type SilenceStatus struct {
+	State string `json:"state"`
+}
+
+//Matcher label matcher of a silence, IsEqual is missing before Alertmanager 0.22 which has only equal matchers
+type Matcher struct {
+	Name    string `json:"name"`
+	Value   string `json:"value"`
+	IsRegex bool   `json:"isRegex"`
+	IsEqual *bool  `json:"isEqual,omitempty"`
+}
+
+//Equal reports whether the matcher selects the alerts with the label matching the value
+func (m Matcher) Equal() bool {
+	return m.IsEqual == nil || *m.IsEqual
+}
+
+//Silences returns all the silences, including the expired ones Alertmanager still keeps
+func (c *AlertmanagerClient) Silences(ctx context.Context) ([]Silence, error) {
+	var silences []Silence
+	if err := c.do(ctx, "GET", "/api/v2/silences", nil, &silences); err != nil {
+		return nil, errors.Wrap(err, "can't get the silences")
+	}
+	return silences, nil
+}
+
+//postableSilence silence sent to Alertmanager, an existing silence is updated when the ID is set
+type postableSilence struct {
+	ID        string    `json:"id,omitempty"`
+	Matchers  []Matcher `json:"matchers"`
+	StartsAt  time.Time `json:"startsAt"`
+	EndsAt    time.Time `json:"endsAt"`
+	CreatedBy string    `json:"createdBy"`
+	Comment   string    `json:"comment"`
+}
+
+//PutSilence creates the silence, or updates it when the ID is set, and returns its ID.
+// Alertmanager may give an updated silence a new ID.
+func (c *AlertmanagerClient) PutSilence(ctx context.Context, silence Silence) (string, error) {
+	body, err := json.Marshal(postableSilence{
+		ID:        silence.ID,
+		Matchers:  silence.Matchers,
+		StartsAt:  silence.StartsAt,
+		EndsAt:    silence.EndsAt,
+		CreatedBy: silence.CreatedBy,
+		Comment:   silence.Comment,
+	})
+	if err != nil {
+		return "", err
+	}
+	var res struct {
+		SilenceID string `json:"silenceID"`
+	}
+	if err := c.do(ctx, "POST", "/api/v2/silences", body, &res); err != nil {
+		return "", errors.Wrap(err, "can't put the silence")
+	}
+	return res.SilenceID, nil
+}
+
+//ExpireSilence expires the silence with the ID
+func (c *AlertmanagerClient) ExpireSilence(ctx context.Context, id string) error {
+	if err := c.do(ctx, "DELETE", "/api/v2/silence/"+url.PathEscape(id), nil, nil); err != nil {
+		return errors.Wrapf(err, "can't expire the silence %s", id)
+	}
+	return nil
+}
+
+//PostableAlert alert pushed to Alertmanager, it resolves at EndsAt unless it is pushed again
+type PostableAlert struct {
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+	StartsAt     time.Time         `json:"startsAt,omitempty"`
+	EndsAt       time.Time         `json:"endsAt,omitempty"`
+	GeneratorURL string            `json:"generatorURL,omitempty"`
+}
+
+//PostAlerts pushes the alerts, alerts with the same labels update each other
+func (c *AlertmanagerClient) PostAlerts(ctx context.Context, alerts []PostableAlert) error {
+	body, err := json.Marshal(alerts)
+	if err != nil {
+		return err
+	}
+	if err := c.do(ctx, "POST", "/api/v2/alerts", body, nil); err != nil {
+		return errors.Wrap(err, "can't post the alerts")
+	}
+	return nil
+}
+
+//do sends the request with the JSON body and decodes the JSON response into v unless it is nil
+func (c *AlertmanagerClient) do(ctx context.Context, method, path string, body []byte, v interface{}) error {
+	req, err := http.NewRequest(method, c.URL+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	res, err := c.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK {
+		return errors.Errorf("unexpected status %s: %s", res.Status, strings.TrimSpace(string(data)))
+	}
+	if v == nil {
+		return nil
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,229 @@
+package zabbixsvc
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/neogan74/zabbix-alertmanager/zabbixprovisioner/provisioner"
+	zabbix "github.com/neogan74/zabbix-alertmanager/zabbixprovisioner/zabbixclient"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	log "github.com/sirupsen/logrus"
+)
+
+var exporterRefreshErrorsTotal = promauto.NewCounter(
+	prometheus.CounterOpts{
+		Name: "zabbix_exporter_refresh_errors_total",
+		Help: "Current number of failed reads of the Zabbix problems, triggers and hosts",
+	},
+)
+
+//Exporter exposes the open Zabbix problems, the trigger values and the host availability as Prometheus metrics.
+// They are read by Refresh, the scrapes return the metrics of the last successful refresh.
+type Exporter struct {
+	API *zabbix.API
+
+	tags        []string
+	problem     *prometheus.Desc
+	trigger     *prometheus.Desc
+	available   *prometheus.Desc
+	lastRefresh *prometheus.Desc
+
+	mu          sync.RWMutex
+	metrics     []prometheus.Metric
+	refreshedAt time.Time
+}
+
+//NewExporter creates the exporter, the problem tags are added as labels to zabbix_problem.
+// Tags whose label name is already taken are skipped.
+func NewExporter(api *zabbix.API, tags []string) *Exporter {
+	problemLabels := []string{"host", "trigger", "severity"}
+	used := map[string]bool{"host": true, "trigger": true, "severity": true}
+	var exported []string
+	for _, tag := range tags {
+		name := labelName(tag)
+		if used[name] {
+			log.Warnf("skipping problem tag %q, label %s is already exported", tag, name)
+			continue
+		}
+		used[name] = true
+		exported = append(exported, tag)
+		problemLabels = append(problemLabels, name)
+	}
+	return &Exporter{
+		API:  api,
+		tags: exported,
+		problem: prometheus.NewDesc("zabbix_problem",
+			"Open Zabbix problems, more than 1 when the trigger has several open problems.", problemLabels, nil),
+		trigger: prometheus.NewDesc("zabbix_trigger_value",
+			"Value of the enabled Zabbix triggers of the monitored hosts, 1 for a problem and 0 for OK.",
+			[]string{"host", "trigger", "severity", "triggerid"}, nil),
+		available: prometheus.NewDesc("zabbix_host_available",
+			"Availability of the monitored hosts, 1 when available and 0 when unavailable.",
+			[]string{"host"}, nil),
+		lastRefresh: prometheus.NewDesc("zabbix_exporter_last_refresh_timestamp_seconds",
+			"Time of the last successful read of the exported metrics.", nil, nil),
+	}
+}
+
+//Describe implements prometheus.Collector
+func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
+	ch <- e.problem
+	ch <- e.trigger
+	ch <- e.available
+	ch <- e.lastRefresh
+}
+
+//Collect implements prometheus.Collector
+func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if e.refreshedAt.IsZero() {
+		return
+	}
+	for _, m := range e.metrics {
+		ch <- m
+	}
+	ch <- prometheus.MustNewConstMetric(e.lastRefresh, prometheus.GaugeValue, float64(e.refreshedAt.UnixNano())/1e9)
+}
+
+//Run refreshes the metrics every interval until stop is closed
+func (e *Exporter) Run(interval time.Duration, stop <-chan struct{}) {
+	runEvery(interval, stop, func(ctx context.Context) {
+		if err := e.Refresh(ctx); err != nil {
+			exporterRefreshErrorsTotal.Inc()
+			log.Errorf("error reading the exported Zabbix metrics: %v", err)
+		}
+	})
+}
+
+//Refresh reads the problems, triggers and hosts, the previous metrics are kept when it fails
+func (e *Exporter) Refresh(ctx context.Context) error {
+	triggers, err := e.API.TriggersGetContext(ctx, zabbix.Params{
+		"output":            []string{"triggerid", "description", "priority", "value"},
+		"selectHosts":       []string{"host"},
+		"monitored":         true,
+		"expandDescription": true,
+	})
+	if err != nil {
+		return errors.Wrap(err, "can't get the triggers")
+	}
+	problems, err := e.API.ProblemsGetContext(ctx, zabbix.Params{
+		"output":     []string{"eventid", "objectid", "name", "severity"},
+		"source":     zabbix.EventSourceTrigger,
+		"object":     zabbix.EventObjectTrigger,
+		"selectTags": "extend",
+	})
+	if err != nil {
+		return errors.Wrap(err, "can't get the problems")
+	}
+	// Zabbix 5.4 moved the availability from the hosts to their interfaces
+	version, err := e.API.VersionContext(ctx)
+	if err != nil {
+		return errors.Wrap(err, "can't get the Zabbix version")
+	}
+	hostParams := zabbix.Params{
+		"output":          []string{"hostid", "host", "available"},
+		"monitored_hosts": true,
+	}
+	interfaces := zabbix.VersionAtLeast(version, 5, 4)
+	if interfaces {
+		hostParams["output"] = []string{"hostid", "host"}
+		hostParams["selectInterfaces"] = []string{"available"}
+	}
+	hosts, err := e.API.HostsGetContext(ctx, hostParams)
+	if err != nil {
+		return errors.Wrap(err, "can't get the hosts")
+	}
+
+	var metrics []prometheus.Metric
+	triggerHosts := map[string]string{}
+	for _, trigger := range triggers {
+		host := ""
+		if len(trigger.Hosts) != 0 {
+			host = trigger.Hosts[0].Host
+		}
+		triggerHosts[trigger.TriggerID] = host
+		metrics = append(metrics, prometheus.MustNewConstMetric(e.trigger, prometheus.GaugeValue, float64(trigger.Value),
+			host, trigger.Description, provisioner.PriorityName(trigger.Priority), trigger.TriggerID))
+	}
+
+	// Problems with the same labels are counted in the same series
+	type problemSeries struct {
+		labels []string
+		count  int
+	}
+	series := map[string]*problemSeries{}
+	for _, problem := range problems {
+		labels := append([]string{triggerHosts[problem.ObjectID], problem.Name, provisioner.PriorityName(problem.Severity)}, e.tagValues(problem.Tags)...)
+		key := strings.Join(labels, "\xff")
+		if s, ok := series[key]; ok {
+			s.count++
+			continue
+		}
+		series[key] = &problemSeries{labels: labels, count: 1}
+	}
+	keys := make([]string, 0, len(series))
+	for key := range series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		metrics = append(metrics, prometheus.MustNewConstMetric(e.problem, prometheus.GaugeValue, float64(series[key].count), series[key].labels...))
+	}
+
+	for _, host := range hosts {
+		available := host.Available
+		if interfaces {
+			available = interfacesAvailability(host.Interfaces)
+		}
+		switch available {
+		case zabbix.Available:
+			metrics = append(metrics, prometheus.MustNewConstMetric(e.available, prometheus.GaugeValue, 1, host.Host))
+		case zabbix.Unavailable:
+			metrics = append(metrics, prometheus.MustNewConstMetric(e.available, prometheus.GaugeValue, 0, host.Host))
+		}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.metrics = metrics
+	e.refreshedAt = time.Now()
+	return nil
+}
+
+//interfacesAvailability availability of the host from its interfaces, available when one of them is,
+// unavailable when one is and none is available, unknown otherwise
+func interfacesAvailability(interfaces zabbix.HostInterfaces) zabbix.AvailableType {
+	var available zabbix.AvailableType
+	for _, iface := range interfaces {
+		switch iface.Available {
+		case zabbix.Available:
+			return zabbix.Available
+		case zabbix.Unavailable:
+			available = zabbix.Unavailable
+		}
+	}
+	return available
+}
+
+//tagValues values of the exported tags, several values of a tag are separated by commas
+func (e *Exporter) tagValues(tags []zabbix.Tag) []string {
+	values := make([]string, len(e.tags))
+	for i, name := range e.tags {
+		for _, tag := range tags {
+			if tag.Tag != name {
+				continue
+			}
+			if values[i] != "" {
+				values[i] += ","
+			}
+			values[i] += tag.Value
+		}
+	}
+	return values
+}
@@ -0,0 +1,141 @@
+package zabbixsvc_test
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	zabbix "github.com/neogan74/zabbix-alertmanager/zabbixprovisioner/zabbixclient"
+	"github.com/neogan74/zabbix-alertmanager/zabbixsender/zabbixsvc"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+//fakeExporterZabbix Zabbix API of the version with 2 triggers of node1, 3 problems and 3 hosts.
+// The availability is on the hosts before Zabbix 5.4, on their interfaces since then.
+func fakeExporterZabbix(t *testing.T, version string, fail *bool) *httptest.Server {
+	failing := func(handler zabbixHandler) zabbixHandler {
+		return func(params map[string]interface{}) interface{} {
+			if *fail {
+				return &zabbix.Error{Code: -32500, Message: "Application error.", Data: "No permissions."}
+			}
+			return handler(params)
+		}
+	}
+	return newFakeZabbix(t, map[string]zabbixHandler{
+		"APIInfo.version": func(map[string]interface{}) interface{} {
+			return version
+		},
+		"trigger.get": failing(func(map[string]interface{}) interface{} {
+			return []map[string]interface{}{
+				{"triggerid": "40", "description": "Zabbix agent is unreachable", "priority": "4", "value": "1",
+					"hosts": []map[string]string{{"hostid": "1", "host": "node1"}}},
+				{"triggerid": "41", "description": "High CPU load", "priority": "2", "value": "0",
+					"hosts": []map[string]string{{"hostid": "1", "host": "node1"}}},
+			}
+		}),
+		"problem.get": failing(func(map[string]interface{}) interface{} {
+			tags := []map[string]string{{"tag": "scope", "value": "availability"}, {"tag": "app.name", "value": "agent"}}
+			return []map[string]interface{}{
+				{"eventid": "1205", "objectid": "40", "name": "Zabbix agent is unreachable", "severity": "4", "tags": tags},
+				{"eventid": "1207", "objectid": "40", "name": "Zabbix agent is unreachable", "severity": "4", "tags": tags},
+				{"eventid": "1206", "objectid": "40", "name": "Zabbix agent is unreachable", "severity": "5"},
+			}
+		}),
+		"host.get": failing(func(params map[string]interface{}) interface{} {
+			if !zabbix.VersionAtLeast(version, 5, 4) {
+				return []map[string]string{
+					{"hostid": "1", "host": "node1", "available": "2"},
+					{"hostid": "2", "host": "node2", "available": "1"},
+					{"hostid": "3", "host": "node3", "available": "0"},
+				}
+			}
+			if !reflect.DeepEqual(params["selectInterfaces"], []interface{}{"available"}) {
+				t.Errorf("expected the availability of the interfaces, got %v", params)
+			}
+			interfaces := func(available ...string) []map[string]string {
+				var res []map[string]string
+				for _, a := range available {
+					res = append(res, map[string]string{"available": a})
+				}
+				return res
+			}
+			return []map[string]interface{}{
+				{"hostid": "1", "host": "node1", "interfaces": interfaces("2", "0")},
+				{"hostid": "2", "host": "node2", "interfaces": interfaces("2", "1")},
+				{"hostid": "3", "host": "node3", "interfaces": interfaces("0")},
+			}
+		}),
+	})
+}
+
+//gather collects the metrics as name{label="value",...} by value
+func gather(t *testing.T, c prometheus.Collector) map[string]float64 {
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(c)
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	metrics := map[string]float64{}
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			var labels []string
+			for _, l := range m.GetLabel() {
+				labels = append(labels, fmt.Sprintf("%s=%q", l.GetName(), l.GetValue()))
+			}
+			metrics[family.GetName()+"{"+strings.Join(labels, ",")+"}"] = m.GetGauge().GetValue()
+		}
+	}
+	return metrics
+}
+
+func TestExporter(t *testing.T) {
+	for _, version := range []string{"5.0.0", "5.4.0"} {
+		t.Run(version, func(t *testing.T) {
+			testExporter(t, version)
+		})
+	}
+}
+
+func testExporter(t *testing.T, version string) {
+	fail := false
+	ts := fakeExporterZabbix(t, version, &fail)
+	defer ts.Close()
+
+	e := zabbixsvc.NewExporter(zabbix.NewAPI(ts.URL), []string{"app.name", "host"})
+	if metrics := gather(t, e); len(metrics) != 0 {
+		t.Fatalf("expected no metrics before the first refresh, got %v", metrics)
+	}
+
+	if err := e.Refresh(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	metrics := gather(t, e)
+	if _, ok := metrics["zabbix_exporter_last_refresh_timestamp_seconds{}"]; !ok {
+		t.Errorf("expected the time of the refresh, got %v", metrics)
+	}
+	delete(metrics, "zabbix_exporter_last_refresh_timestamp_seconds{}")
+	expected := map[string]float64{
+		`zabbix_trigger_value{host="node1",severity="high",trigger="Zabbix agent is unreachable",triggerid="40"}`: 1,
+		`zabbix_trigger_value{host="node1",severity="warning",trigger="High CPU load",triggerid="41"}`:            0,
+		`zabbix_problem{app_name="agent",host="node1",severity="high",trigger="Zabbix agent is unreachable"}`:     2,
+		`zabbix_problem{app_name="",host="node1",severity="disaster",trigger="Zabbix agent is unreachable"}`:      1,
+		`zabbix_host_available{host="node1"}`: 0,
+		`zabbix_host_available{host="node2"}`: 1,
+	}
+	if !reflect.DeepEqual(metrics, expected) {
+		t.Errorf("expected metrics %v, got %v", expected, metrics)
+	}
+
+	// The metrics of the last refresh are kept when Zabbix fails
+	fail = true
+	if err := e.Refresh(context.Background()); err == nil {
+		t.Fatal("expected an error")
+	}
+	if metrics := gather(t, e); len(metrics) != len(expected)+1 {
+		t.Errorf("expected the previous metrics, got %v", metrics)
+	}
+}
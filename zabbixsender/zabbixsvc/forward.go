@@ -0,0 +1,268 @@
+package zabbixsvc
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/neogan74/zabbix-alertmanager/zabbixprovisioner/provisioner"
+	zabbix "github.com/neogan74/zabbix-alertmanager/zabbixprovisioner/zabbixclient"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	log "github.com/sirupsen/logrus"
+)
+
+//Labels of the alerts forwarded from Zabbix problems, the problem tags are added as labels too
+const (
+	ForwardHostLabel    = "zabbix_host"
+	ForwardGroupsLabel  = "zabbix_groups"
+	ForwardEventIDLabel = "zabbix_eventid"
+)
+
+//DefaultResolveTimeout how long a forwarded alert fires without being pushed again
+const DefaultResolveTimeout = 5 * time.Minute
+
+var (
+	forwardedProblems = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "forwarded_problems",
+			Help: "Current number of Zabbix problems forwarded to Alertmanager as firing alerts",
+		},
+	)
+
+	forwardErrorsTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "forward_errors_total",
+			Help: "Current number of failed forwards of the Zabbix problems",
+		},
+	)
+)
+
+//invalidLabelChars characters which aren't allowed in Prometheus label names
+var invalidLabelChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+//Forwarder pushes the open Zabbix problems to Alertmanager as alerts and resolves them when the problems recover.
+// The alerts are pushed again on every run so they don't resolve by themselves.
+type Forwarder struct {
+	API          *zabbix.API
+	Alertmanager *AlertmanagerClient
+	// FrontendURL Zabbix frontend URL used for the generator URL of the alerts, none when empty
+	FrontendURL string
+	// MinSeverity lowest severity of the forwarded problems
+	MinSeverity zabbix.PriorityType
+	// IncludeManaged forwards the problems of the triggers managed by zal too, which come from Alertmanager
+	IncludeManaged bool
+	// ResolveTimeout how long the alerts fire without being pushed again, DefaultResolveTimeout when 0.
+	// It has to be longer than the interval of the runs.
+	ResolveTimeout time.Duration
+
+	// WebhookToken bearer token required by HandleWebhook, none when empty
+	WebhookToken string
+	// NoPoll only refreshes the alerts received by the webhook instead of polling the problems
+	NoPoll bool
+
+	mu sync.Mutex
+	// firing alerts pushed by the last run or received by the webhook, by event
+	firing map[string]PostableAlert
+}
+
+//Run forwards the problems every interval until stop is closed
+func (f *Forwarder) Run(interval time.Duration, stop <-chan struct{}) {
+	run := f.Forward
+	if f.NoPoll {
+		run = f.Refresh
+	}
+	runEvery(interval, stop, func(ctx context.Context) {
+		if err := run(ctx); err != nil {
+			forwardErrorsTotal.Inc()
+			log.Errorf("error forwarding the Zabbix problems: %v", err)
+		}
+	})
+}
+
+//Forward pushes the open problems and resolves the alerts of the problems which recovered since the last run
+func (f *Forwarder) Forward(ctx context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	problems, err := f.problems(ctx)
+	if err != nil {
+		return err
+	}
+	triggers, err := f.triggers(ctx, problems)
+	if err != nil {
+		return err
+	}
+
+	resolveTimeout := f.resolveTimeout()
+	now := time.Now()
+
+	firing := map[string]PostableAlert{}
+	var alerts []PostableAlert
+	for _, problem := range problems {
+		alert := f.problemAlert(problem, triggers[problem.ObjectID])
+		alert.EndsAt = now.Add(resolveTimeout)
+		firing[problem.EventID] = alert
+		alerts = append(alerts, alert)
+	}
+	resolved := 0
+	for eventID, alert := range f.firing {
+		if _, ok := firing[eventID]; ok {
+			continue
+		}
+		alert.EndsAt = now
+		alerts = append(alerts, alert)
+		resolved++
+	}
+
+	if len(alerts) != 0 {
+		if err := f.Alertmanager.PostAlerts(ctx, alerts); err != nil {
+			return err
+		}
+	}
+	if resolved != 0 {
+		log.Infof("resolved %d alerts of recovered Zabbix problems", resolved)
+	}
+	f.firing = firing
+	forwardedProblems.Set(float64(len(firing)))
+	return nil
+}
+
+//Refresh pushes the firing alerts again, so they don't resolve by themselves
+func (f *Forwarder) Refresh(ctx context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if len(f.firing) == 0 {
+		return nil
+	}
+	endsAt := time.Now().Add(f.resolveTimeout())
+	alerts := make([]PostableAlert, 0, len(f.firing))
+	for eventID, alert := range f.firing {
+		alert.EndsAt = endsAt
+		f.firing[eventID] = alert
+		alerts = append(alerts, alert)
+	}
+	return f.Alertmanager.PostAlerts(ctx, alerts)
+}
+
+func (f *Forwarder) resolveTimeout() time.Duration {
+	if f.ResolveTimeout == 0 {
+		return DefaultResolveTimeout
+	}
+	return f.ResolveTimeout
+}
+
+//forwarded reports whether the problem passes the severity and ownership filters
+func (f *Forwarder) forwarded(problem zabbix.ProblemEvent) bool {
+	return problem.Severity >= f.MinSeverity && (f.IncludeManaged || !provisioner.IsManaged(problem.Tags))
+}
+
+//problems returns the open trigger problems to forward
+func (f *Forwarder) problems(ctx context.Context) (zabbix.Problems, error) {
+	params := zabbix.Params{
+		"source":     zabbix.EventSourceTrigger,
+		"object":     zabbix.EventObjectTrigger,
+		"selectTags": "extend",
+	}
+	if f.MinSeverity > zabbix.NotClassified {
+		var severities []zabbix.PriorityType
+		for s := f.MinSeverity; s <= zabbix.Critical; s++ {
+			severities = append(severities, s)
+		}
+		params["severities"] = severities
+	}
+	problems, err := f.API.ProblemsGetContext(ctx, params)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't get the problems")
+	}
+	res := problems[:0]
+	for _, problem := range problems {
+		if f.forwarded(problem) {
+			res = append(res, problem)
+		}
+	}
+	return res, nil
+}
+
+//triggers returns the triggers of the problems with their hosts and groups, by id
+func (f *Forwarder) triggers(ctx context.Context, problems zabbix.Problems) (map[string]zabbix.Trigger, error) {
+	res := map[string]zabbix.Trigger{}
+	if len(problems) == 0 {
+		return res, nil
+	}
+	triggerIDs := make([]string, len(problems))
+	for i, problem := range problems {
+		triggerIDs[i] = problem.ObjectID
+	}
+	triggers, err := f.API.TriggersGetContext(ctx, zabbix.Params{
+		"output":       []string{"triggerid", "comments"},
+		"triggerids":   triggerIDs,
+		"selectHosts":  []string{"host"},
+		"selectGroups": []string{"name"},
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "can't get the triggers of the problems")
+	}
+	for _, trigger := range triggers {
+		res[trigger.TriggerID] = trigger
+	}
+	return res, nil
+}
+
+//problemAlert converts the problem into an alert labeled with its host, host groups, tags and severity
+func (f *Forwarder) problemAlert(problem zabbix.ProblemEvent, trigger zabbix.Trigger) PostableAlert {
+	labels := map[string]string{}
+	for _, tag := range problem.Tags {
+		name := labelName(tag.Tag)
+		if existing, ok := labels[name]; ok {
+			labels[name] = existing + "," + tag.Value
+			continue
+		}
+		labels[name] = tag.Value
+	}
+
+	labels["alertname"] = problem.Name
+	labels[provisioner.DefaultSeverityLabel] = provisioner.PriorityName(problem.Severity)
+	labels[ForwardEventIDLabel] = problem.EventID
+	if len(trigger.Hosts) != 0 {
+		labels[ForwardHostLabel] = trigger.Hosts[0].Host
+	}
+	if len(trigger.Groups) != 0 {
+		groups := make([]string, len(trigger.Groups))
+		for i, group := range trigger.Groups {
+			groups[i] = group.Name
+		}
+		sort.Strings(groups)
+		labels[ForwardGroupsLabel] = strings.Join(groups, ",")
+	}
+
+	annotations := map[string]string{"summary": problem.Name}
+	if trigger.Comments != "" {
+		annotations["description"] = trigger.Comments
+	}
+
+	alert := PostableAlert{
+		Labels:      labels,
+		Annotations: annotations,
+		StartsAt:    time.Unix(int64(problem.Clock), 0),
+	}
+	if f.FrontendURL != "" {
+		alert.GeneratorURL = fmt.Sprintf("%s/tr_events.php?triggerid=%s&eventid=%s", strings.TrimSuffix(f.FrontendURL, "/"), problem.ObjectID, problem.EventID)
+	}
+	return alert
+}
+
+//labelName turns the tag name into a valid label name
+func labelName(tag string) string {
+	name := invalidLabelChars.ReplaceAllString(tag, "_")
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		name = "_" + name
+	}
+	return name
+}
@@ -0,0 +1,152 @@
+package zabbixsvc_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	zabbix "github.com/neogan74/zabbix-alertmanager/zabbixprovisioner/zabbixclient"
+	"github.com/neogan74/zabbix-alertmanager/zabbixsender/zabbixsvc"
+)
+
+//fakeForwardZabbix Zabbix API returning the problems, their triggers are on host node1 in 2 groups
+func fakeForwardZabbix(t *testing.T, problems *[]map[string]interface{}) *httptest.Server {
+	return newFakeZabbix(t, map[string]zabbixHandler{
+		"problem.get": func(params map[string]interface{}) interface{} {
+			if !reflect.DeepEqual(params["severities"], []interface{}{float64(3), float64(4), float64(5)}) {
+				t.Errorf("expected the severities from average, got %v", params)
+			}
+			return *problems
+		},
+		"trigger.get": func(params map[string]interface{}) interface{} {
+			var triggers []map[string]interface{}
+			for _, id := range params["triggerids"].([]interface{}) {
+				triggers = append(triggers, map[string]interface{}{
+					"triggerid": id, "comments": "agent is down",
+					"hosts":  []map[string]string{{"hostid": "1", "host": "node1"}},
+					"groups": []map[string]string{{"groupid": "3", "name": "Linux"}, {"groupid": "2", "name": "DB"}},
+				})
+			}
+			return triggers
+		},
+	})
+}
+
+//alertsReceiver Alertmanager API recording the pushed alerts
+func alertsReceiver(posts *[][]zabbixsvc.PostableAlert) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/api/v2/alerts" {
+			http.NotFound(w, r)
+			return
+		}
+		var alerts []zabbixsvc.PostableAlert
+		json.NewDecoder(r.Body).Decode(&alerts)
+		*posts = append(*posts, alerts)
+	}))
+}
+
+func TestForwarder(t *testing.T) {
+	problems := []map[string]interface{}{
+		{
+			"eventid": "1205", "objectid": "40", "name": "Zabbix agent is unreachable", "clock": "1700000000", "severity": "4",
+			"tags": []map[string]string{{"tag": "scope", "value": "availability"}, {"tag": "app.name", "value": "agent"}},
+		},
+		{
+			"eventid": "1206", "objectid": "41", "name": "NodeDown", "clock": "1700000000", "severity": "5",
+			"tags": []map[string]string{{"tag": "managed_by", "value": "zal"}},
+		},
+	}
+	ts := fakeForwardZabbix(t, &problems)
+	defer ts.Close()
+
+	var posts [][]zabbixsvc.PostableAlert
+	am := alertsReceiver(&posts)
+	defer am.Close()
+
+	f := &zabbixsvc.Forwarder{
+		API:          zabbix.NewAPI(ts.URL),
+		Alertmanager: zabbixsvc.NewAlertmanagerClient(am.URL),
+		FrontendURL:  "http://zabbix.example.com/",
+		MinSeverity:  zabbix.Average,
+	}
+	if err := f.Forward(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(posts) != 1 || len(posts[0]) != 1 {
+		t.Fatalf("expected the alert of the problem which isn't managed by zal, got %+v", posts)
+	}
+	alert := posts[0][0]
+	labels := map[string]string{
+		"alertname":      "Zabbix agent is unreachable",
+		"severity":       "high",
+		"zabbix_host":    "node1",
+		"zabbix_groups":  "DB,Linux",
+		"zabbix_eventid": "1205",
+		"scope":          "availability",
+		"app_name":       "agent",
+	}
+	if !reflect.DeepEqual(alert.Labels, labels) {
+		t.Errorf("expected labels %v, got %v", labels, alert.Labels)
+	}
+	if alert.Annotations["description"] != "agent is down" ||
+		alert.GeneratorURL != "http://zabbix.example.com/tr_events.php?triggerid=40&eventid=1205" ||
+		!alert.StartsAt.Equal(time.Unix(1700000000, 0)) {
+		t.Errorf("unexpected alert %+v", alert)
+	}
+	if d := time.Until(alert.EndsAt); d < 4*time.Minute || d > zabbixsvc.DefaultResolveTimeout {
+		t.Errorf("expected the alert to end after the resolve timeout, got %s", alert.EndsAt)
+	}
+
+	// The problem recovers
+	problems = nil
+	if err := f.Forward(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(posts) != 2 || len(posts[1]) != 1 || posts[1][0].Labels["zabbix_eventid"] != "1205" || time.Until(posts[1][0].EndsAt) > 0 {
+		t.Fatalf("expected the alert to be resolved, got %+v", posts)
+	}
+
+	// Nothing left to push
+	if err := f.Forward(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(posts) != 2 {
+		t.Errorf("expected no more alerts, got %+v", posts[2:])
+	}
+}
+
+func TestForwarderRetriesResolution(t *testing.T) {
+	problems := []map[string]interface{}{{"eventid": "1205", "objectid": "40", "name": "Down", "severity": "3"}}
+	ts := fakeForwardZabbix(t, &problems)
+	defer ts.Close()
+
+	var posts [][]zabbixsvc.PostableAlert
+	am := alertsReceiver(&posts)
+	defer am.Close()
+
+	f := &zabbixsvc.Forwarder{API: zabbix.NewAPI(ts.URL), Alertmanager: zabbixsvc.NewAlertmanagerClient(am.URL), MinSeverity: zabbix.Average}
+	if err := f.Forward(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Alertmanager is down when the problem recovers
+	am.Close()
+	problems = nil
+	if err := f.Forward(context.Background()); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	am = alertsReceiver(&posts)
+	defer am.Close()
+	f.Alertmanager = zabbixsvc.NewAlertmanagerClient(am.URL)
+	if err := f.Forward(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(posts) != 2 || len(posts[1]) != 1 || time.Until(posts[1][0].EndsAt) > 0 {
+		t.Errorf("expected the resolution to be pushed again, got %+v", posts)
+	}
+}
@@ -0,0 +1,106 @@
+package zabbixsvc
+
+import (
+	"encoding/json"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+//MediaTypeExportVersion Zabbix version of the media type export, importable by Zabbix 5.4 and newer
+const MediaTypeExportVersion = "5.4"
+
+//DefaultMediaTypeName name of the exported media type
+const DefaultMediaTypeName = "Alertmanager (zal)"
+
+//webhookScript script of the media type, it posts the parameters but URL and Token as JSON to zal
+const webhookScript = `var params = JSON.parse(value),
+    request = new CurlHttpRequest(),
+    url = params.URL,
+    token = params.Token;
+
+delete params.URL;
+delete params.Token;
+request.AddHeader('Content-Type: application/json');
+if (token) {
+    request.AddHeader('Authorization: Bearer ' + token);
+}
+var response = request.Post(url, JSON.stringify(params));
+Zabbix.Log(4, '[ zal webhook ] response ' + request.Status() + ': ' + response);
+
+if (request.Status() !== 200) {
+    throw 'zal responded with ' + request.Status() + ': ' + response;
+}
+return 'OK';
+`
+
+//MediaTypeExport Zabbix configuration export holding the webhook media type of zal
+type MediaTypeExport struct {
+	ZabbixExport struct {
+		Version    string      `yaml:"version" json:"version"`
+		MediaTypes []MediaType `yaml:"media_types" json:"media_types"`
+	} `yaml:"zabbix_export" json:"zabbix_export"`
+}
+
+//MediaType webhook media type in the Zabbix export format
+type MediaType struct {
+	Name             string               `yaml:"name" json:"name"`
+	Type             string               `yaml:"type" json:"type"`
+	Parameters       []MediaTypeParameter `yaml:"parameters" json:"parameters"`
+	Script           string               `yaml:"script" json:"script"`
+	Timeout          string               `yaml:"timeout" json:"timeout"`
+	Description      string               `yaml:"description" json:"description"`
+	MessageTemplates []MessageTemplate    `yaml:"message_templates" json:"message_templates"`
+}
+
+//MediaTypeParameter ...
+type MediaTypeParameter struct {
+	Name  string `yaml:"name" json:"name"`
+	Value string `yaml:"value" json:"value"`
+}
+
+//MessageTemplate ...
+type MessageTemplate struct {
+	EventSource   string `yaml:"event_source" json:"event_source"`
+	OperationMode string `yaml:"operation_mode" json:"operation_mode"`
+	Subject       string `yaml:"subject" json:"subject"`
+	Message       string `yaml:"message" json:"message"`
+}
+
+//WebhookMediaType returns the export of the media type posting the trigger events to the webhook of zal forward at the URL,
+// authenticated by the token of zal forward --webhook-token-file
+func WebhookMediaType(name, url, token string) MediaTypeExport {
+	parameters := []MediaTypeParameter{{Name: "URL", Value: url}, {Name: "Token", Value: token}}
+	for _, macro := range WebhookMacros {
+		parameters = append(parameters, MediaTypeParameter{Name: macro.Name, Value: macro.Macro})
+	}
+
+	var export MediaTypeExport
+	export.ZabbixExport.Version = MediaTypeExportVersion
+	export.ZabbixExport.MediaTypes = []MediaType{{
+		Name:        name,
+		Type:        "WEBHOOK",
+		Parameters:  parameters,
+		Script:      webhookScript,
+		Timeout:     "10s",
+		Description: "Forwards the trigger problems and their recoveries to Alertmanager through zal forward --webhook.",
+		MessageTemplates: []MessageTemplate{
+			{EventSource: "TRIGGERS", OperationMode: "PROBLEM", Subject: "Problem: {EVENT.NAME}", Message: "{EVENT.NAME}"},
+			{EventSource: "TRIGGERS", OperationMode: "RECOVERY", Subject: "Resolved: {EVENT.NAME}", Message: "{EVENT.NAME}"},
+		},
+	}}
+	return export
+}
+
+//YAML encodes the export as YAML
+func (e MediaTypeExport) YAML() ([]byte, error) {
+	return yaml.Marshal(e)
+}
+
+//JSON encodes the export as indented JSON
+func (e MediaTypeExport) JSON() ([]byte, error) {
+	data, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
@@ -0,0 +1,125 @@
+package zabbixsvc
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	zabbix "github.com/neogan74/zabbix-alertmanager/zabbixprovisioner/zabbixclient"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	problemsClosedTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "problems_closed_total",
+			Help: "Current number of Zabbix problems closed for resolved alerts",
+		},
+		[]string{"host"},
+	)
+
+	problemsCloseErrorsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "problems_close_errors_total",
+			Help: "Current number of resolved alerts whose Zabbix problems couldn't be closed",
+		},
+		[]string{"host"},
+	)
+)
+
+//ProblemCloser closes the Zabbix problems of resolved alerts, only the triggers with manual close allow it
+type ProblemCloser struct {
+	API *zabbix.API
+}
+
+//Close closes the open problems of the manually closable triggers of the item with the key on the host,
+// adding the message to them. Returns the number of closed problems.
+func (c *ProblemCloser) Close(ctx context.Context, host, key, message string) (int, error) {
+	items, err := c.API.ItemsGetContext(ctx, zabbix.Params{
+		"output": []string{"itemid"},
+		"host":   host,
+		"filter": map[string]interface{}{"key_": key},
+	})
+	if err != nil {
+		return 0, errors.Wrapf(err, "can't get the item %s of host %s", key, host)
+	}
+	if len(items) == 0 {
+		return 0, nil
+	}
+	itemIDs := make([]string, len(items))
+	for i, item := range items {
+		itemIDs[i] = item.ItemID
+	}
+
+	triggers, err := c.API.TriggersGetContext(ctx, zabbix.Params{
+		"output":  []string{"triggerid"},
+		"itemids": itemIDs,
+		"filter":  map[string]interface{}{"manual_close": 1},
+	})
+	if err != nil {
+		return 0, errors.Wrapf(err, "can't get the triggers of item %s of host %s", key, host)
+	}
+	if len(triggers) == 0 {
+		return 0, nil
+	}
+	triggerIDs := make([]string, len(triggers))
+	for i, trigger := range triggers {
+		triggerIDs[i] = trigger.TriggerID
+	}
+
+	problems, err := c.API.ProblemsGetContext(ctx, zabbix.Params{
+		"output":    []string{"eventid"},
+		"source":    zabbix.EventSourceTrigger,
+		"object":    zabbix.EventObjectTrigger,
+		"objectids": triggerIDs,
+	})
+	if err != nil {
+		return 0, errors.Wrapf(err, "can't get the problems of item %s of host %s", key, host)
+	}
+	if len(problems) == 0 {
+		return 0, nil
+	}
+	eventIDs := make([]string, len(problems))
+	for i, problem := range problems {
+		eventIDs[i] = problem.EventID
+	}
+
+	closed, err := c.API.EventsAcknowledgeContext(ctx, eventIDs, zabbix.AckClose|zabbix.AckMessage, message)
+	if err != nil {
+		return 0, errors.Wrapf(err, "can't close the problems of item %s of host %s", key, host)
+	}
+	return len(closed), nil
+}
+
+//closeResolved closes the problems of the resolved alerts, the failures are only logged
+// because the resolution was already sent
+func (h *JSONHandler) closeResolved(ctx context.Context, host string, alerts []Alert, keys []string, externalURL string) {
+	for i, alert := range alerts {
+		closed, err := h.Closer.Close(ctx, host, keys[i], resolvedMessage(alert, externalURL))
+		if err != nil {
+			problemsCloseErrorsTotal.WithLabelValues(host).Inc()
+			log.Errorf("error closing the problems of resolved alert %s: %v", keys[i], err)
+			continue
+		}
+		if closed != 0 {
+			problemsClosedTotal.WithLabelValues(host).Add(float64(closed))
+			log.Debugf("closed %d problems of host '%s' key '%s'", closed, host, keys[i])
+		}
+	}
+}
+
+//resolvedMessage message added to the closed problems, with the resolution time and the Alertmanager link
+func resolvedMessage(alert Alert, externalURL string) string {
+	resolvedAt, err := time.Parse(time.RFC3339, alert.EndsAt)
+	if err != nil {
+		resolvedAt = time.Now()
+	}
+	message := fmt.Sprintf("Resolved in Alertmanager at %s", resolvedAt.UTC().Format(time.RFC3339))
+	if externalURL != "" {
+		message = fmt.Sprintf("%s: %s", message, externalURL)
+	}
+	return message
+}
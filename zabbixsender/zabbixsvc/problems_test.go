@@ -0,0 +1,79 @@
+package zabbixsvc_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	zabbix "github.com/neogan74/zabbix-alertmanager/zabbixprovisioner/zabbixclient"
+	"github.com/neogan74/zabbix-alertmanager/zabbixsender/zabbixsvc"
+)
+
+//fakeProblems Zabbix API with the item prometheus.nodedown of node1, its manually closable trigger and 2 problems
+func fakeProblems(t *testing.T, acknowledged *map[string]interface{}) *httptest.Server {
+	return newFakeZabbix(t, map[string]zabbixHandler{
+		"item.get": func(params map[string]interface{}) interface{} {
+			filter, _ := params["filter"].(map[string]interface{})
+			if params["host"] == "node1" && filter["key_"] == "prometheus.nodedown" {
+				return []map[string]string{{"itemid": "30"}}
+			}
+			return []interface{}{}
+		},
+		"trigger.get": func(params map[string]interface{}) interface{} {
+			filter, _ := params["filter"].(map[string]interface{})
+			if filter["manual_close"] != float64(1) {
+				t.Errorf("expected only manually closable triggers, got %v", params)
+			}
+			return []map[string]string{{"triggerid": "40"}}
+		},
+		"problem.get": func(params map[string]interface{}) interface{} {
+			if !reflect.DeepEqual(params["objectids"], []interface{}{"40"}) {
+				t.Errorf("unexpected problem.get params %v", params)
+			}
+			return []map[string]string{{"eventid": "50"}, {"eventid": "51"}}
+		},
+		"event.acknowledge": func(params map[string]interface{}) interface{} {
+			*acknowledged = params
+			return map[string]interface{}{"eventids": params["eventids"]}
+		},
+	})
+}
+
+func TestProblemCloser(t *testing.T) {
+	var acknowledged map[string]interface{}
+	ts := fakeProblems(t, &acknowledged)
+	defer ts.Close()
+
+	c := &zabbixsvc.ProblemCloser{API: zabbix.NewAPI(ts.URL)}
+	closed, err := c.Close(context.Background(), "node1", "prometheus.nodedown", "Resolved in Alertmanager")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if closed != 2 {
+		t.Errorf("expected 2 closed problems, got %d", closed)
+	}
+	expected := map[string]interface{}{
+		"eventids": []interface{}{"50", "51"},
+		"action":   float64(zabbix.AckClose | zabbix.AckMessage),
+		"message":  "Resolved in Alertmanager",
+	}
+	if !reflect.DeepEqual(acknowledged, expected) {
+		t.Errorf("expected acknowledge %v, got %v", expected, acknowledged)
+	}
+}
+
+func TestProblemCloserUnknownItem(t *testing.T) {
+	var acknowledged map[string]interface{}
+	ts := fakeProblems(t, &acknowledged)
+	defer ts.Close()
+
+	c := &zabbixsvc.ProblemCloser{API: zabbix.NewAPI(ts.URL)}
+	closed, err := c.Close(context.Background(), "node2", "prometheus.nodedown", "Resolved in Alertmanager")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if closed != 0 || acknowledged != nil {
+		t.Errorf("expected nothing closed, got %d and %v", closed, acknowledged)
+	}
+}
@@ -0,0 +1,254 @@
+package zabbixsvc
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/neogan74/zabbix-alertmanager/zabbixprovisioner/provisioner"
+	zabbix "github.com/neogan74/zabbix-alertmanager/zabbixprovisioner/zabbixclient"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	log "github.com/sirupsen/logrus"
+)
+
+//SilenceMaintenancePrefix prefix of the names of the maintenances created for the silences
+const SilenceMaintenancePrefix = "Alertmanager silence "
+
+//minMaintenancePeriod shortest period Zabbix accepts for a maintenance time period
+const minMaintenancePeriod = 5 * time.Minute
+
+var (
+	silenceMaintenances = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "silence_maintenances",
+			Help: "Current number of Zabbix maintenances created for Alertmanager silences",
+		},
+	)
+
+	silenceSyncErrorsTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "silence_sync_errors_total",
+			Help: "Current number of failed synchronizations of the silences into maintenances",
+		},
+	)
+)
+
+//SilenceSync turns the active and pending Alertmanager silences into Zabbix maintenances for the silenced hosts.
+// A silence selects hosts with an equality matcher on HostLabel, or a regex matcher listing host names like a|b.
+type SilenceSync struct {
+	Alertmanager *AlertmanagerClient
+	API          *zabbix.API
+	HostLabel    string
+}
+
+//Run synchronizes the silences every interval until stop is closed
+func (s *SilenceSync) Run(interval time.Duration, stop <-chan struct{}) {
+	runEvery(interval, stop, func(ctx context.Context) {
+		if err := s.Sync(ctx); err != nil {
+			silenceSyncErrorsTotal.Inc()
+			log.Errorf("error synchronizing the silences into maintenances: %v", err)
+		}
+	})
+}
+
+//Sync creates, updates and deletes the maintenances of the silences once
+func (s *SilenceSync) Sync(ctx context.Context) error {
+	silences, err := s.Alertmanager.Silences(ctx)
+	if err != nil {
+		return err
+	}
+
+	hostsBySilence := map[string][]string{}
+	var names []string
+	for _, silence := range silences {
+		if silence.Status.State != SilenceActive && silence.Status.State != SilencePending {
+			continue
+		}
+		hosts := s.silencedHosts(silence)
+		if len(hosts) == 0 {
+			log.Debugf("silence %s doesn't select hosts by %s, skipping it", silence.ID, s.HostLabel)
+			continue
+		}
+		hostsBySilence[silence.ID] = hosts
+		names = append(names, hosts...)
+	}
+
+	hostIDs := map[string]string{}
+	if len(names) != 0 {
+		hosts, err := s.API.HostsGetContext(ctx, zabbix.Params{
+			"output": []string{"hostid", "host"},
+			"filter": map[string]interface{}{"host": names},
+		})
+		if err != nil {
+			return errors.Wrap(err, "can't get the silenced hosts")
+		}
+		for _, host := range hosts {
+			hostIDs[host.Host] = host.HostID
+		}
+	}
+
+	desired := map[string]zabbix.Maintenance{}
+	for _, silence := range silences {
+		hosts, ok := hostsBySilence[silence.ID]
+		if !ok {
+			continue
+		}
+		var ids []string
+		for _, host := range hosts {
+			id, ok := hostIDs[host]
+			if !ok {
+				log.Warnf("silence %s selects host %s which doesn't exist in Zabbix", silence.ID, host)
+				continue
+			}
+			ids = append(ids, id)
+		}
+		if len(ids) == 0 {
+			continue
+		}
+		maintenance := silenceMaintenance(silence, ids)
+		desired[maintenance.Name] = maintenance
+	}
+
+	existing, err := s.API.MaintenancesGetContext(ctx, zabbix.Params{
+		"search":            map[string]string{"name": SilenceMaintenancePrefix},
+		"startSearch":       true,
+		"selectHosts":       []string{"hostid"},
+		"selectTimeperiods": "extend",
+	})
+	if err != nil {
+		return errors.Wrap(err, "can't get the maintenances")
+	}
+
+	var create, update zabbix.Maintenances
+	var remove []string
+	found := map[string]bool{}
+	for _, maintenance := range existing {
+		if !provisioner.HasManagedMarker(maintenance.Description) {
+			continue
+		}
+		want, ok := desired[maintenance.Name]
+		if !ok || found[maintenance.Name] {
+			remove = append(remove, maintenance.MaintenanceID)
+			continue
+		}
+		found[maintenance.Name] = true
+		if !maintenanceEqual(maintenance, want) {
+			want.MaintenanceID = maintenance.MaintenanceID
+			update = append(update, want)
+		}
+	}
+	for name, maintenance := range desired {
+		if !found[name] {
+			create = append(create, maintenance)
+		}
+	}
+	sort.Slice(create, func(i, j int) bool { return create[i].Name < create[j].Name })
+
+	if len(create) != 0 {
+		if err := s.API.MaintenancesCreateContext(ctx, create); err != nil {
+			return errors.Wrap(err, "can't create the maintenances")
+		}
+		for _, maintenance := range create {
+			log.Infof("created maintenance %q for %d hosts", maintenance.Name, len(maintenance.HostIDs))
+		}
+	}
+	if len(update) != 0 {
+		if err := s.API.MaintenancesUpdateContext(ctx, update); err != nil {
+			return errors.Wrap(err, "can't update the maintenances")
+		}
+		for _, maintenance := range update {
+			log.Infof("updated maintenance %q", maintenance.Name)
+		}
+	}
+	if len(remove) != 0 {
+		if err := s.API.MaintenancesDeleteByIDsContext(ctx, remove); err != nil {
+			return errors.Wrap(err, "can't delete the maintenances")
+		}
+		log.Infof("deleted %d maintenances of expired silences", len(remove))
+	}
+
+	silenceMaintenances.Set(float64(len(desired)))
+	return nil
+}
+
+//literalAlternation matches regexes which only list host names, like node1|node2.example.com,
+// the dots are taken literally
+var literalAlternation = regexp.MustCompile(`^[\w.:-]+(\|[\w.:-]+)*$`)
+
+//silencedHosts returns the host names selected by the matchers of the silence on the host label.
+// Silences with other matchers are skipped, the maintenance would suppress all the problems of the hosts.
+func (s *SilenceSync) silencedHosts(silence Silence) []string {
+	for _, matcher := range silence.Matchers {
+		if matcher.Name != s.HostLabel {
+			log.Warnf("silence %s also matches %s, only silences of whole hosts by %s become maintenances", silence.ID, matcher.Name, s.HostLabel)
+			return nil
+		}
+	}
+	for _, matcher := range silence.Matchers {
+		if matcher.Name != s.HostLabel || !matcher.Equal() {
+			continue
+		}
+		if !matcher.IsRegex {
+			return []string{matcher.Value}
+		}
+		if literalAlternation.MatchString(matcher.Value) {
+			return strings.Split(matcher.Value, "|")
+		}
+		log.Warnf("silence %s matches %s with the regex %q, only lists of host names like a|b are supported", silence.ID, s.HostLabel, matcher.Value)
+	}
+	return nil
+}
+
+//silenceMaintenance the maintenance of the silence for the hosts, keeping the data collection
+func silenceMaintenance(silence Silence, hostIDs []string) zabbix.Maintenance {
+	since := silence.StartsAt.Truncate(time.Minute)
+	till := silence.EndsAt.Add(time.Minute - time.Nanosecond).Truncate(time.Minute)
+	if till.Sub(since) < minMaintenancePeriod {
+		till = since.Add(minMaintenancePeriod)
+	}
+	sort.Strings(hostIDs)
+
+	description := fmt.Sprintf("Created by %s in Alertmanager", silence.CreatedBy)
+	if silence.Comment != "" {
+		description = fmt.Sprintf("%s: %s", description, silence.Comment)
+	}
+	return zabbix.Maintenance{
+		Name:            SilenceMaintenancePrefix + silence.ID,
+		ActiveSince:     zabbix.Int(since.Unix()),
+		ActiveTill:      zabbix.Int(till.Unix()),
+		Description:     description + "\n\n" + provisioner.ManagedMarker,
+		MaintenanceType: zabbix.MaintenanceWithData,
+		TimePeriods: zabbix.TimePeriods{{
+			TimePeriodType: zabbix.OneTimeOnly,
+			StartDate:      zabbix.Int(since.Unix()),
+			Period:         zabbix.Int(till.Sub(since) / time.Second),
+		}},
+		HostIDs: hostIDs,
+	}
+}
+
+//maintenanceEqual reports whether the existing maintenance matches the desired one
+func maintenanceEqual(existing, desired zabbix.Maintenance) bool {
+	if existing.ActiveSince != desired.ActiveSince || existing.ActiveTill != desired.ActiveTill ||
+		existing.Description != desired.Description || existing.MaintenanceType != desired.MaintenanceType {
+		return false
+	}
+	if len(existing.TimePeriods) != 1 {
+		return false
+	}
+	period, want := existing.TimePeriods[0], desired.TimePeriods[0]
+	if period.TimePeriodType != want.TimePeriodType || period.StartDate != want.StartDate || period.Period != want.Period {
+		return false
+	}
+	hostIDs := make([]string, len(existing.Hosts))
+	for i, host := range existing.Hosts {
+		hostIDs[i] = host.HostID
+	}
+	sort.Strings(hostIDs)
+	return strings.Join(hostIDs, ",") == strings.Join(desired.HostIDs, ",")
+}
@@ -0,0 +1,196 @@
+package zabbixsvc_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	zabbix "github.com/neogan74/zabbix-alertmanager/zabbixprovisioner/zabbixclient"
+	"github.com/neogan74/zabbix-alertmanager/zabbixsender/zabbixsvc"
+)
+
+//fakeMaintenances Zabbix API keeping the maintenances in memory, with the hosts node1 and node2
+type fakeMaintenances struct {
+	mu           sync.Mutex
+	lastID       int
+	maintenances map[string]zabbix.Maintenance
+	calls        []string
+}
+
+func (f *fakeMaintenances) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Method string          `json:"method"`
+		Params json.RawMessage `json:"params"`
+		ID     int             `json:"id"`
+	}
+	json.NewDecoder(r.Body).Decode(&req)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, req.Method)
+
+	var result interface{}
+	switch req.Method {
+	case "host.get":
+		result = []map[string]string{{"hostid": "1", "host": "node1"}, {"hostid": "2", "host": "node2"}}
+	case "maintenance.get":
+		var res []map[string]interface{}
+		for _, m := range f.maintenances {
+			hosts := make([]map[string]string, len(m.HostIDs))
+			for i, id := range m.HostIDs {
+				hosts[i] = map[string]string{"hostid": id}
+			}
+			res = append(res, map[string]interface{}{
+				"maintenanceid": m.MaintenanceID, "name": m.Name, "description": m.Description,
+				"active_since": fmt.Sprint(m.ActiveSince), "active_till": fmt.Sprint(m.ActiveTill),
+				"maintenance_type": "0", "timeperiods": m.TimePeriods, "hosts": hosts,
+			})
+		}
+		result = res
+	case "maintenance.create", "maintenance.update":
+		var maintenances zabbix.Maintenances
+		json.Unmarshal(req.Params, &maintenances)
+		var ids []string
+		for _, m := range maintenances {
+			if req.Method == "maintenance.create" {
+				f.lastID++
+				m.MaintenanceID = fmt.Sprint(f.lastID)
+			}
+			f.maintenances[m.MaintenanceID] = m
+			ids = append(ids, m.MaintenanceID)
+		}
+		result = map[string]interface{}{"maintenanceids": ids}
+	case "maintenance.delete":
+		var ids []string
+		json.Unmarshal(req.Params, &ids)
+		for _, id := range ids {
+			delete(f.maintenances, id)
+		}
+		result = map[string]interface{}{"maintenanceids": ids}
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "result": result, "id": req.ID})
+}
+
+func (f *fakeMaintenances) byName() map[string]zabbix.Maintenance {
+	res := map[string]zabbix.Maintenance{}
+	for _, m := range f.maintenances {
+		res[m.Name] = m
+	}
+	return res
+}
+
+func silenceJSON(id, state, matchers string, endsAt time.Time) string {
+	return fmt.Sprintf(`{"id": %q, "status": {"state": %q}, "matchers": [%s],
+		"startsAt": "2023-11-14T22:13:20.5Z", "endsAt": %q, "createdBy": "alice", "comment": "disk swap"}`,
+		id, state, matchers, endsAt.Format(time.RFC3339Nano))
+}
+
+func TestSilenceSync(t *testing.T) {
+	start := time.Date(2023, 11, 14, 22, 13, 0, 0, time.UTC)
+	var silences []string
+	am := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v2/silences" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, "[%s]", strings.Join(silences, ","))
+	}))
+	defer am.Close()
+
+	f := &fakeMaintenances{maintenances: map[string]zabbix.Maintenance{
+		"100": {MaintenanceID: "100", Name: "Alertmanager silence manual", Description: "not ours"},
+	}}
+	f.lastID = 100
+	ts := httptest.NewServer(f)
+	defer ts.Close()
+
+	s := &zabbixsvc.SilenceSync{
+		Alertmanager: zabbixsvc.NewAlertmanagerClient(am.URL + "/"),
+		API:          zabbix.NewAPI(ts.URL),
+		HostLabel:    "host",
+	}
+
+	silences = []string{
+		silenceJSON("a", "active", `{"name": "host", "value": "node1", "isRegex": false}`, start.Add(time.Hour+10*time.Second)),
+		silenceJSON("b", "pending", `{"name": "host", "value": "node2|node1|node3", "isRegex": true, "isEqual": true}`, start.Add(time.Minute)),
+		silenceJSON("c", "active", `{"name": "host", "value": "node.*", "isRegex": true}`, start.Add(time.Hour)),
+		silenceJSON("d", "active", `{"name": "host", "value": "node1", "isRegex": false, "isEqual": false}`, start.Add(time.Hour)),
+		silenceJSON("e", "expired", `{"name": "host", "value": "node1", "isRegex": false}`, start.Add(time.Hour)),
+		silenceJSON("f", "active", `{"name": "alertname", "value": "NodeDown", "isRegex": false}`, start.Add(time.Hour)),
+		silenceJSON("g", "active", `{"name": "host", "value": "node1", "isRegex": false},
+			{"name": "alertname", "value": "NodeDown", "isRegex": false}`, start.Add(time.Hour)),
+	}
+	if err := s.Sync(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	maintenances := f.byName()
+	if len(maintenances) != 3 {
+		t.Fatalf("expected the maintenances of silences a and b only, got %+v", maintenances)
+	}
+	a := maintenances["Alertmanager silence a"]
+	since := int(start.Unix())
+	if int(a.ActiveSince) != since || int(a.ActiveTill) != since+61*60 ||
+		a.Description != "Created by alice in Alertmanager: disk swap\n\nmanaged_by: zal" ||
+		len(a.TimePeriods) != 1 || int(a.TimePeriods[0].StartDate) != since || a.TimePeriods[0].Period != 61*60 ||
+		strings.Join(a.HostIDs, ",") != "1" {
+		t.Errorf("unexpected maintenance %+v", a)
+	}
+	b := maintenances["Alertmanager silence b"]
+	sort.Strings(b.HostIDs)
+	if strings.Join(b.HostIDs, ",") != "1,2" || b.TimePeriods[0].Period != 300 {
+		t.Errorf("expected the known hosts and the shortest period, got %+v", b)
+	}
+
+	// Nothing changed, nothing to write
+	f.calls = nil
+	if err := s.Sync(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Join(f.calls, ",") != "host.get,maintenance.get" {
+		t.Errorf("expected only reads, got %v", f.calls)
+	}
+
+	// a is extended and b expires
+	silences = []string{
+		silenceJSON("a", "active", `{"name": "host", "value": "node1", "isRegex": false}`, start.Add(2*time.Hour)),
+		silenceJSON("b", "expired", `{"name": "host", "value": "node2", "isRegex": false}`, start.Add(time.Minute)),
+	}
+	if err := s.Sync(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	maintenances = f.byName()
+	if len(maintenances) != 2 || maintenances["Alertmanager silence manual"].MaintenanceID != "100" {
+		t.Fatalf("expected maintenance b to be deleted, got %+v", maintenances)
+	}
+	a = maintenances["Alertmanager silence a"]
+	if a.MaintenanceID != "101" || int(a.ActiveTill) != since+2*3600 || a.TimePeriods[0].Period != 2*3600 {
+		t.Errorf("expected maintenance a to be extended, got %+v", a)
+	}
+}
+
+func TestSilenceSyncAlertmanagerDown(t *testing.T) {
+	am := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer am.Close()
+
+	f := &fakeMaintenances{maintenances: map[string]zabbix.Maintenance{}}
+	ts := httptest.NewServer(f)
+	defer ts.Close()
+
+	s := &zabbixsvc.SilenceSync{Alertmanager: zabbixsvc.NewAlertmanagerClient(am.URL), API: zabbix.NewAPI(ts.URL), HostLabel: "host"}
+	if err := s.Sync(context.Background()); err == nil {
+		t.Fatal("expected an error")
+	}
+	if len(f.calls) != 0 {
+		t.Errorf("expected no Zabbix calls, got %v", f.calls)
+	}
+}
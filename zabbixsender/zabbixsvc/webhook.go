@@ -0,0 +1,171 @@
+package zabbixsvc
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	zabbix "github.com/neogan74/zabbix-alertmanager/zabbixprovisioner/zabbixclient"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	log "github.com/sirupsen/logrus"
+)
+
+//WebhookEvent body posted by the Zabbix webhook media type of zal mediatype export, every field holds the value
+// of the event macro in the comment. Macros Zabbix can't expand, like the ones of newer versions, are ignored.
+type WebhookEvent struct {
+	// EventID {EVENT.ID}
+	EventID string `json:"event_id"`
+	// Value {EVENT.VALUE}, 1 for a problem and 0 for its recovery
+	Value string `json:"event_value"`
+	// Severity {EVENT.NSEVERITY}, the severity as a number
+	Severity string `json:"event_severity"`
+	// Name {EVENT.NAME}
+	Name string `json:"event_name"`
+	// Tags {EVENT.TAGSJSON}, the list of the tags as JSON, Zabbix 5.0 or newer
+	Tags string `json:"event_tags"`
+	// Host {HOST.HOST}
+	Host string `json:"host"`
+	// HostGroups {TRIGGER.HOSTGROUP.NAME}, the sorted host groups separated by commas
+	HostGroups string `json:"host_groups"`
+	// TriggerID {TRIGGER.ID}
+	TriggerID string `json:"trigger_id"`
+	// TriggerDescription {TRIGGER.DESCRIPTION}
+	TriggerDescription string `json:"trigger_description"`
+}
+
+//WebhookMacros event macros of the fields of WebhookEvent, by JSON name
+var WebhookMacros = []struct{ Name, Macro string }{
+	{"event_id", "{EVENT.ID}"},
+	{"event_value", "{EVENT.VALUE}"},
+	{"event_severity", "{EVENT.NSEVERITY}"},
+	{"event_name", "{EVENT.NAME}"},
+	{"event_tags", "{EVENT.TAGSJSON}"},
+	{"host", "{HOST.HOST}"},
+	{"host_groups", "{TRIGGER.HOSTGROUP.NAME}"},
+	{"trigger_id", "{TRIGGER.ID}"},
+	{"trigger_description", "{TRIGGER.DESCRIPTION}"},
+}
+
+var webhookEventsTotal = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "forward_webhook_events_total",
+		Help: "Current number of Zabbix webhook events by result: problem, recovery, ignored, unauthorized or error",
+	},
+	[]string{"result"},
+)
+
+//expanded returns the value unless it is a macro Zabbix didn't expand
+func expanded(value string) string {
+	if strings.HasPrefix(value, "{") && strings.HasSuffix(value, "}") && !strings.Contains(value, "\"") {
+		return ""
+	}
+	return value
+}
+
+//problem converts the event into the problem and the trigger the alerts are made of
+func (e WebhookEvent) problem(now time.Time) (zabbix.ProblemEvent, zabbix.Trigger) {
+	severity, _ := strconv.Atoi(expanded(e.Severity))
+	problem := zabbix.ProblemEvent{
+		EventID:  e.EventID,
+		ObjectID: expanded(e.TriggerID),
+		Name:     expanded(e.Name),
+		Severity: zabbix.PriorityType(severity),
+		Clock:    zabbix.Int(now.Unix()),
+	}
+	if tags := expanded(e.Tags); tags != "" {
+		if err := json.Unmarshal([]byte(tags), &problem.Tags); err != nil {
+			log.Warnf("can't decode the tags of Zabbix event %s: %v", e.EventID, err)
+		}
+	}
+
+	trigger := zabbix.Trigger{TriggerID: problem.ObjectID, Comments: expanded(e.TriggerDescription)}
+	if host := expanded(e.Host); host != "" {
+		trigger.Hosts = zabbix.Hosts{{Host: host}}
+	}
+	if groups := expanded(e.HostGroups); groups != "" {
+		for _, group := range strings.Split(groups, ",") {
+			trigger.Groups = append(trigger.Groups, zabbix.HostGroup{Name: strings.TrimSpace(group)})
+		}
+	}
+	return problem, trigger
+}
+
+//authorized reports whether the request carries the webhook token as bearer token, any request is when it is empty
+func (f *Forwarder) authorized(r *http.Request) bool {
+	if f.WebhookToken == "" {
+		return true
+	}
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+	token := strings.TrimPrefix(auth, "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(f.WebhookToken)) == 1
+}
+
+//HandleWebhook receives the events of the Zabbix webhook media type, pushes the alerts of the problems
+// and resolves them on recovery. The alerts are kept firing by Run until they recover.
+func (f *Forwarder) HandleWebhook(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	if !f.authorized(r) {
+		webhookEventsTotal.WithLabelValues("unauthorized").Inc()
+		http.Error(w, "missing or wrong bearer token", http.StatusUnauthorized)
+		return
+	}
+	var event WebhookEvent
+	if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+		webhookEventsTotal.WithLabelValues("error").Inc()
+		http.Error(w, "request body is not valid json", http.StatusBadRequest)
+		return
+	}
+	if expanded(event.EventID) == "" || (event.Value != "0" && event.Value != "1") {
+		webhookEventsTotal.WithLabelValues("error").Inc()
+		http.Error(w, "missing event_id or event_value in request body", http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	problem, trigger := event.problem(now)
+	if !f.forwarded(problem) {
+		webhookEventsTotal.WithLabelValues("ignored").Inc()
+		log.Debugf("ignoring Zabbix event %s", event.EventID)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.firing == nil {
+		f.firing = map[string]PostableAlert{}
+	}
+
+	result := "problem"
+	alert, ok := f.firing[event.EventID]
+	if !ok {
+		// Alertmanager keeps the earliest start of the alerts with the same labels
+		alert = f.problemAlert(problem, trigger)
+	}
+	if event.Value == "0" {
+		result = "recovery"
+		alert.EndsAt = now
+	} else {
+		alert.EndsAt = now.Add(f.resolveTimeout())
+	}
+
+	if err := f.Alertmanager.PostAlerts(r.Context(), []PostableAlert{alert}); err != nil {
+		webhookEventsTotal.WithLabelValues("error").Inc()
+		log.Errorf("error forwarding Zabbix event %s: %v", event.EventID, err)
+		http.Error(w, "failed to push the alert to Alertmanager", http.StatusBadGateway)
+		return
+	}
+	webhookEventsTotal.WithLabelValues(result).Inc()
+	if event.Value == "0" {
+		delete(f.firing, event.EventID)
+	} else {
+		f.firing[event.EventID] = alert
+	}
+	forwardedProblems.Set(float64(len(f.firing)))
+}
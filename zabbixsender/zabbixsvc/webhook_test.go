@@ -0,0 +1,164 @@
+package zabbixsvc_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/neogan74/zabbix-alertmanager/zabbixsender/zabbixsvc"
+	yaml "gopkg.in/yaml.v2"
+)
+
+const webhookProblem = `{
+	"event_id": "1205",
+	"event_value": "1",
+	"event_severity": "4",
+	"event_name": "Zabbix agent is unreachable",
+	"event_tags": "[{\"tag\":\"scope\",\"value\":\"availability\"}]",
+	"host": "node1",
+	"host_groups": "DB, Linux",
+	"trigger_id": "40",
+	"trigger_description": "{TRIGGER.DESCRIPTION}"
+}`
+
+func postWebhook(f *zabbixsvc.Forwarder, body string) int {
+	return postWebhookAuth(f, body, "Bearer secret")
+}
+
+func postWebhookAuth(f *zabbixsvc.Forwarder, body, auth string) int {
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/webhook", strings.NewReader(body))
+	req.Header.Set("Authorization", auth)
+	f.HandleWebhook(rr, req)
+	return rr.Code
+}
+
+func TestHandleWebhook(t *testing.T) {
+	var posts [][]zabbixsvc.PostableAlert
+	am := alertsReceiver(&posts)
+	defer am.Close()
+
+	f := &zabbixsvc.Forwarder{Alertmanager: zabbixsvc.NewAlertmanagerClient(am.URL), FrontendURL: "http://zabbix", WebhookToken: "secret", NoPoll: true}
+	if code := postWebhook(f, webhookProblem); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if len(posts) != 1 || len(posts[0]) != 1 {
+		t.Fatalf("expected an alert, got %+v", posts)
+	}
+	alert := posts[0][0]
+	labels := map[string]string{
+		"alertname":      "Zabbix agent is unreachable",
+		"severity":       "high",
+		"zabbix_host":    "node1",
+		"zabbix_groups":  "DB,Linux",
+		"zabbix_eventid": "1205",
+		"scope":          "availability",
+	}
+	if !reflect.DeepEqual(alert.Labels, labels) {
+		t.Errorf("expected labels %v, got %v", labels, alert.Labels)
+	}
+	if _, ok := alert.Annotations["description"]; ok {
+		t.Errorf("expected no description for the unexpanded macro, got %v", alert.Annotations)
+	}
+	if alert.GeneratorURL != "http://zabbix/tr_events.php?triggerid=40&eventid=1205" || time.Until(alert.EndsAt) <= 0 {
+		t.Errorf("unexpected alert %+v", alert)
+	}
+
+	// The firing alerts are pushed again
+	if err := f.Refresh(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(posts) != 2 || !reflect.DeepEqual(posts[1][0].Labels, labels) {
+		t.Fatalf("expected the alert to be refreshed, got %+v", posts)
+	}
+
+	recovery := strings.Replace(webhookProblem, `"event_value": "1"`, `"event_value": "0"`, 1)
+	if code := postWebhook(f, recovery); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if len(posts) != 3 || time.Until(posts[2][0].EndsAt) > 0 || !posts[2][0].StartsAt.Equal(alert.StartsAt) {
+		t.Fatalf("expected the alert to be resolved, got %+v", posts)
+	}
+
+	if err := f.Refresh(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(posts) != 3 {
+		t.Errorf("expected nothing to refresh, got %+v", posts[3:])
+	}
+}
+
+func TestHandleWebhookRejected(t *testing.T) {
+	var posts [][]zabbixsvc.PostableAlert
+	am := alertsReceiver(&posts)
+	defer am.Close()
+	f := &zabbixsvc.Forwarder{Alertmanager: zabbixsvc.NewAlertmanagerClient(am.URL), WebhookToken: "secret", NoPoll: true}
+
+	for body, code := range map[string]int{
+		`{"event_id": "1205"`:                            http.StatusBadRequest,
+		`{"event_id": "{EVENT.ID}", "event_value": "1"}`: http.StatusBadRequest,
+		`{"event_id": "1205", "event_value": "2"}`:       http.StatusBadRequest,
+		// problems of the triggers managed by zal come from Alertmanager
+		`{"event_id": "1205", "event_value": "1", "event_tags": "[{\"tag\":\"managed_by\",\"value\":\"zal\"}]"}`: http.StatusOK,
+	} {
+		if got := postWebhook(f, body); got != code {
+			t.Errorf("expected %d for %s, got %d", code, body, got)
+		}
+	}
+	for _, auth := range []string{"", "secret", "Bearer wrong"} {
+		if got := postWebhookAuth(f, webhookProblem, auth); got != http.StatusUnauthorized {
+			t.Errorf("expected 401 for the authorization %q, got %d", auth, got)
+		}
+	}
+	if len(posts) != 0 {
+		t.Errorf("expected no alerts, got %+v", posts)
+	}
+
+	am.Close()
+	if code := postWebhook(f, webhookProblem); code != http.StatusBadGateway {
+		t.Errorf("expected 502 when Alertmanager is down, got %d", code)
+	}
+}
+
+func TestWebhookMediaType(t *testing.T) {
+	export := zabbixsvc.WebhookMediaType(zabbixsvc.DefaultMediaTypeName, "http://zal:9098/webhook", "secret")
+	data, err := export.YAML()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var decoded zabbixsvc.MediaTypeExport
+	if err := yaml.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(decoded, export) {
+		t.Fatalf("expected %+v after a round trip, got %+v", export, decoded)
+	}
+
+	mediaType := export.ZabbixExport.MediaTypes[0]
+	if mediaType.Type != "WEBHOOK" || mediaType.Parameters[0].Name != "URL" || mediaType.Parameters[0].Value != "http://zal:9098/webhook" ||
+		mediaType.Parameters[1].Name != "Token" || mediaType.Parameters[1].Value != "secret" {
+		t.Errorf("unexpected media type %+v", mediaType)
+	}
+
+	// Every parameter but URL and Token fills a field of the event posted by the script
+	params := map[string]string{}
+	for _, p := range mediaType.Parameters[2:] {
+		params[p.Name] = "x"
+	}
+	body, _ := json.Marshal(params)
+	var event zabbixsvc.WebhookEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	fields, _ := json.Marshal(event)
+	var decodedFields map[string]string
+	json.Unmarshal(fields, &decodedFields)
+	if !reflect.DeepEqual(decodedFields, params) {
+		t.Errorf("expected the parameters %v to fill the event fields, got %v", params, decodedFields)
+	}
+}
@@ -1,6 +1,7 @@
 package zabbixsvc
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -8,6 +9,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/neogan74/zabbix-alertmanager/zabbixprovisioner/provisioner"
 	"github.com/neogan74/zabbix-alertmanager/zabbixsender/zabbixsnd"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
@@ -48,6 +50,11 @@ type JSONHandler struct {
 	KeyPrefix   string
 	DefaultHost string
 	Hosts       map[string]string
+	// HostConfigs provisioner configs by Zabbix host, used to compute item keys the same way zal prov does.
+	// See HostConfigsByHost.
+	HostConfigs map[string]provisioner.HostConfig
+	// Closer closes the Zabbix problems of the resolved alerts when set
+	Closer *ProblemCloser
 }
 
 var (
@@ -66,8 +73,37 @@ var (
 		},
 		[]string{"alert_status", "host"},
 	)
+
+	alertsUnconfiguredTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "alerts_unconfigured_host_total",
+			Help: "Current number of alerts sent to hosts without a provisioner config, their item keys use the defaults",
+		},
+		[]string{"host"},
+	)
 )
 
+//HostConfigsByHost indexes the provisioner configs by the Zabbix hosts listed in their hosts field.
+// The config name is the template name in zal prov, the alerts are pushed to the hosts linked to it.
+func HostConfigsByHost(configs []provisioner.HostConfig) (map[string]provisioner.HostConfig, error) {
+	byHost := map[string]provisioner.HostConfig{}
+	for _, config := range configs {
+		if err := config.ItemKey.Validate(); err != nil {
+			return nil, errors.Wrapf(err, "error in item key config of %s", config.Name)
+		}
+		if len(config.Hosts) == 0 {
+			log.Warnf("config %s has no hosts, zal send doesn't use it", config.Name)
+		}
+		for _, host := range config.Hosts {
+			if previous, ok := byHost[host]; ok {
+				return nil, errors.Errorf("host %s is listed by the configs %s and %s", host, previous.Name, config.Name)
+			}
+			byHost[host] = config
+		}
+	}
+	return byHost, nil
+}
+
 func (h *JSONHandler) HandlePost(w http.ResponseWriter, r *http.Request) {
 	dec := json.NewDecoder(r.Body)
 	defer r.Body.Close()
@@ -101,13 +137,24 @@ func (h *JSONHandler) HandlePost(w http.ResponseWriter, r *http.Request) {
 	alertsSentStats.WithLabelValues(req.Status, host).Inc()
 
 	var metrics []*zabbixsnd.Metric
+	var keys []string
+	hostConfig, ok := h.HostConfigs[host]
+	if !ok && len(h.HostConfigs) != 0 {
+		alertsUnconfiguredTotal.WithLabelValues(host).Add(float64(len(req.Alerts)))
+		log.Warnf("host %s isn't listed in the hosts of any config, sending the default item keys which may not exist", host)
+	}
+
 	for _, alert := range req.Alerts {
-		key := fmt.Sprintf("%s.%s", h.KeyPrefix, strings.ToLower(alert.Labels["alertname"]))
+		key := hostConfig.ItemKey.AlertKey(h.KeyPrefix, alert.Labels)
+		if hostConfig.SeverityMapping.Dynamic {
+			key = fmt.Sprintf("%s.%s", key, provisioner.PriorityName(hostConfig.SeverityMapping.Priority(alert.Labels)))
+		}
 		m := &zabbixsnd.Metric{Host: host, Key: key, Value: value}
 
 		m.Clock = time.Now().Unix()
 
 		metrics = append(metrics, m)
+		keys = append(keys, key)
 
 		log.Debugf("sending zabbix metrics, host: '%s' key: '%s', value: '%s'", host, key, value)
 	}
@@ -121,6 +168,10 @@ func (h *JSONHandler) HandlePost(w http.ResponseWriter, r *http.Request) {
 	}
 
 	log.Debugf("request succesfully sent: %s", res)
+
+	if h.Closer != nil && req.Status == "resolved" {
+		h.closeResolved(r.Context(), host, req.Alerts, keys, req.ExternalURL)
+	}
 }
 
 func (h *JSONHandler) zabbixSend(metrics []*zabbixsnd.Metric) (*ZabbixResponse, error) {
@@ -160,3 +211,26 @@ func LoadHostsFromFile(filename string) (map[string]string, error) {
 
 	return hosts, nil
 }
+
+//runEvery calls run right away and then every interval until stop is closed.
+// The context of run is canceled when stop is closed.
+func runEvery(interval time.Duration, stop <-chan struct{}, run func(ctx context.Context)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-stop
+		cancel()
+	}()
+
+	for {
+		run(ctx)
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -1,12 +1,18 @@
 package zabbixsvc_test
 
 import (
+	"encoding/binary"
+	"encoding/json"
+	"io"
 	"net"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
 
+	"github.com/neogan74/zabbix-alertmanager/zabbixprovisioner/provisioner"
+	zabbix "github.com/neogan74/zabbix-alertmanager/zabbixprovisioner/zabbixclient"
+	"github.com/neogan74/zabbix-alertmanager/zabbixsender/zabbixsnd"
 	"github.com/neogan74/zabbix-alertmanager/zabbixsender/zabbixsvc"
 	log "github.com/sirupsen/logrus"
 )
@@ -279,3 +285,110 @@ func TestJSONHandlerInternal(t *testing.T) {
 	}
 
 }
+
+//zabbixHandler answers a Zabbix API method with the result for the params, a *zabbix.Error is sent as the error
+type zabbixHandler func(params map[string]interface{}) interface{}
+
+//newFakeZabbix Zabbix API answering the methods with their handlers, the other methods return an empty list
+func newFakeZabbix(t *testing.T, handlers map[string]zabbixHandler) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Method string                 `json:"method"`
+			Params map[string]interface{} `json:"params"`
+			ID     int                    `json:"id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("unexpected request: %v", err)
+		}
+		var result interface{} = []interface{}{}
+		if handler, ok := handlers[req.Method]; ok {
+			result = handler(req.Params)
+		}
+		if e, ok := result.(*zabbix.Error); ok {
+			json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "error": e, "id": req.ID})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "result": result, "id": req.ID})
+	}))
+}
+
+//fakeTrapper Zabbix trapper accepting one packet, its data is sent to the channel
+func fakeTrapper(t *testing.T) (string, <-chan []map[string]interface{}) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := make(chan []map[string]interface{}, 1)
+	go func() {
+		defer l.Close()
+		conn, err := l.Accept()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+
+		header := make([]byte, 13)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			t.Error(err)
+			return
+		}
+		body := make([]byte, binary.LittleEndian.Uint64(header[5:]))
+		if _, err := io.ReadFull(conn, body); err != nil {
+			t.Error(err)
+			return
+		}
+		var packet struct {
+			Data []map[string]interface{} `json:"data"`
+		}
+		json.Unmarshal(body, &packet)
+		received <- packet.Data
+		conn.Write([]byte("ZBXD\x01Z\x00\x00\x00\x00\x00\x00\x00{\"response\":\"success\",\"info\":\"processed: 1; failed: 0; total: 1; seconds spent: 0.000041\"}"))
+	}()
+	return l.Addr().String(), received
+}
+
+func TestJSONHandlerHostConfig(t *testing.T) {
+	addr, received := fakeTrapper(t)
+	s, err := zabbixsnd.New(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The config is named after the template, the alerts are pushed to the host linked to it
+	hostConfigs, err := zabbixsvc.HostConfigsByHost([]provisioner.HostConfig{{
+		Name:            "Template Prometheus",
+		Hosts:           []string{"prometheus"},
+		ItemKey:         provisioner.ItemKeyConfig{Strategy: provisioner.KeyStrategyLabels, Labels: []string{"job"}},
+		SeverityMapping: provisioner.SeverityMapping{Values: map[string]string{"critical": "high"}, Dynamic: true},
+	}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	h := &zabbixsvc.JSONHandler{
+		Sender:      s,
+		KeyPrefix:   "prometheus",
+		DefaultHost: "prometheus",
+		HostConfigs: hostConfigs,
+	}
+
+	rr := httptest.NewRecorder()
+	h.HandlePost(rr, httptest.NewRequest("POST", "/alerts", strings.NewReader(alertInternal)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	data := <-received
+	if len(data) != 1 || data[0]["host"] != "prometheus" || data[0]["key"] != "prometheus.instancedown.node_exporter.high" {
+		t.Errorf("expected the key of the template config, got %v", data)
+	}
+}
+
+func TestHostConfigsByHostDuplicate(t *testing.T) {
+	_, err := zabbixsvc.HostConfigsByHost([]provisioner.HostConfig{
+		{Name: "Template A", Hosts: []string{"prometheus"}},
+		{Name: "Template B", Hosts: []string{"prometheus"}},
+	})
+	if err == nil {
+		t.Error("expected an error for a host listed by 2 configs")
+	}
+}